@@ -19,8 +19,12 @@ package v1
 import (
 	"fmt"
 	"regexp"
+	"sort"
+	"strings"
+	"time"
 
 	opsconstants "github.com/shaowenchen/ops/pkg/constants"
+	opsexpr "github.com/shaowenchen/ops/pkg/expr"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 )
@@ -35,6 +39,8 @@ type PipelineSpec struct {
 	Desc                    string    `json:"desc,omitempty" yaml:"desc,omitempty"`
 	Variables               Variables `json:"variables,omitempty" yaml:"variables,omitempty"`
 	Tasks                   []TaskRef `json:"tasks" yaml:"tasks"`
+	Finally                 []TaskRef `json:"finally,omitempty" yaml:"finally,omitempty"` // run one by one after tasks, whatever the outcome
+	Timeout                 string    `json:"timeout,omitempty" yaml:"timeout,omitempty"` // max duration of tasks, eg: 30m, finally tasks still run after it
 	RuntimeImage            string    `json:"runtimeImage,omitempty" yaml:"runtimeImage,omitempty"`
 	TTlSecondsAfterFinished int       `json:"ttlSecondsAfterFinished,omitempty" yaml:"ttlSecondsAfterFinished,omitempty"`
 }
@@ -43,11 +49,151 @@ type TaskRef struct {
 	// +kubebuilder:validation:Pattern="^[a-z](-?[a-z0-9])*$"
 	Name         string            `json:"name,omitempty" yaml:"name,omitempty"`
 	Desc         string            `json:"desc,omitempty" yaml:"desc,omitempty"`
+	When         string            `json:"when,omitempty" yaml:"when,omitempty"` // condition over variables and ${tasks.X.results.Y}, the task is skipped if false
 	TaskRef      string            `json:"taskRef,omitempty" yaml:"taskRef,omitempty"`
+	PipelineRef  string            `json:"pipelineRef,omitempty" yaml:"pipelineRef,omitempty"` // runs another pipeline as a child PipelineRun instead of a task
 	RuntimeImage string            `json:"runtimeImage,omitempty" yaml:"runtimeImage,omitempty"`
 	AllowFailure bool              `json:"allowFailure,omitempty" yaml:"allowFailure,omitempty"`
-	RunAlways    bool              `json:"runAlways,omitempty" yaml:"runAlways,omitempty"`
-	Results      map[string]string `json:"results,omitempty" yaml:"results,omitempty"` // map[resultKey]stepName, defines which step outputs to export as results
+	RunAlways    bool              `json:"runAlways,omitempty" yaml:"runAlways,omitempty"` // Deprecated: use finally
+	Results      map[string]string `json:"results,omitempty" yaml:"results,omitempty"`     // map[resultKey]stepName, defines which step outputs to export as results, map[resultKey]task.result for pipelineRef
+	RunAfter     []string          `json:"runAfter,omitempty" yaml:"runAfter,omitempty"`   // names of tasks that must finish before this task starts
+	// +kubebuilder:validation:Minimum=0
+	Retries int `json:"retries,omitempty" yaml:"retries,omitempty"` // times to run the task again after a failed attempt
+	// +kubebuilder:validation:Minimum=0
+	RetryBackoffSeconds int    `json:"retryBackoffSeconds,omitempty" yaml:"retryBackoffSeconds,omitempty"` // delay before the first retry, doubled after every attempt
+	RetryOn             string `json:"retryOn,omitempty" yaml:"retryOn,omitempty"`                         // regular expression over the output of the failed attempt, retry any failure if empty
+	Timeout             string `json:"timeout,omitempty" yaml:"timeout,omitempty"`                         // max duration of each attempt, eg: 10m
+	// map[variable]values, runs the task once per combination, a value can be a comma-separated list like ${hosts}
+	Matrix map[string][]string `json:"matrix,omitempty" yaml:"matrix,omitempty"`
+	// +kubebuilder:validation:Minimum=0
+	MaxParallel int `json:"maxParallel,omitempty" yaml:"maxParallel,omitempty"` // max combinations of matrix running at the same time, 0 means no limit
+	// a gate waiting for a human to approve or reject, instead of a task
+	Approval *TaskApproval `json:"approval,omitempty" yaml:"approval,omitempty"`
+}
+
+// TaskApproval is a manual approval gate inside a pipeline
+type TaskApproval struct {
+	Message   string   `json:"message,omitempty" yaml:"message,omitempty"`     // what is approved, sent with the event
+	Approvers []string `json:"approvers,omitempty" yaml:"approvers,omitempty"` // users allowed to approve or reject, any authenticated user if empty
+	Timeout   string   `json:"timeout,omitempty" yaml:"timeout,omitempty"`     // the gate fails if not decided in time, eg: 2h
+}
+
+// IsApprover returns true if the user is allowed to approve or reject
+func (a *TaskApproval) IsApprover(user string) bool {
+	if len(a.Approvers) == 0 {
+		return true
+	}
+	for _, approver := range a.Approvers {
+		if approver == user {
+			return true
+		}
+	}
+	return false
+}
+
+// GetName returns the name used to identify the task inside the pipeline, fallback to taskRef or pipelineRef
+func (t TaskRef) GetName() string {
+	if t.Name != "" {
+		return t.Name
+	}
+	return t.GetRef()
+}
+
+// GetRef returns the name of the referenced task, or the referenced pipeline
+func (t TaskRef) GetRef() string {
+	if t.PipelineRef != "" {
+		return t.PipelineRef
+	}
+	return t.TaskRef
+}
+
+// GetMatrixName returns the name of a combination of matrix, eg: deploy[0]
+func (t TaskRef) GetMatrixName(index int) string {
+	return fmt.Sprintf("%s[%d]", t.GetName(), index)
+}
+
+// GetMatrixCombinations returns every combination of matrix, values are rendered by render then split by comma
+func (t TaskRef) GetMatrixCombinations(render func(string) string) []map[string]string {
+	if len(t.Matrix) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(t.Matrix))
+	for k := range t.Matrix {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	combinations := []map[string]string{{}}
+	for _, k := range keys {
+		values := []string{}
+		for _, raw := range t.Matrix[k] {
+			for _, v := range strings.Split(render(raw), ",") {
+				if v = strings.TrimSpace(v); v != "" {
+					values = append(values, v)
+				}
+			}
+		}
+		next := make([]map[string]string, 0, len(combinations)*len(values))
+		for _, c := range combinations {
+			for _, v := range values {
+				combination := make(map[string]string, len(c)+1)
+				for ck, cv := range c {
+					combination[ck] = cv
+				}
+				combination[k] = v
+				next = append(next, combination)
+			}
+		}
+		combinations = next
+	}
+	return combinations
+}
+
+// GetRetryBackoff returns the delay before the attempt after the given one, capped by MaxRetryBackoffSeconds
+func (t TaskRef) GetRetryBackoff(attempt int) time.Duration {
+	if t.RetryBackoffSeconds <= 0 {
+		return 0
+	}
+	seconds := t.RetryBackoffSeconds
+	for i := 1; i < attempt && seconds < opsconstants.MaxRetryBackoffSeconds; i++ {
+		seconds *= 2
+	}
+	if seconds > opsconstants.MaxRetryBackoffSeconds {
+		seconds = opsconstants.MaxRetryBackoffSeconds
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// GetTimeout returns the max duration of each attempt, 0 means no limit
+func (t TaskRef) GetTimeout() time.Duration {
+	timeout, _ := ParseTimeout(t.Timeout)
+	return timeout
+}
+
+// ParseTimeout parses a timeout like 30m, 0 means no limit
+func ParseTimeout(timeout string) (time.Duration, error) {
+	if timeout == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(timeout)
+	if err != nil {
+		return 0, err
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("timeout %s must not be negative", timeout)
+	}
+	return d, nil
+}
+
+// ShouldRetryOn returns true if the output of a failed attempt matches RetryOn
+func (t TaskRef) ShouldRetryOn(output string) bool {
+	if t.RetryOn == "" {
+		return true
+	}
+	re, err := regexp.Compile(t.RetryOn)
+	if err != nil {
+		return false
+	}
+	return re.MatchString(output)
 }
 
 // PipelineStatus defines the observed state of Pipeline
@@ -155,7 +301,7 @@ func (obj *Pipeline) MergeVersion(merge *Pipeline) *Pipeline {
 // - Can contain lowercase letters, numbers and hyphens
 // - Must end with a letter or number (not a hyphen)
 func (obj *Pipeline) ValidateTaskNames() error {
-	for _, task := range obj.Spec.Tasks {
+	for _, task := range obj.GetAllTasks() {
 		if task.Name == "" {
 			continue
 		}
@@ -166,6 +312,268 @@ func (obj *Pipeline) ValidateTaskNames() error {
 	return nil
 }
 
+// GetAllTasks returns tasks followed by finally tasks
+func (obj *Pipeline) GetAllTasks() []TaskRef {
+	all := make([]TaskRef, 0, len(obj.Spec.Tasks)+len(obj.Spec.Finally))
+	all = append(all, obj.Spec.Tasks...)
+	return append(all, obj.Spec.Finally...)
+}
+
+// GetTaskNames returns the names of tasks followed by finally tasks, the state of a task in a PipelineRun is kept by its name
+// An unnamed task sharing the name of an earlier task is named with a suffix, eg: the second restart is restart-2
+func (obj *Pipeline) GetTaskNames() []string {
+	all := obj.GetAllTasks()
+	named := make(map[string]bool)
+	for _, task := range all {
+		if task.Name != "" {
+			named[task.Name] = true
+		}
+	}
+	names := make([]string, len(all))
+	seen := make(map[string]bool)
+	for i, task := range all {
+		name := task.GetName()
+		if task.Name == "" && seen[name] {
+			for n := 2; ; n++ {
+				if candidate := fmt.Sprintf("%s-%d", name, n); !seen[candidate] && !named[candidate] {
+					name = candidate
+					break
+				}
+			}
+		}
+		names[i] = name
+		seen[name] = true
+	}
+	return names
+}
+
+// SetDefaultTaskNames names the tasks by GetTaskNames, so that tasks running the same taskRef have their own state
+func (obj *Pipeline) SetDefaultTaskNames() {
+	names := obj.GetTaskNames()
+	for i := range obj.Spec.Tasks {
+		obj.Spec.Tasks[i].Name = names[i]
+	}
+	for i := range obj.Spec.Finally {
+		obj.Spec.Finally[i].Name = names[len(obj.Spec.Tasks)+i]
+	}
+}
+
+// IsGraph returns true if any task declares runAfter
+// Without runAfter, tasks run one by one in the order of the list
+func (obj *Pipeline) IsGraph() bool {
+	for _, task := range obj.Spec.Tasks {
+		if len(task.RunAfter) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// GetTaskRunAfter returns the names of the tasks that the task at index must wait for
+func (obj *Pipeline) GetTaskRunAfter(index int) []string {
+	if index < 0 || index >= len(obj.Spec.Tasks) {
+		return nil
+	}
+	if obj.IsGraph() {
+		return obj.Spec.Tasks[index].RunAfter
+	}
+	if index == 0 {
+		return nil
+	}
+	return []string{obj.Spec.Tasks[index-1].GetName()}
+}
+
+// GetTaskDependencies returns, for each task, the indexes of the tasks it must wait for
+// - Without runAfter, every task depends on the previous one
+// - With runAfter, tasks without runAfter start immediately
+// Returns an error if a task name is duplicated, runAfter references an unknown task, or the graph has a cycle
+func (obj *Pipeline) GetTaskDependencies() ([][]int, error) {
+	tasks := obj.Spec.Tasks
+	deps := make([][]int, len(tasks))
+	names := obj.GetTaskNames()
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if seen[name] {
+			return nil, fmt.Errorf("task name '%s' is duplicated, names of tasks and finally tasks must be unique", name)
+		}
+		seen[name] = true
+	}
+	if !obj.IsGraph() {
+		for i := 1; i < len(tasks); i++ {
+			deps[i] = []int{i - 1}
+		}
+		return deps, nil
+	}
+	indexes := make(map[string]int)
+	for i := range tasks {
+		indexes[names[i]] = i
+	}
+	for i, task := range tasks {
+		for _, after := range task.RunAfter {
+			j, ok := indexes[after]
+			if !ok {
+				return nil, fmt.Errorf("task '%s' runAfter unknown task '%s'", names[i], after)
+			}
+			if j == i {
+				return nil, fmt.Errorf("task '%s' can not runAfter itself", names[i])
+			}
+			deps[i] = append(deps[i], j)
+		}
+	}
+	// detect cycle by depth-first search
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	marks := make([]int, len(tasks))
+	path := []string{}
+	var visit func(i int) error
+	visit = func(i int) error {
+		marks[i] = visiting
+		path = append(path, names[i])
+		for _, j := range deps[i] {
+			if marks[j] == visiting {
+				cycle := path
+				for k, name := range path {
+					if name == names[j] {
+						cycle = path[k:]
+						break
+					}
+				}
+				return fmt.Errorf("runAfter cycle detected: %s -> %s", strings.Join(cycle, " -> "), names[j])
+			}
+			if marks[j] == unvisited {
+				if err := visit(j); err != nil {
+					return err
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		marks[i] = visited
+		return nil
+	}
+	for i := range tasks {
+		if marks[i] == unvisited {
+			if err := visit(i); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return deps, nil
+}
+
+// GetFinallyDependencies returns, for each finally task, the indexes of the finally tasks it must wait for
+// Finally tasks run one by one in the order of the list
+func (obj *Pipeline) GetFinallyDependencies() [][]int {
+	deps := make([][]int, len(obj.Spec.Finally))
+	for i := 1; i < len(obj.Spec.Finally); i++ {
+		deps[i] = []int{i - 1}
+	}
+	return deps
+}
+
+// GetFinallyRunAfter returns the name of the finally task that the finally task at index must wait for
+func (obj *Pipeline) GetFinallyRunAfter(index int) []string {
+	if index <= 0 || index >= len(obj.Spec.Finally) {
+		return nil
+	}
+	return []string{obj.Spec.Finally[index-1].GetName()}
+}
+
+// ValidateTaskGraph validates runAfter references and rejects cycles
+// Finally tasks can not use runAfter, the names of tasks and finally tasks must be unique
+// Retry settings and maxParallel must not be negative, retryOn must be a valid regular expression, when a valid expression and timeouts valid durations
+func (obj *Pipeline) ValidateTaskGraph() error {
+	if _, err := obj.GetTaskDependencies(); err != nil {
+		return err
+	}
+	if _, err := ParseTimeout(obj.Spec.Timeout); err != nil {
+		return fmt.Errorf("pipeline timeout is invalid: %v", err)
+	}
+	for _, task := range obj.GetAllTasks() {
+		if task.TaskRef != "" && task.PipelineRef != "" {
+			return fmt.Errorf("task '%s' can not set both taskRef and pipelineRef", task.GetName())
+		}
+		if task.Approval != nil {
+			if task.Name == "" || task.TaskRef != "" || task.PipelineRef != "" || len(task.Matrix) > 0 {
+				return fmt.Errorf("approval task '%s' must set name and can not set taskRef, pipelineRef or matrix", task.GetName())
+			}
+			if _, err := ParseTimeout(task.Approval.Timeout); err != nil {
+				return fmt.Errorf("task '%s' approval timeout is invalid: %v", task.GetName(), err)
+			}
+		}
+		if task.PipelineRef == obj.Name && obj.Name != "" {
+			return fmt.Errorf("task '%s' references the pipeline itself", task.GetName())
+		}
+		if task.Retries < 0 || task.RetryBackoffSeconds < 0 {
+			return fmt.Errorf("task '%s' retries and retryBackoffSeconds must not be negative", task.GetName())
+		}
+		if _, err := regexp.Compile(task.RetryOn); err != nil {
+			return fmt.Errorf("task '%s' retryOn is invalid: %v", task.GetName(), err)
+		}
+		if _, err := ParseTimeout(task.Timeout); err != nil {
+			return fmt.Errorf("task '%s' timeout is invalid: %v", task.GetName(), err)
+		}
+		if err := opsexpr.Validate(task.When); err != nil {
+			return fmt.Errorf("task '%s' when is invalid: %v", task.GetName(), err)
+		}
+		if task.MaxParallel < 0 {
+			return fmt.Errorf("task '%s' maxParallel must not be negative", task.GetName())
+		}
+		for k := range task.Matrix {
+			if strings.TrimSpace(k) == "" {
+				return fmt.Errorf("task '%s' matrix variable name must not be empty", task.GetName())
+			}
+		}
+	}
+	for _, task := range obj.Spec.Finally {
+		if len(task.RunAfter) > 0 {
+			return fmt.Errorf("finally task '%s' can not use runAfter", task.GetName())
+		}
+	}
+	return nil
+}
+
+// ValidatePipelineRefs checks the pipelines referenced by pipelineRef recursively, get returns a pipeline by name
+// Returns an error if the references have a cycle or are nested deeper than MaxPipelineDepth
+func (obj *Pipeline) ValidatePipelineRefs(get func(name string) (*Pipeline, error)) error {
+	return obj.validatePipelineRefs(get, []string{obj.Name})
+}
+
+func (obj *Pipeline) validatePipelineRefs(get func(name string) (*Pipeline, error), chain []string) error {
+	for _, task := range obj.GetAllTasks() {
+		if task.PipelineRef == "" {
+			continue
+		}
+		if err := CheckPipelineChain(chain, task.PipelineRef); err != nil {
+			return err
+		}
+		child, err := get(task.PipelineRef)
+		if err != nil {
+			return err
+		}
+		if err := child.validatePipelineRefs(get, append(chain[:len(chain):len(chain)], task.PipelineRef)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckPipelineChain returns an error if running pipeline under a chain of nested pipelines makes a cycle or exceeds MaxPipelineDepth
+func CheckPipelineChain(chain []string, pipeline string) error {
+	next := append(chain[:len(chain):len(chain)], pipeline)
+	for _, name := range chain {
+		if name == pipeline {
+			return fmt.Errorf("pipeline %s is referenced in a cycle: %s", pipeline, strings.Join(next, " -> "))
+		}
+	}
+	if len(next) > opsconstants.MaxPipelineDepth {
+		return fmt.Errorf("pipelines are nested deeper than %d: %s", opsconstants.MaxPipelineDepth, strings.Join(next, " -> "))
+	}
+	return nil
+}
+
 // isValidName checks if a name contains only lowercase letters, numbers and hyphens
 // Pattern: ^[a-z](-?[a-z0-9])*$
 // - Must start with a lowercase letter (not a number or hyphen)
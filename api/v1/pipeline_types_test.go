@@ -0,0 +1,226 @@
+/*
+Copyright 2022 shaowenchen.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGetTaskNames(t *testing.T) {
+	tests := []struct {
+		name    string
+		tasks   []TaskRef
+		finally []TaskRef
+		want    []string
+	}{
+		{
+			name:  "unnamed duplicates",
+			tasks: []TaskRef{{TaskRef: "restart"}, {TaskRef: "check"}, {TaskRef: "restart"}, {TaskRef: "restart"}},
+			want:  []string{"restart", "check", "restart-2", "restart-3"},
+		},
+		{
+			name:  "generated name used by a named task",
+			tasks: []TaskRef{{TaskRef: "restart"}, {TaskRef: "restart"}, {Name: "restart-2", TaskRef: "check"}},
+			want:  []string{"restart", "restart-3", "restart-2"},
+		},
+		{
+			name:    "finally repeats a task",
+			tasks:   []TaskRef{{TaskRef: "notify"}},
+			finally: []TaskRef{{TaskRef: "notify"}},
+			want:    []string{"notify", "notify-2"},
+		},
+		{
+			name:  "named duplicates are kept",
+			tasks: []TaskRef{{Name: "a", TaskRef: "restart"}, {Name: "a", TaskRef: "check"}},
+			want:  []string{"a", "a"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Pipeline{Spec: PipelineSpec{Tasks: tt.tasks, Finally: tt.finally}}
+			if got := p.GetTaskNames(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetTaskNames() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLinearPipelineRunsRepeatedTasks(t *testing.T) {
+	p := &Pipeline{Spec: PipelineSpec{Tasks: []TaskRef{{TaskRef: "restart"}, {TaskRef: "check"}, {TaskRef: "restart"}}}}
+	if _, err := p.GetTaskDependencies(); err != nil {
+		t.Fatalf("GetTaskDependencies() error = %v", err)
+	}
+	p.SetDefaultTaskNames()
+	pr := &PipelineRunStatus{}
+	for i, tRef := range p.Spec.Tasks {
+		pr.InitPipelineRunTaskStatus(tRef.GetName(), tRef.TaskRef, p.GetTaskRunAfter(i))
+	}
+	if len(pr.PipelineRunStatus) != 3 {
+		t.Fatalf("got %d task states, want 3", len(pr.PipelineRunStatus))
+	}
+	last := pr.PipelineRunStatus[2]
+	if last.TaskName != "restart-2" || !reflect.DeepEqual(last.RunAfter, []string{"check"}) {
+		t.Errorf("last task state = %s after %v, want restart-2 after [check]", last.TaskName, last.RunAfter)
+	}
+}
+
+func TestGetTaskDependenciesRejectsNamedDuplicates(t *testing.T) {
+	p := &Pipeline{Spec: PipelineSpec{Tasks: []TaskRef{{Name: "a", TaskRef: "restart"}, {Name: "a", TaskRef: "check"}}}}
+	_, err := p.GetTaskDependencies()
+	if err == nil || !strings.Contains(err.Error(), "duplicated") {
+		t.Errorf("GetTaskDependencies() error = %v, want duplicated", err)
+	}
+}
+
+func TestGetTaskDependencies(t *testing.T) {
+	tests := []struct {
+		name    string
+		tasks   []TaskRef
+		finally []TaskRef
+		want    [][]int
+		wantErr string
+	}{
+		{
+			name:  "linear",
+			tasks: []TaskRef{{Name: "a"}, {Name: "b"}, {Name: "c"}},
+			want:  [][]int{nil, {0}, {1}},
+		},
+		{
+			name:  "graph",
+			tasks: []TaskRef{{Name: "a"}, {Name: "b"}, {Name: "c", RunAfter: []string{"a", "b"}}, {Name: "d", RunAfter: []string{"c"}}},
+			want:  [][]int{nil, nil, {0, 1}, {2}},
+		},
+		{
+			name:  "runAfter a later task",
+			tasks: []TaskRef{{Name: "a", RunAfter: []string{"b"}}, {Name: "b"}},
+			want:  [][]int{{1}, nil},
+		},
+		{
+			name:  "runAfter an unnamed task",
+			tasks: []TaskRef{{TaskRef: "restart"}, {TaskRef: "restart", RunAfter: []string{"restart"}}},
+			want:  [][]int{nil, {0}},
+		},
+		{
+			name:    "unknown runAfter",
+			tasks:   []TaskRef{{Name: "a"}, {Name: "b", RunAfter: []string{"x"}}},
+			wantErr: "task 'b' runAfter unknown task 'x'",
+		},
+		{
+			name:    "runAfter itself",
+			tasks:   []TaskRef{{Name: "a", RunAfter: []string{"a"}}},
+			wantErr: "task 'a' can not runAfter itself",
+		},
+		{
+			name:    "runAfter a finally task",
+			tasks:   []TaskRef{{Name: "a", RunAfter: []string{"notify"}}},
+			finally: []TaskRef{{Name: "notify"}},
+			wantErr: "task 'a' runAfter unknown task 'notify'",
+		},
+		{
+			name:    "cycle",
+			tasks:   []TaskRef{{Name: "a", RunAfter: []string{"c"}}, {Name: "b", RunAfter: []string{"a"}}, {Name: "c", RunAfter: []string{"b"}}},
+			wantErr: "runAfter cycle detected: a -> c -> b -> a",
+		},
+		{
+			name:    "cycle after a root",
+			tasks:   []TaskRef{{Name: "root"}, {Name: "a", RunAfter: []string{"root", "b"}}, {Name: "b", RunAfter: []string{"a"}}},
+			wantErr: "runAfter cycle detected: a -> b -> a",
+		},
+		{
+			name:    "task and finally task with the same name",
+			tasks:   []TaskRef{{Name: "notify"}},
+			finally: []TaskRef{{Name: "notify"}},
+			wantErr: "task name 'notify' is duplicated",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Pipeline{Spec: PipelineSpec{Tasks: tt.tasks, Finally: tt.finally}}
+			got, err := p.GetTaskDependencies()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("GetTaskDependencies() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GetTaskDependencies() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetTaskDependencies() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateTaskGraph(t *testing.T) {
+	tests := []struct {
+		name    string
+		tasks   []TaskRef
+		finally []TaskRef
+		wantErr string
+	}{
+		{
+			name:    "finally tasks after tasks",
+			tasks:   []TaskRef{{Name: "a"}, {Name: "b", RunAfter: []string{"a"}}},
+			finally: []TaskRef{{Name: "cleanup"}, {Name: "notify"}},
+		},
+		{
+			name:    "finally task with runAfter",
+			tasks:   []TaskRef{{Name: "a"}},
+			finally: []TaskRef{{Name: "notify", RunAfter: []string{"a"}}},
+			wantErr: "finally task 'notify' can not use runAfter",
+		},
+		{
+			name:    "finally task with runAfter of a finally task",
+			finally: []TaskRef{{Name: "cleanup"}, {Name: "notify", RunAfter: []string{"cleanup"}}},
+			wantErr: "finally task 'notify' can not use runAfter",
+		},
+		{
+			name:    "duplicated finally tasks",
+			finally: []TaskRef{{Name: "notify"}, {Name: "notify"}},
+			wantErr: "task name 'notify' is duplicated",
+		},
+		{
+			name:    "unknown runAfter",
+			tasks:   []TaskRef{{Name: "a", RunAfter: []string{"x"}}},
+			wantErr: "runAfter unknown task 'x'",
+		},
+		{
+			name:    "cycle",
+			tasks:   []TaskRef{{Name: "a", RunAfter: []string{"b"}}, {Name: "b", RunAfter: []string{"a"}}},
+			wantErr: "runAfter cycle detected",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Pipeline{Spec: PipelineSpec{Tasks: tt.tasks, Finally: tt.finally}}
+			err := p.ValidateTaskGraph()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("ValidateTaskGraph() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ValidateTaskGraph() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -17,10 +17,12 @@ limitations under the License.
 package v1
 
 import (
+	"errors"
 	"fmt"
 	opsconstants "github.com/shaowenchen/ops/pkg/constants"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"strings"
 	"time"
 )
 
@@ -30,47 +32,283 @@ import (
 // PipelineRunSpec defines the desired state of PipelineRun
 type PipelineRunSpec struct {
 	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
-	Desc        string            `json:"desc,omitempty" yaml:"desc,omitempty"`
-	Crontab     string            `json:"crontab,omitempty" yaml:"crontab,omitempty"`
-	Variables   map[string]string `json:"variables,omitempty" yaml:"variables,omitempty"`
-	PipelineRef string            `json:"pipelineRef,omitempty" yaml:"pipelineRef,omitempty"`
+	Desc        string                  `json:"desc,omitempty" yaml:"desc,omitempty"`
+	Crontab     string                  `json:"crontab,omitempty" yaml:"crontab,omitempty"`
+	Variables   map[string]string       `json:"variables,omitempty" yaml:"variables,omitempty"`
+	PipelineRef string                  `json:"pipelineRef,omitempty" yaml:"pipelineRef,omitempty"`
+	Timeout     string                  `json:"timeout,omitempty" yaml:"timeout,omitempty"`         // overrides the timeout of the pipeline, eg: 30m
+	Cancelled   bool                    `json:"cancelled,omitempty" yaml:"cancelled,omitempty"`     // stop the run and its TaskRuns, it ends as Aborted
+	CancelledBy string                  `json:"cancelledBy,omitempty" yaml:"cancelledBy,omitempty"` // who cancelled the run
+	RerunFrom   string                  `json:"rerunFrom,omitempty" yaml:"rerunFrom,omitempty"`     // the task a rerun starts from
+	ReusedTasks []PipelineRunReusedTask `json:"reusedTasks,omitempty" yaml:"reusedTasks,omitempty"` // tasks succeeded in the original run, they are not run again
+	Approvals   []PipelineRunApproval   `json:"approvals,omitempty" yaml:"approvals,omitempty"`     // decisions on approval tasks
+	DryRun      bool                    `json:"dryRun,omitempty" yaml:"dryRun,omitempty"`           // plan the TaskRuns without running them, approvals are passed
+	// run a copy of the pipeline in every healthy Cluster matched, instead of the cluster variable
+	ClusterSelector *metav1.LabelSelector `json:"clusterSelector,omitempty" yaml:"clusterSelector,omitempty"`
+	// +kubebuilder:validation:Minimum=0
+	MaxParallelClusters int `json:"maxParallelClusters,omitempty" yaml:"maxParallelClusters,omitempty"` // clusters running at the same time, all at once if 0
+	// +kubebuilder:validation:Minimum=0
+	MaxFailedClusters *int32 `json:"maxFailedClusters,omitempty" yaml:"maxFailedClusters,omitempty"` // the clusters not started yet are skipped once more clusters failed
+	ScheduleSpec      `json:",inline" yaml:",inline"`
+}
+
+// PipelineRunApproval is a decision on an approval task
+type PipelineRunApproval struct {
+	Task     string `json:"task" yaml:"task"`
+	Decision string `json:"decision" yaml:"decision"` // Approved or Rejected
+	Approver string `json:"approver,omitempty" yaml:"approver,omitempty"`
+	Comment  string `json:"comment,omitempty" yaml:"comment,omitempty"`
+}
+
+// GetApproval returns the first decision on an approval task made by an allowed approver, nil if not decided
+func (s *PipelineRunSpec) GetApproval(taskName string, approval *TaskApproval) *PipelineRunApproval {
+	for i := range s.Approvals {
+		if s.Approvals[i].Task != taskName || !approval.IsApprover(s.Approvals[i].Approver) {
+			continue
+		}
+		if s.Approvals[i].Decision == opsconstants.ApprovalApproved || s.Approvals[i].Decision == opsconstants.ApprovalRejected {
+			return &s.Approvals[i]
+		}
+	}
+	return nil
+}
+
+// PipelineRunReusedTask is a task succeeded in the original run of a rerun
+type PipelineRunReusedTask struct {
+	Name    string            `json:"name" yaml:"name"`
+	Results map[string]string `json:"results,omitempty" yaml:"results,omitempty"`
+}
+
+// GetReusedTask returns the reused task by name, nil if the task should run
+func (s *PipelineRunSpec) GetReusedTask(taskName string) *PipelineRunReusedTask {
+	for i := range s.ReusedTasks {
+		if s.ReusedTasks[i].Name == taskName {
+			return &s.ReusedTasks[i]
+		}
+	}
+	return nil
+}
+
+// GetCancelledReason returns the reason recorded when the run is cancelled
+func (s *PipelineRunSpec) GetCancelledReason() string {
+	return getCancelledReason(s.CancelledBy)
+}
+
+// ErrNotApprover is returned when a user can not decide an approval task
+var ErrNotApprover = errors.New("not an approver")
+
+// AddApproval records the decision of an approver on an approval task waiting for it
+// The approver is required, an approval task without approvers can be decided by any user
+func (obj *PipelineRun) AddApproval(p *Pipeline, taskName, decision, approver, comment string) error {
+	if decision != opsconstants.ApprovalApproved && decision != opsconstants.ApprovalRejected {
+		return fmt.Errorf("decision %s is invalid", decision)
+	}
+	var approval *TaskApproval
+	for _, tRef := range p.GetAllTasks() {
+		if tRef.GetName() == taskName {
+			approval = tRef.Approval
+		}
+	}
+	if approval == nil {
+		return fmt.Errorf("task %s is not an approval task of pipeline %s", taskName, p.Name)
+	}
+	ts := obj.Status.GetPipelineRunTaskStatus(taskName)
+	if ts == nil || ts.RunStatus != opsconstants.StatusWaitingApproval {
+		return fmt.Errorf("task %s is not waiting for approval", taskName)
+	}
+	if approver == "" {
+		return fmt.Errorf("approver of task %s is required: %w", taskName, ErrNotApprover)
+	}
+	if !approval.IsApprover(approver) {
+		return fmt.Errorf("user %s is not allowed to approve task %s: %w", approver, taskName, ErrNotApprover)
+	}
+	obj.Spec.Approvals = append(obj.Spec.Approvals, PipelineRunApproval{
+		Task:     taskName,
+		Decision: decision,
+		Approver: approver,
+		Comment:  comment,
+	})
+	return nil
 }
 
 // PipelineRunStatus defines the observed state of PipelineRun
 type PipelineRunStatus struct {
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
-	PipelineRunStatus []PipelineRunTaskStatus `json:"pipelineRunStatus,omitempty" yaml:"pipelineRunStatus,omitempty"`
-	RunStatus         string                  `json:"runStatus,omitempty" yaml:"runStatus,omitempty"`
-	StartTime         *metav1.Time            `json:"startTime,omitempty" yaml:"startTime,omitempty"`
+	PipelineRunStatus []PipelineRunTaskStatus    `json:"pipelineRunStatus,omitempty" yaml:"pipelineRunStatus,omitempty"`
+	RunStatus         string                     `json:"runStatus,omitempty" yaml:"runStatus,omitempty"`
+	Reason            string                     `json:"reason,omitempty" yaml:"reason,omitempty"` // why the run stopped, eg: cancelled by admin
+	StartTime         *metav1.Time               `json:"startTime,omitempty" yaml:"startTime,omitempty"`
+	Env               map[string]string          `json:"env,omitempty" yaml:"env,omitempty"`                     // variables generated once when the run starts, eg: TIME
+	Dispatch          *PipelineRunDispatch       `json:"dispatch,omitempty" yaml:"dispatch,omitempty"`           // the PipelineRun sent to another cluster
+	ClusterStatus     []PipelineRunClusterStatus `json:"clusterStatus,omitempty" yaml:"clusterStatus,omitempty"` // the run in each cluster matched by clusterSelector
+	Conditions        []metav1.Condition         `json:"conditions,omitempty" yaml:"conditions,omitempty"`
+	ScheduleStatus    `json:",inline" yaml:",inline"`
+}
+
+// PipelineRunClusterStatus is the PipelineRun created for a cluster matched by clusterSelector
+type PipelineRunClusterStatus struct {
+	Cluster     string       `json:"cluster" yaml:"cluster"`
+	PipelineRun string       `json:"pipelineRun,omitempty" yaml:"pipelineRun,omitempty"`
+	RunStatus   string       `json:"runStatus,omitempty" yaml:"runStatus,omitempty"`
+	Reason      string       `json:"reason,omitempty" yaml:"reason,omitempty"`
+	StartTime   *metav1.Time `json:"startTime,omitempty" yaml:"startTime,omitempty"`
+	// Results are the results of the tasks of the PipelineRun in this cluster as map[task.result]value
+	Results map[string]string `json:"results,omitempty" yaml:"results,omitempty"`
+}
+
+// PipelineRunDispatch records the PipelineRun sent to another cluster, its status is synced from there
+type PipelineRunDispatch struct {
+	Cluster      string       `json:"cluster,omitempty" yaml:"cluster,omitempty"`
+	RemoteUID    string       `json:"remoteUID,omitempty" yaml:"remoteUID,omitempty"`
+	LastSyncTime *metav1.Time `json:"lastSyncTime,omitempty" yaml:"lastSyncTime,omitempty"`
 }
 
 func (pr *PipelineRunStatus) AddPipelineRunTaskStatus(taskName string, taskRef string, taskRunStatus *TaskRunStatus) {
 	if taskName == "" || taskRef == "" || taskRunStatus == nil {
 		return
 	}
+	runStatus := taskRunStatus.RunStatus
+	if runStatus == opsconstants.StatusEmpty {
+		runStatus = opsconstants.StatusRunning
+	}
 	found := false
 	for i, task := range pr.PipelineRunStatus {
 		if task.TaskName == taskName && task.TaskRef == taskRef {
 			found = true
 			pr.PipelineRunStatus[i].TaskRunStatus = taskRunStatus
+			pr.PipelineRunStatus[i].RunStatus = runStatus
 		}
 	}
 	if !found {
 		pr.PipelineRunStatus = append(pr.PipelineRunStatus, PipelineRunTaskStatus{
 			TaskName:      taskName,
 			TaskRef:       taskRef,
+			RunStatus:     runStatus,
 			TaskRunStatus: taskRunStatus,
 		})
 	}
 	return
 }
 
+// InitPipelineRunTaskStatus adds a pending task to the graph if it is not recorded yet
+func (pr *PipelineRunStatus) InitPipelineRunTaskStatus(taskName string, taskRef string, runAfter []string) {
+	if taskName == "" {
+		return
+	}
+	for _, task := range pr.PipelineRunStatus {
+		if task.TaskName == taskName && task.TaskRef == taskRef {
+			return
+		}
+	}
+	pr.PipelineRunStatus = append(pr.PipelineRunStatus, PipelineRunTaskStatus{
+		TaskName:  taskName,
+		TaskRef:   taskRef,
+		RunAfter:  runAfter,
+		RunStatus: opsconstants.StatusPending,
+	})
+}
+
+// InitPipelineRunMatrixStatus adds a pending combination of a matrix task if it is not recorded yet
+func (pr *PipelineRunStatus) InitPipelineRunMatrixStatus(parent string, taskName string, taskRef string, matrix map[string]string) {
+	if taskName == "" || pr.GetPipelineRunTaskStatus(taskName) != nil {
+		return
+	}
+	pr.PipelineRunStatus = append(pr.PipelineRunStatus, PipelineRunTaskStatus{
+		TaskName:  taskName,
+		TaskRef:   taskRef,
+		RunStatus: opsconstants.StatusPending,
+		Parent:    parent,
+		Matrix:    matrix,
+	})
+}
+
+// SetPipelineRunTaskState sets the state of a task in the graph and why, adds the task if it is not recorded yet
+func (pr *PipelineRunStatus) SetPipelineRunTaskState(taskName string, taskRef string, runStatus string, reason string) {
+	if taskName == "" {
+		return
+	}
+	for i, task := range pr.PipelineRunStatus {
+		if task.TaskName == taskName && task.TaskRef == taskRef {
+			pr.PipelineRunStatus[i].RunStatus = runStatus
+			pr.PipelineRunStatus[i].Reason = reason
+			return
+		}
+	}
+	pr.PipelineRunStatus = append(pr.PipelineRunStatus, PipelineRunTaskStatus{
+		TaskName:  taskName,
+		TaskRef:   taskRef,
+		RunStatus: runStatus,
+		Reason:    reason,
+	})
+}
+
+// SetPipelineRunTaskAttempt records an attempt of a task, replaces the attempt with the same number
+func (pr *PipelineRunStatus) SetPipelineRunTaskAttempt(taskName string, taskRef string, attempt PipelineRunTaskAttempt) {
+	if taskName == "" {
+		return
+	}
+	task := pr.GetPipelineRunTaskStatus(taskName)
+	if task == nil {
+		pr.PipelineRunStatus = append(pr.PipelineRunStatus, PipelineRunTaskStatus{
+			TaskName: taskName,
+			TaskRef:  taskRef,
+		})
+		task = &pr.PipelineRunStatus[len(pr.PipelineRunStatus)-1]
+	}
+	for i := range task.Attempts {
+		if task.Attempts[i].Attempt == attempt.Attempt {
+			if attempt.StartTime == nil {
+				attempt.StartTime = task.Attempts[i].StartTime
+			}
+			task.Attempts[i] = attempt
+			return
+		}
+	}
+	task.Attempts = append(task.Attempts, attempt)
+}
+
+// GetPipelineRunTaskStatus returns the recorded status of a task by name
+func (pr *PipelineRunStatus) GetPipelineRunTaskStatus(taskName string) *PipelineRunTaskStatus {
+	for i := range pr.PipelineRunStatus {
+		if pr.PipelineRunStatus[i].TaskName == taskName {
+			return &pr.PipelineRunStatus[i]
+		}
+	}
+	return nil
+}
+
 type PipelineRunTaskStatus struct {
-	TaskName      string            `json:"name,omitempty" yaml:"name,omitempty"`
-	TaskRef       string            `json:"taskRef,omitempty" yaml:"taskRef,omitempty"`
-	TaskRunStatus *TaskRunStatus    `json:"taskRunStatus,omitempty" yaml:"taskRunStatus,omitempty"`
-	Results       map[string]string `json:"results,omitempty" yaml:"results,omitempty"` // exported results from this task
+	TaskName      string                   `json:"name,omitempty" yaml:"name,omitempty"`
+	TaskRef       string                   `json:"taskRef,omitempty" yaml:"taskRef,omitempty"`
+	RunAfter      []string                 `json:"runAfter,omitempty" yaml:"runAfter,omitempty"`   // tasks this task waits for in the graph
+	RunStatus     string                   `json:"runStatus,omitempty" yaml:"runStatus,omitempty"` // state of this task in the graph
+	Reason        string                   `json:"reason,omitempty" yaml:"reason,omitempty"`       // why the task is in this state, eg: skipped by when
+	TaskRunStatus *TaskRunStatus           `json:"taskRunStatus,omitempty" yaml:"taskRunStatus,omitempty"`
+	Results       map[string]string        `json:"results,omitempty" yaml:"results,omitempty"`   // exported results from this task, a TaskRun on multiple nodes also has key.node for each node
+	Attempts      []PipelineRunTaskAttempt `json:"attempts,omitempty" yaml:"attempts,omitempty"` // one entry per TaskRun created for this task
+	Parent        string                   `json:"parent,omitempty" yaml:"parent,omitempty"`     // name of the matrix task this combination belongs to
+	Matrix        map[string]string        `json:"matrix,omitempty" yaml:"matrix,omitempty"`     // variables of this combination
+	Approval      *PipelineRunTaskApproval `json:"approval,omitempty" yaml:"approval,omitempty"` // state of an approval task
+}
+
+// PipelineRunTaskApproval records who decided an approval task and when
+type PipelineRunTaskApproval struct {
+	StartTime  *metav1.Time `json:"startTime,omitempty" yaml:"startTime,omitempty"`
+	Decision   string       `json:"decision,omitempty" yaml:"decision,omitempty"`
+	Approver   string       `json:"approver,omitempty" yaml:"approver,omitempty"`
+	Comment    string       `json:"comment,omitempty" yaml:"comment,omitempty"`
+	DecideTime *metav1.Time `json:"decideTime,omitempty" yaml:"decideTime,omitempty"`
+}
+
+type PipelineRunTaskAttempt struct {
+	Attempt     int          `json:"attempt" yaml:"attempt"`
+	TaskRun     string       `json:"taskRun,omitempty" yaml:"taskRun,omitempty"`
+	PipelineRun string       `json:"pipelineRun,omitempty" yaml:"pipelineRun,omitempty"` // child PipelineRun of a task referencing a pipeline
+	RunStatus   string       `json:"runStatus,omitempty" yaml:"runStatus,omitempty"`
+	Reason      string       `json:"reason,omitempty" yaml:"reason,omitempty"`
+	StartTime   *metav1.Time `json:"startTime,omitempty" yaml:"startTime,omitempty"`
+	FinishTime  *metav1.Time `json:"finishTime,omitempty" yaml:"finishTime,omitempty"` // when the TaskRun is seen finished, retry backoff starts from it
 }
 
 // +kubebuilder:object:root=true
@@ -95,6 +333,16 @@ func (obj *PipelineRun) GetUniqueKey() string {
 	}.String()
 }
 
+// GetTimeout returns the max duration of tasks, the timeout of PipelineRun takes precedence over Pipeline
+func (obj *PipelineRun) GetTimeout(p *Pipeline) time.Duration {
+	timeout := obj.Spec.Timeout
+	if timeout == "" && p != nil {
+		timeout = p.Spec.Timeout
+	}
+	d, _ := ParseTimeout(timeout)
+	return d
+}
+
 func (obj *PipelineRun) GetCluster() string {
 	if obj.Spec.Variables == nil {
 		return ""
@@ -123,11 +371,20 @@ func (obj *PipelineRun) CopyWithOutVersion() *PipelineRun {
 	}
 }
 
+// SetEnv adds the generated variables, eg: TIME, to the variables of spec
+// They are generated once and kept in status.env, so every reconcile and a resumed run render the same values
 func (obj *PipelineRun) SetEnv() *PipelineRun {
+	if obj.Status.Env == nil {
+		obj.Status.Env = map[string]string{
+			"TIME": fmt.Sprintf("%d", time.Now().UnixMicro()),
+		}
+	}
 	if obj.Spec.Variables == nil {
 		obj.Spec.Variables = make(map[string]string)
 	}
-	obj.Spec.Variables["TIME"] = fmt.Sprintf("%d", time.Now().UnixMicro())
+	for k, v := range obj.Status.Env {
+		obj.Spec.Variables[k] = v
+	}
 	return obj
 }
 
@@ -152,18 +409,180 @@ func NewPipelineRun(p *Pipeline) *PipelineRun {
 		pr.Spec.Variables[k] = v.GetValue()
 	}
 	// fill owner ref
-	if p.UID != "" {
-		pr.OwnerReferences = []metav1.OwnerReference{
-			{
-				APIVersion: opsconstants.APIVersion,
-				Kind:       opsconstants.Pipeline,
-				Name:       p.Name,
-				UID:        p.UID,
+	pr.OwnerReferences = newPipelineOwnerReferences(p)
+	// validate
+	return &pr
+}
+
+// newPipelineOwnerReferences returns the owner reference of a PipelineRun to its Pipeline, it is not a controller
+func newPipelineOwnerReferences(p *Pipeline) []metav1.OwnerReference {
+	if p.UID == "" {
+		return nil
+	}
+	return []metav1.OwnerReference{
+		{
+			APIVersion: opsconstants.APIVersion,
+			Kind:       opsconstants.Pipeline,
+			Name:       p.Name,
+			UID:        p.UID,
+		},
+	}
+}
+
+// NewPipelineRunWithPipelineRun returns the child PipelineRun of a task referencing a pipeline, it is controlled by the parent
+func NewPipelineRunWithPipelineRun(parent *PipelineRun, p *Pipeline, tRef TaskRef) *PipelineRun {
+	isController := true
+	pr := NewPipelineRun(p)
+	pr.GenerateName = ""
+	pr.Namespace = parent.Namespace
+	pr.Labels[opsconstants.LabelPipelineRunKey] = parent.Name
+	pr.Labels[opsconstants.LabelPipelineTaskKey] = tRef.GetName()
+	pr.Labels[opsconstants.LabelAttemptKey] = "1"
+	pr.Annotations = map[string]string{
+		opsconstants.AnnotationPipelineChainKey: strings.Join(append(parent.GetPipelineChain(), p.Name), ","),
+	}
+	pr.OwnerReferences = []metav1.OwnerReference{
+		{
+			APIVersion: opsconstants.APIVersion,
+			Kind:       opsconstants.PipelineRun,
+			Name:       parent.Name,
+			UID:        parent.UID,
+			Controller: &isController,
+		},
+	}
+	pr.Spec.Desc = tRef.Desc
+	pr.Spec.DryRun = parent.Spec.DryRun
+	return pr
+}
+
+// NewPipelineRunForCluster returns the PipelineRun of a PipelineRun with clusterSelector for one of the clusters
+func NewPipelineRunForCluster(parent *PipelineRun, cluster string) *PipelineRun {
+	isController := true
+	pr := &PipelineRun{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s-%s", parent.Name, cluster),
+			Namespace: parent.Namespace,
+			Labels: map[string]string{
+				opsconstants.LabelPipelineRefKey: parent.Spec.PipelineRef,
+				opsconstants.LabelPipelineRunKey: parent.Name,
+				opsconstants.LabelClusterKey:     cluster,
+			},
+			OwnerReferences: []metav1.OwnerReference{
+				{
+					APIVersion: opsconstants.APIVersion,
+					Kind:       opsconstants.PipelineRun,
+					Name:       parent.Name,
+					UID:        parent.UID,
+					Controller: &isController,
+				},
 			},
+		},
+		Spec: PipelineRunSpec{
+			Desc:        parent.Spec.Desc,
+			PipelineRef: parent.Spec.PipelineRef,
+			Timeout:     parent.Spec.Timeout,
+			DryRun:      parent.Spec.DryRun,
+			Variables:   make(map[string]string),
+		},
+	}
+	for k, v := range parent.Spec.Variables {
+		pr.Spec.Variables[k] = v
+	}
+	pr.Spec.Variables[opsconstants.ClusterLower] = cluster
+	return pr
+}
+
+// GetPipelineChain returns the pipelines from the root PipelineRun to this one, for nested pipelines
+func (obj *PipelineRun) GetPipelineChain() []string {
+	if chain := obj.Annotations[opsconstants.AnnotationPipelineChainKey]; chain != "" {
+		return strings.Split(chain, ",")
+	}
+	return []string{obj.Spec.PipelineRef}
+}
+
+// GetOutput returns the output of all tasks
+func (pr *PipelineRunStatus) GetOutput() string {
+	var output strings.Builder
+	for _, ts := range pr.PipelineRunStatus {
+		if ts.TaskRunStatus != nil {
+			output.WriteString(ts.TaskRunStatus.GetOutput())
 		}
 	}
-	// validate
-	return &pr
+	return output.String()
+}
+
+// NewRerunPipelineRun returns a PipelineRun that runs the pipeline again from a task of the original run
+// The tasks succeeded in the original run are reused with their results, except the task and the tasks depending on it
+func NewRerunPipelineRun(origin *PipelineRun, p *Pipeline, rerunFrom string) (*PipelineRun, error) {
+	if !opsconstants.IsFinishedStatus(origin.Status.RunStatus) {
+		return nil, fmt.Errorf("pipelinerun %s is not finished", origin.Name)
+	}
+	names := p.GetTaskNames()
+	from := -1
+	for i := range p.Spec.Tasks {
+		if names[i] == rerunFrom {
+			from = i
+		}
+	}
+	if from < 0 {
+		return nil, fmt.Errorf("task %s not found in pipeline %s", rerunFrom, p.Name)
+	}
+	deps, err := p.GetTaskDependencies()
+	if err != nil {
+		return nil, err
+	}
+	// the task and the tasks depending on it run again
+	rerun := map[int]bool{from: true}
+	for changed := true; changed; {
+		changed = false
+		for i := range deps {
+			if rerun[i] {
+				continue
+			}
+			for _, j := range deps[i] {
+				if rerun[j] {
+					rerun[i] = true
+					changed = true
+					break
+				}
+			}
+		}
+	}
+	pr := &PipelineRun{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: p.Name + "-",
+			Namespace:    origin.Namespace,
+			Labels:       map[string]string{opsconstants.LabelPipelineRefKey: origin.Spec.PipelineRef},
+			Annotations:  map[string]string{opsconstants.AnnotationRerunOfKey: origin.Name},
+			// the owner references of origin are not copied, a rerun of a child is not controlled by its parent
+			OwnerReferences: newPipelineOwnerReferences(p),
+		},
+		Spec: PipelineRunSpec{
+			Desc:        origin.Spec.Desc,
+			PipelineRef: origin.Spec.PipelineRef,
+			Variables:   make(map[string]string),
+			Timeout:     origin.Spec.Timeout,
+			RerunFrom:   rerunFrom,
+		},
+	}
+	for k, v := range origin.Spec.Variables {
+		pr.Spec.Variables[k] = v
+	}
+	for i := range p.Spec.Tasks {
+		ts := origin.Status.GetPipelineRunTaskStatus(names[i])
+		if rerun[i] || ts == nil || ts.RunStatus != opsconstants.StatusSuccessed {
+			continue
+		}
+		reused := PipelineRunReusedTask{Name: names[i]}
+		if len(ts.Results) > 0 {
+			reused.Results = make(map[string]string)
+			for k, v := range ts.Results {
+				reused.Results[k] = v
+			}
+		}
+		pr.Spec.ReusedTasks = append(pr.Spec.ReusedTasks, reused)
+	}
+	return pr, nil
 }
 
 //+kubebuilder:object:root=true
@@ -0,0 +1,94 @@
+/*
+Copyright 2022 shaowenchen.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"errors"
+	"testing"
+
+	opsconstants "github.com/shaowenchen/ops/pkg/constants"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSetEnvKeepsGeneratedValues(t *testing.T) {
+	pr := &PipelineRun{}
+	pr.SetEnv()
+	generated := pr.Spec.Variables["TIME"]
+	if generated == "" || pr.Status.Env["TIME"] != generated {
+		t.Fatalf("SetEnv() TIME = %q, status env = %v", generated, pr.Status.Env)
+	}
+	// a later reconcile reads the stored value, eg: after the status is written and read back
+	later := &PipelineRun{Status: PipelineRunStatus{Env: pr.Status.Env}}
+	later.Spec.Variables = map[string]string{"TIME": "0", "name": "ops"}
+	later.SetEnv()
+	if later.Spec.Variables["TIME"] != generated || later.Spec.Variables["name"] != "ops" {
+		t.Errorf("SetEnv() of a started run = %v, want TIME %s", later.Spec.Variables, generated)
+	}
+}
+
+func TestNewRerunPipelineRunIsNotControlledByParent(t *testing.T) {
+	isController := true
+	p := &Pipeline{Spec: PipelineSpec{Tasks: []TaskRef{{Name: "a"}}}}
+	p.Name, p.UID = "deploy", "pipeline-uid"
+	origin := &PipelineRun{Spec: PipelineRunSpec{PipelineRef: "deploy"}, Status: PipelineRunStatus{RunStatus: opsconstants.StatusFailed}}
+	origin.Name, origin.Namespace = "deploy-child", "ops-system"
+	origin.OwnerReferences = []metav1.OwnerReference{
+		{APIVersion: opsconstants.APIVersion, Kind: opsconstants.PipelineRun, Name: "parent", UID: "parent-uid", Controller: &isController},
+		{APIVersion: opsconstants.APIVersion, Kind: opsconstants.Pipeline, Name: "deploy", UID: "pipeline-uid"},
+	}
+	pr, err := NewRerunPipelineRun(origin, p, "a")
+	if err != nil {
+		t.Fatalf("NewRerunPipelineRun() error = %v", err)
+	}
+	if len(pr.OwnerReferences) != 1 {
+		t.Fatalf("owner references = %v, want the pipeline only", pr.OwnerReferences)
+	}
+	ref := pr.OwnerReferences[0]
+	if ref.Kind != opsconstants.Pipeline || ref.UID != p.UID || (ref.Controller != nil && *ref.Controller) {
+		t.Errorf("owner reference = %+v, want the pipeline, not a controller", ref)
+	}
+	if metav1.GetControllerOf(pr) != nil {
+		t.Errorf("rerun is controlled by %v", metav1.GetControllerOf(pr))
+	}
+}
+
+func TestAddApprovalRequiresApprover(t *testing.T) {
+	p := &Pipeline{Spec: PipelineSpec{Tasks: []TaskRef{{Name: "confirm", Approval: &TaskApproval{Approvers: []string{"admin"}}}}}}
+	tests := []struct {
+		name     string
+		approver string
+		wantErr  error
+	}{
+		{"approver", "admin", nil},
+		{"no user", "", ErrNotApprover},
+		{"not an approver", "guest", ErrNotApprover},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pr := &PipelineRun{}
+			pr.Status.InitPipelineRunTaskStatus("confirm", "", nil)
+			pr.Status.GetPipelineRunTaskStatus("confirm").RunStatus = opsconstants.StatusWaitingApproval
+			err := pr.AddApproval(p, "confirm", opsconstants.ApprovalApproved, tt.approver, "")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("AddApproval() error = %v, want %v", err, tt.wantErr)
+			}
+			if recorded := len(pr.Spec.Approvals) == 1 && pr.Spec.Approvals[0].Approver == tt.approver; recorded != (tt.wantErr == nil) {
+				t.Errorf("AddApproval() approvals = %+v", pr.Spec.Approvals)
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2024 shaowenchen.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package v1
+
+import (
+	"time"
+
+	opsconstants "github.com/shaowenchen/ops/pkg/constants"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ScheduleSpec controls the runs created by crontab, like a CronJob
+type ScheduleSpec struct {
+	// +kubebuilder:validation:Enum=Allow;Forbid;Replace
+	ConcurrencyPolicy string `json:"concurrencyPolicy,omitempty" yaml:"concurrencyPolicy,omitempty"` // what to do if the last run is not finished, Forbid by default
+	// +kubebuilder:validation:Minimum=0
+	SuccessfulRunsHistoryLimit *int32 `json:"successfulRunsHistoryLimit,omitempty" yaml:"successfulRunsHistoryLimit,omitempty"` // successful runs to keep, removed after a TTL if not set
+	// +kubebuilder:validation:Minimum=0
+	FailedRunsHistoryLimit *int32 `json:"failedRunsHistoryLimit,omitempty" yaml:"failedRunsHistoryLimit,omitempty"` // failed runs to keep, removed after a TTL if not set
+	// +kubebuilder:validation:Minimum=0
+	StartingDeadlineSeconds *int64 `json:"startingDeadlineSeconds,omitempty" yaml:"startingDeadlineSeconds,omitempty"` // a missed schedule still runs if late less than it, eg: while the controller restarts
+	Suspend                 bool   `json:"suspend,omitempty" yaml:"suspend,omitempty"`                                 // skip the schedules without removing the crontab
+	TimeZone                string `json:"timeZone,omitempty" yaml:"timeZone,omitempty"`                               // timezone of crontab, eg: Asia/Shanghai, the timezone of controller if empty
+}
+
+// ScheduleStatus is the last run created by crontab
+type ScheduleStatus struct {
+	LastScheduleTime *metav1.Time `json:"lastScheduleTime,omitempty" yaml:"lastScheduleTime,omitempty"`
+	LastRunName      string       `json:"lastRunName,omitempty" yaml:"lastRunName,omitempty"`
+}
+
+// GetConcurrencyPolicy returns the concurrency policy, a schedule is skipped if the last run is not finished by default
+func (s *ScheduleSpec) GetConcurrencyPolicy() string {
+	if s.ConcurrencyPolicy == "" {
+		return opsconstants.ConcurrencyPolicyForbid
+	}
+	return s.ConcurrencyPolicy
+}
+
+// GetCronSpec returns the crontab with its timezone
+func (s *ScheduleSpec) GetCronSpec(crontab string) string {
+	if s.TimeZone == "" {
+		return crontab
+	}
+	return "CRON_TZ=" + s.TimeZone + " " + crontab
+}
+
+// IsStartingDeadlineExceeded returns true if a schedule is too late to run
+func (s *ScheduleSpec) IsStartingDeadlineExceeded(scheduledAt, now time.Time) bool {
+	return s.StartingDeadlineSeconds != nil && now.Sub(scheduledAt) > time.Duration(*s.StartingDeadlineSeconds)*time.Second
+}
+
+// HasHistoryLimit returns true if the finished runs are kept by the history limits instead of the TTL
+func (s *ScheduleSpec) HasHistoryLimit() bool {
+	return s.SuccessfulRunsHistoryLimit != nil || s.FailedRunsHistoryLimit != nil
+}
@@ -20,6 +20,7 @@ import (
 	"fmt"
 
 	opsconstants "github.com/shaowenchen/ops/pkg/constants"
+	opsexpr "github.com/shaowenchen/ops/pkg/expr"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 )
@@ -38,6 +39,17 @@ type TaskSpec struct {
 	RuntimeImage            string      `json:"runtimeImage,omitempty" yaml:"runtimeImage,omitempty"`
 	TTlSecondsAfterFinished int         `json:"ttlSecondsAfterFinished,omitempty" yaml:"ttlSecondsAfterFinished,omitempty"`
 	Mounts                  []TaskMount `json:"mounts,omitempty" yaml:"mounts,omitempty"`
+	// +kubebuilder:validation:Minimum=0
+	TimeOutSeconds int `json:"timeoutSeconds,omitempty" yaml:"timeoutSeconds,omitempty"` // default timeout of steps, no timeout if 0
+	// +kubebuilder:validation:Minimum=0
+	Parallelism int `json:"parallelism,omitempty" yaml:"parallelism,omitempty"` // hosts running at the same time, one by one if 0
+	// +kubebuilder:validation:Minimum=0
+	BatchSize int `json:"batchSize,omitempty" yaml:"batchSize,omitempty"` // hosts of a batch, the next batch starts once the batch finished, all hosts in one batch if 0
+	// +kubebuilder:validation:Minimum=0
+	MaxFailures *int32 `json:"maxFailures,omitempty" yaml:"maxFailures,omitempty"` // the hosts not started yet are skipped once more hosts failed
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	MaxFailurePercent *int32 `json:"maxFailurePercent,omitempty" yaml:"maxFailurePercent,omitempty"` // the same as maxFailures, in percent of all hosts
 }
 
 // TaskMount defines a mount configuration for a Task
@@ -63,14 +75,25 @@ type ConfigMapMount struct {
 type Step struct {
 	When string `json:"when,omitempty" yaml:"when,omitempty"`
 	// +kubebuilder:validation:Pattern="^[a-z](-?[a-z0-9])*$"
-	Name           string `json:"name,omitempty" yaml:"name,omitempty"`
-	Content        string `json:"content,omitempty" yaml:"content,omitempty"`
-	LocalFile      string `json:"localfile,omitempty" yaml:"localfile,omitempty"`
-	RemoteFile     string `json:"remotefile,omitempty" yaml:"remotefile,omitempty"`
-	Direction      string `json:"direction,omitempty" yaml:"direction,omitempty"`
-	AllowFailure   string `json:"allowfailure,omitempty" yaml:"allowfailure,omitempty"`
-	TimeOutSeconds int    `json:"timeoutSeconds,omitempty" yaml:"timeoutSeconds,omitempty"`
+	Name         string `json:"name,omitempty" yaml:"name,omitempty"`
+	Content      string `json:"content,omitempty" yaml:"content,omitempty"`
+	LocalFile    string `json:"localfile,omitempty" yaml:"localfile,omitempty"`
+	RemoteFile   string `json:"remotefile,omitempty" yaml:"remotefile,omitempty"`
+	Direction    string `json:"direction,omitempty" yaml:"direction,omitempty"`
+	AllowFailure string `json:"allowfailure,omitempty" yaml:"allowfailure,omitempty"`
+	// +kubebuilder:validation:Minimum=0
+	TimeOutSeconds int    `json:"timeoutSeconds,omitempty" yaml:"timeoutSeconds,omitempty"` // the step is killed and Timeout after it, the timeoutSeconds of task if 0
 	RuntimeImage   string `json:"runtimeImage,omitempty" yaml:"runtimeImage,omitempty"`
+	// +kubebuilder:validation:Minimum=0
+	Retries int `json:"retries,omitempty" yaml:"retries,omitempty"` // the step is run again at most retries times until it succeeds and until is true
+	// +kubebuilder:validation:Minimum=0
+	DelaySeconds int    `json:"delaySeconds,omitempty" yaml:"delaySeconds,omitempty"` // the delay before running the step again
+	Until        string `json:"until,omitempty" yaml:"until,omitempty"`               // evaluated like when after every attempt, with ${output} and ${status} of the attempt
+}
+
+// IsRetryable returns true if the step may be run more than once or has an until condition to check
+func (s Step) IsRetryable() bool {
+	return s.Retries > 0 || s.Until != ""
 }
 
 // +kubebuilder:object:root=true
@@ -100,6 +123,14 @@ func (obj *Task) GetTTLSecondsAfterFinished() int {
 	return opsconstants.DefaultTTLSecondsAfterFinished
 }
 
+// GetStepTimeoutSeconds returns the timeout of a step, the default timeout of the task if not set, no timeout if 0
+func (obj *Task) GetStepTimeoutSeconds(step Step) int {
+	if step.TimeOutSeconds > 0 {
+		return step.TimeOutSeconds
+	}
+	return obj.Spec.TimeOutSeconds
+}
+
 func (obj *Task) GetUniqueKey() string {
 	return types.NamespacedName{
 		Namespace: obj.Namespace,
@@ -145,6 +176,30 @@ func (obj *Task) ValidateStepNames() error {
 	return nil
 }
 
+// ValidateExpressions validates the when, until and allowfailure of all steps, so that an invalid expression is found before the task runs
+func (obj *Task) ValidateExpressions() error {
+	for _, step := range obj.Spec.Steps {
+		if err := opsexpr.Validate(step.When); err != nil {
+			return fmt.Errorf("step '%s' when is invalid: %v", step.Name, err)
+		}
+		if err := opsexpr.Validate(step.Until); err != nil {
+			return fmt.Errorf("step '%s' until is invalid: %v", step.Name, err)
+		}
+		if err := opsexpr.Validate(step.AllowFailure); err != nil {
+			return fmt.Errorf("step '%s' allowfailure is invalid: %v", step.Name, err)
+		}
+	}
+	return nil
+}
+
+// Validate validates the step names and the expressions of the task
+func (obj *Task) Validate() error {
+	if err := obj.ValidateStepNames(); err != nil {
+		return err
+	}
+	return obj.ValidateExpressions()
+}
+
 func (obj *Task) MergeVersion(merge *Task) *Task {
 	obj.ObjectMeta.ResourceVersion = merge.ObjectMeta.ResourceVersion
 	return obj
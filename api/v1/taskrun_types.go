@@ -18,6 +18,7 @@ package v1
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
 	opsconstants "github.com/shaowenchen/ops/pkg/constants"
@@ -37,6 +38,22 @@ type TaskRunSpec struct {
 	Variables    map[string]string `json:"variables,omitempty" yaml:"variables,omitempty"`
 	TaskRef      string            `json:"taskRef,omitempty" yaml:"taskRef,omitempty"`
 	RuntimeImage string            `json:"runtimeImage,omitempty" yaml:"runtimeImage,omitempty"`
+	Cancelled    bool              `json:"cancelled,omitempty" yaml:"cancelled,omitempty"`     // stop the run, it ends as Aborted
+	CancelledBy  string            `json:"cancelledBy,omitempty" yaml:"cancelledBy,omitempty"` // who cancelled the run
+	DryRun       bool              `json:"dryRun,omitempty" yaml:"dryRun,omitempty"`           // render the steps on every node without running them
+	ScheduleSpec `json:",inline" yaml:",inline"`
+}
+
+// GetCancelledReason returns the reason recorded when the run is cancelled
+func (s *TaskRunSpec) GetCancelledReason() string {
+	return getCancelledReason(s.CancelledBy)
+}
+
+func getCancelledReason(user string) string {
+	if user == "" {
+		return "cancelled"
+	}
+	return "cancelled by " + user
 }
 
 func (obj *TaskRun) MergeVariables(t *Task) {
@@ -114,13 +131,18 @@ func NewTaskRun(t *Task) TaskRun {
 }
 
 func NewTaskRunWithPipelineRun(pr *PipelineRun, t *Task, tRef TaskRef, p *Pipeline) *TaskRun {
+	// the PipelineRun controls its TaskRuns, so changes of them trigger it
+	isController := true
 	tr := &TaskRun{
 		ObjectMeta: metav1.ObjectMeta{
 			Namespace:    pr.Namespace,
 			GenerateName: fmt.Sprintf("%s-%s-", pr.Name, tRef.TaskRef),
 			Labels: map[string]string{
-				opsconstants.LabelTaskRefKey:     t.ObjectMeta.GetName(),
-				opsconstants.LabelPipelineRefKey: pr.Spec.PipelineRef,
+				opsconstants.LabelTaskRefKey:      t.ObjectMeta.GetName(),
+				opsconstants.LabelPipelineRefKey:  pr.Spec.PipelineRef,
+				opsconstants.LabelPipelineRunKey:  pr.Name,
+				opsconstants.LabelPipelineTaskKey: tRef.GetName(),
+				opsconstants.LabelAttemptKey:      "1",
 			},
 			OwnerReferences: []metav1.OwnerReference{
 				{
@@ -128,12 +150,14 @@ func NewTaskRunWithPipelineRun(pr *PipelineRun, t *Task, tRef TaskRef, p *Pipeli
 					Kind:       opsconstants.PipelineRun,
 					Name:       pr.Name,
 					UID:        pr.UID,
+					Controller: &isController,
 				},
 			},
 		},
 		Spec: TaskRunSpec{
 			TaskRef:   t.ObjectMeta.GetName(),
 			Variables: make(map[string]string), // Initialize empty, caller should set filtered variables
+			DryRun:    pr.Spec.DryRun,
 		},
 	}
 	// Set Pipeline runtimeImage in spec
@@ -147,13 +171,27 @@ func NewTaskRunWithPipelineRun(pr *PipelineRun, t *Task, tRef TaskRef, p *Pipeli
 	return tr
 }
 
+// GetOutput returns the output of all steps on all nodes
+func (tr *TaskRunStatus) GetOutput() string {
+	var output strings.Builder
+	for _, nodeStatus := range tr.TaskRunNodeStatus {
+		for _, step := range nodeStatus.TaskRunStep {
+			output.WriteString(step.StepOutput)
+			output.WriteString("\n")
+		}
+	}
+	return output.String()
+}
+
 // TaskRunStatus defines the observed state of TaskRun
 type TaskRunStatus struct {
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
 	TaskRunNodeStatus map[string]*TaskRunNodeStatus `json:"taskrunNodeStatus,omitempty" yaml:"taskrunNodeStatus,omitempty"`
 	RunStatus         string                        `json:"runStatus,omitempty" yaml:"runStatus,omitempty"`
+	Reason            string                        `json:"reason,omitempty" yaml:"reason,omitempty"` // why the run stopped, eg: ControllerRestarted
 	StartTime         *metav1.Time                  `json:"startTime,omitempty" yaml:"startTime,omitempty"`
+	ScheduleStatus    `json:",inline" yaml:",inline"`
 }
 
 type TaskRunNodeStatus struct {
@@ -166,23 +204,87 @@ type TaskRunNodeStatus struct {
 type TaskRunStep struct {
 	StepName   string `json:"stepName,omitempty" yaml:"stepName,omitempty"`
 	StepOutput string `json:"stepOutput,omitempty" yaml:"stepOutput,omitempty"`
+	// the stepOutput only keeps the head and tail of a large output, the full output is in the log store by outputRef
+	Truncated  bool   `json:"truncated,omitempty" yaml:"truncated,omitempty"`
+	OutputRef  string `json:"outputRef,omitempty" yaml:"outputRef,omitempty"`
 	StepStatus string `json:"stepStatus,omitempty" yaml:"stepStatus,omitempty"`
+	Attempt    int    `json:"attempt,omitempty" yaml:"attempt,omitempty"` // the attempt of a step with retries or until, starting from 1
+	Command    string `json:"command,omitempty" yaml:"command,omitempty"` // the rendered command of the step
+	Stderr     string `json:"stderr,omitempty" yaml:"stderr,omitempty"`
+	// exit code of the command, not set if it did not exit, eg: it is cancelled
+	ExitCode   *int32       `json:"exitCode,omitempty" yaml:"exitCode,omitempty"`
+	StartTime  *metav1.Time `json:"startTime,omitempty" yaml:"startTime,omitempty"`
+	FinishTime *metav1.Time `json:"finishTime,omitempty" yaml:"finishTime,omitempty"`
+	DurationMs int64        `json:"durationMs,omitempty" yaml:"durationMs,omitempty"`
+}
+
+// SetExitCode sets the exit code of the step, a negative exit code means the command did not exit
+func (s *TaskRunStep) SetExitCode(exitCode int) {
+	if exitCode < 0 {
+		return
+	}
+	code := int32(exitCode)
+	s.ExitCode = &code
+}
+
+// SetTimes sets when the step started and finished
+func (s *TaskRunStep) SetTimes(startTime, finishTime time.Time) {
+	s.StartTime = &metav1.Time{Time: startTime}
+	s.FinishTime = &metav1.Time{Time: finishTime}
+	s.DurationMs = finishTime.Sub(startTime).Milliseconds()
 }
 
 func (tr *TaskRunStatus) AddOutputStep(nodeName string, stepName, stepCmd, stepOutput, stepStatus string) {
+	tr.AddOutputStepAttempt(nodeName, stepName, stepCmd, stepOutput, stepStatus, 0)
+}
+
+// AddOutputStepAttempt records an attempt of a step, every attempt of a step with retries or until is recorded
+func (tr *TaskRunStatus) AddOutputStepAttempt(nodeName string, stepName, stepCmd, stepOutput, stepStatus string, attempt int) {
+	tr.AddStep(nodeName, &TaskRunStep{
+		StepName:   stepName,
+		StepOutput: stepOutput,
+		StepStatus: stepStatus,
+		Attempt:    attempt,
+		Command:    stepCmd,
+	})
+}
+
+// AddStep records a step with the details of its execution, the status of the node is the status of the step
+func (tr *TaskRunStatus) AddStep(nodeName string, step *TaskRunStep) {
 	if tr.TaskRunNodeStatus == nil {
 		tr.TaskRunNodeStatus = make(map[string]*TaskRunNodeStatus)
 	}
 	if _, ok := tr.TaskRunNodeStatus[nodeName]; !ok {
 		tr.TaskRunNodeStatus[nodeName] = &TaskRunNodeStatus{}
 	}
-	tr.TaskRunNodeStatus[nodeName].TaskRunStep = append(tr.TaskRunNodeStatus[nodeName].TaskRunStep, &TaskRunStep{
-		StepName:   stepName,
-		StepOutput: stepOutput,
-		StepStatus: stepStatus,
-	})
+	tr.TaskRunNodeStatus[nodeName].TaskRunStep = append(tr.TaskRunNodeStatus[nodeName].TaskRunStep, step)
 	tr.TaskRunNodeStatus[nodeName].StartTime = &metav1.Time{Time: time.Now()}
-	tr.TaskRunNodeStatus[nodeName].RunStatus = stepStatus
+	tr.TaskRunNodeStatus[nodeName].RunStatus = step.StepStatus
+}
+
+// SetLastStepStatus overrides the status of the last recorded step of a node, eg: the until of the step is not satisfied
+func (tr *TaskRunStatus) SetLastStepStatus(nodeName, stepStatus string) {
+	nodeStatus, ok := tr.TaskRunNodeStatus[nodeName]
+	if !ok || len(nodeStatus.TaskRunStep) == 0 {
+		return
+	}
+	nodeStatus.TaskRunStep[len(nodeStatus.TaskRunStep)-1].StepStatus = stepStatus
+	nodeStatus.RunStatus = stepStatus
+}
+
+// SetNodeStatus sets the status of a node, eg: merged from the TaskRun copy the node run with
+func (tr *TaskRunStatus) SetNodeStatus(nodeName string, nodeStatus *TaskRunNodeStatus) {
+	if tr.TaskRunNodeStatus == nil {
+		tr.TaskRunNodeStatus = make(map[string]*TaskRunNodeStatus)
+	}
+	tr.TaskRunNodeStatus[nodeName] = nodeStatus
+}
+
+// AddSkippedNode records a node that is not run, eg: the rollout stopped after too many failures
+func (tr *TaskRunStatus) AddSkippedNode(nodeName string) {
+	tr.SetNodeStatus(nodeName, &TaskRunNodeStatus{
+		RunStatus: opsconstants.StatusSkipped,
+	})
 }
 
 func (tr *TaskRunStatus) ClearNodeStatus() {
@@ -17,6 +17,8 @@ package v1
 
 import (
 	"regexp"
+
+	corev1 "k8s.io/api/core/v1"
 )
 
 type Variable struct {
@@ -28,6 +30,14 @@ type Variable struct {
 	Required bool     `json:"required,omitempty" yaml:"required,omitempty"`
 	Enums    []string `json:"enums,omitempty" yaml:"enums,omitempty"`
 	Examples []string `json:"examples,omitempty" yaml:"examples,omitempty"`
+	// ValueFrom reads the value from a Secret or ConfigMap in the namespace of the TaskRun when it runs
+	ValueFrom *VariableSource `json:"valueFrom,omitempty" yaml:"valueFrom,omitempty"`
+}
+
+// VariableSource selects a key of a Secret or ConfigMap, the values from Secrets are masked in the outputs of steps
+type VariableSource struct {
+	SecretKeyRef    *corev1.SecretKeySelector    `json:"secretKeyRef,omitempty" yaml:"secretKeyRef,omitempty"`
+	ConfigMapKeyRef *corev1.ConfigMapKeySelector `json:"configMapKeyRef,omitempty" yaml:"configMapKeyRef,omitempty"`
 }
 
 func (v Variable) GetValue() string {
@@ -62,6 +72,9 @@ func (v Variable) MergeLowPriorityVariable(others Variable) Variable {
 	if len(v.Examples) == 0 {
 		v.Examples = others.Examples
 	}
+	if v.ValueFrom == nil {
+		v.ValueFrom = others.ValueFrom
+	}
 	return v
 }
 
@@ -90,6 +103,9 @@ func (v Variable) MergeHighPriorityVariable(others Variable) Variable {
 	if len(others.Examples) > 0 {
 		v.Examples = others.Examples
 	}
+	if others.ValueFrom != nil {
+		v.ValueFrom = others.ValueFrom
+	}
 	return v
 }
 
@@ -159,3 +175,13 @@ func (objs Variables) GetVariables() (result map[string]string) {
 	}
 	return
 }
+
+// HasValueFrom returns true if any variable reads its value from a Secret or ConfigMap
+func (objs Variables) HasValueFrom() bool {
+	for _, v := range objs {
+		if v.ValueFrom != nil {
+			return true
+		}
+	}
+	return false
+}
@@ -22,6 +22,8 @@ limitations under the License.
 package v1
 
 import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
@@ -438,6 +440,66 @@ func (in *PipelineRun) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PipelineRunApproval) DeepCopyInto(out *PipelineRunApproval) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PipelineRunApproval.
+func (in *PipelineRunApproval) DeepCopy() *PipelineRunApproval {
+	if in == nil {
+		return nil
+	}
+	out := new(PipelineRunApproval)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PipelineRunClusterStatus) DeepCopyInto(out *PipelineRunClusterStatus) {
+	*out = *in
+	if in.StartTime != nil {
+		in, out := &in.StartTime, &out.StartTime
+		*out = (*in).DeepCopy()
+	}
+	if in.Results != nil {
+		in, out := &in.Results, &out.Results
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PipelineRunClusterStatus.
+func (in *PipelineRunClusterStatus) DeepCopy() *PipelineRunClusterStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(PipelineRunClusterStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PipelineRunDispatch) DeepCopyInto(out *PipelineRunDispatch) {
+	*out = *in
+	if in.LastSyncTime != nil {
+		in, out := &in.LastSyncTime, &out.LastSyncTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PipelineRunDispatch.
+func (in *PipelineRunDispatch) DeepCopy() *PipelineRunDispatch {
+	if in == nil {
+		return nil
+	}
+	out := new(PipelineRunDispatch)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *PipelineRunList) DeepCopyInto(out *PipelineRunList) {
 	*out = *in
@@ -470,6 +532,28 @@ func (in *PipelineRunList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PipelineRunReusedTask) DeepCopyInto(out *PipelineRunReusedTask) {
+	*out = *in
+	if in.Results != nil {
+		in, out := &in.Results, &out.Results
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PipelineRunReusedTask.
+func (in *PipelineRunReusedTask) DeepCopy() *PipelineRunReusedTask {
+	if in == nil {
+		return nil
+	}
+	out := new(PipelineRunReusedTask)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *PipelineRunSpec) DeepCopyInto(out *PipelineRunSpec) {
 	*out = *in
@@ -480,6 +564,29 @@ func (in *PipelineRunSpec) DeepCopyInto(out *PipelineRunSpec) {
 			(*out)[key] = val
 		}
 	}
+	if in.ReusedTasks != nil {
+		in, out := &in.ReusedTasks, &out.ReusedTasks
+		*out = make([]PipelineRunReusedTask, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Approvals != nil {
+		in, out := &in.Approvals, &out.Approvals
+		*out = make([]PipelineRunApproval, len(*in))
+		copy(*out, *in)
+	}
+	if in.ClusterSelector != nil {
+		in, out := &in.ClusterSelector, &out.ClusterSelector
+		*out = new(metav1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.MaxFailedClusters != nil {
+		in, out := &in.MaxFailedClusters, &out.MaxFailedClusters
+		*out = new(int32)
+		**out = **in
+	}
+	in.ScheduleSpec.DeepCopyInto(&out.ScheduleSpec)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PipelineRunSpec.
@@ -506,6 +613,33 @@ func (in *PipelineRunStatus) DeepCopyInto(out *PipelineRunStatus) {
 		in, out := &in.StartTime, &out.StartTime
 		*out = (*in).DeepCopy()
 	}
+	if in.Env != nil {
+		in, out := &in.Env, &out.Env
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Dispatch != nil {
+		in, out := &in.Dispatch, &out.Dispatch
+		*out = new(PipelineRunDispatch)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ClusterStatus != nil {
+		in, out := &in.ClusterStatus, &out.ClusterStatus
+		*out = make([]PipelineRunClusterStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	in.ScheduleStatus.DeepCopyInto(&out.ScheduleStatus)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PipelineRunStatus.
@@ -518,9 +652,60 @@ func (in *PipelineRunStatus) DeepCopy() *PipelineRunStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PipelineRunTaskApproval) DeepCopyInto(out *PipelineRunTaskApproval) {
+	*out = *in
+	if in.StartTime != nil {
+		in, out := &in.StartTime, &out.StartTime
+		*out = (*in).DeepCopy()
+	}
+	if in.DecideTime != nil {
+		in, out := &in.DecideTime, &out.DecideTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PipelineRunTaskApproval.
+func (in *PipelineRunTaskApproval) DeepCopy() *PipelineRunTaskApproval {
+	if in == nil {
+		return nil
+	}
+	out := new(PipelineRunTaskApproval)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PipelineRunTaskAttempt) DeepCopyInto(out *PipelineRunTaskAttempt) {
+	*out = *in
+	if in.StartTime != nil {
+		in, out := &in.StartTime, &out.StartTime
+		*out = (*in).DeepCopy()
+	}
+	if in.FinishTime != nil {
+		in, out := &in.FinishTime, &out.FinishTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PipelineRunTaskAttempt.
+func (in *PipelineRunTaskAttempt) DeepCopy() *PipelineRunTaskAttempt {
+	if in == nil {
+		return nil
+	}
+	out := new(PipelineRunTaskAttempt)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *PipelineRunTaskStatus) DeepCopyInto(out *PipelineRunTaskStatus) {
 	*out = *in
+	if in.RunAfter != nil {
+		in, out := &in.RunAfter, &out.RunAfter
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 	if in.TaskRunStatus != nil {
 		in, out := &in.TaskRunStatus, &out.TaskRunStatus
 		*out = new(TaskRunStatus)
@@ -533,6 +718,25 @@ func (in *PipelineRunTaskStatus) DeepCopyInto(out *PipelineRunTaskStatus) {
 			(*out)[key] = val
 		}
 	}
+	if in.Attempts != nil {
+		in, out := &in.Attempts, &out.Attempts
+		*out = make([]PipelineRunTaskAttempt, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Matrix != nil {
+		in, out := &in.Matrix, &out.Matrix
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Approval != nil {
+		in, out := &in.Approval, &out.Approval
+		*out = new(PipelineRunTaskApproval)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PipelineRunTaskStatus.
@@ -562,6 +766,13 @@ func (in *PipelineSpec) DeepCopyInto(out *PipelineSpec) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.Finally != nil {
+		in, out := &in.Finally, &out.Finally
+		*out = make([]TaskRef, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PipelineSpec.
@@ -589,6 +800,55 @@ func (in *PipelineStatus) DeepCopy() *PipelineStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScheduleSpec) DeepCopyInto(out *ScheduleSpec) {
+	*out = *in
+	if in.SuccessfulRunsHistoryLimit != nil {
+		in, out := &in.SuccessfulRunsHistoryLimit, &out.SuccessfulRunsHistoryLimit
+		*out = new(int32)
+		**out = **in
+	}
+	if in.FailedRunsHistoryLimit != nil {
+		in, out := &in.FailedRunsHistoryLimit, &out.FailedRunsHistoryLimit
+		*out = new(int32)
+		**out = **in
+	}
+	if in.StartingDeadlineSeconds != nil {
+		in, out := &in.StartingDeadlineSeconds, &out.StartingDeadlineSeconds
+		*out = new(int64)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScheduleSpec.
+func (in *ScheduleSpec) DeepCopy() *ScheduleSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ScheduleSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScheduleStatus) DeepCopyInto(out *ScheduleStatus) {
+	*out = *in
+	if in.LastScheduleTime != nil {
+		in, out := &in.LastScheduleTime, &out.LastScheduleTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScheduleStatus.
+func (in *ScheduleStatus) DeepCopy() *ScheduleStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ScheduleStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *SecretMount) DeepCopyInto(out *SecretMount) {
 	*out = *in
@@ -646,6 +906,26 @@ func (in *Task) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TaskApproval) DeepCopyInto(out *TaskApproval) {
+	*out = *in
+	if in.Approvers != nil {
+		in, out := &in.Approvers, &out.Approvers
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TaskApproval.
+func (in *TaskApproval) DeepCopy() *TaskApproval {
+	if in == nil {
+		return nil
+	}
+	out := new(TaskApproval)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *TaskList) DeepCopyInto(out *TaskList) {
 	*out = *in
@@ -713,6 +993,31 @@ func (in *TaskRef) DeepCopyInto(out *TaskRef) {
 			(*out)[key] = val
 		}
 	}
+	if in.RunAfter != nil {
+		in, out := &in.RunAfter, &out.RunAfter
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Matrix != nil {
+		in, out := &in.Matrix, &out.Matrix
+		*out = make(map[string][]string, len(*in))
+		for key, val := range *in {
+			var outVal []string
+			if val == nil {
+				(*out)[key] = nil
+			} else {
+				in, out := &val, &outVal
+				*out = make([]string, len(*in))
+				copy(*out, *in)
+			}
+			(*out)[key] = outVal
+		}
+	}
+	if in.Approval != nil {
+		in, out := &in.Approval, &out.Approval
+		*out = new(TaskApproval)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TaskRef.
@@ -794,7 +1099,7 @@ func (in *TaskRunNodeStatus) DeepCopyInto(out *TaskRunNodeStatus) {
 			if (*in)[i] != nil {
 				in, out := &(*in)[i], &(*out)[i]
 				*out = new(TaskRunStep)
-				**out = **in
+				(*in).DeepCopyInto(*out)
 			}
 		}
 	}
@@ -824,6 +1129,7 @@ func (in *TaskRunSpec) DeepCopyInto(out *TaskRunSpec) {
 			(*out)[key] = val
 		}
 	}
+	in.ScheduleSpec.DeepCopyInto(&out.ScheduleSpec)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TaskRunSpec.
@@ -858,6 +1164,7 @@ func (in *TaskRunStatus) DeepCopyInto(out *TaskRunStatus) {
 		in, out := &in.StartTime, &out.StartTime
 		*out = (*in).DeepCopy()
 	}
+	in.ScheduleStatus.DeepCopyInto(&out.ScheduleStatus)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TaskRunStatus.
@@ -873,6 +1180,19 @@ func (in *TaskRunStatus) DeepCopy() *TaskRunStatus {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *TaskRunStep) DeepCopyInto(out *TaskRunStep) {
 	*out = *in
+	if in.ExitCode != nil {
+		in, out := &in.ExitCode, &out.ExitCode
+		*out = new(int32)
+		**out = **in
+	}
+	if in.StartTime != nil {
+		in, out := &in.StartTime, &out.StartTime
+		*out = (*in).DeepCopy()
+	}
+	if in.FinishTime != nil {
+		in, out := &in.FinishTime, &out.FinishTime
+		*out = (*in).DeepCopy()
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TaskRunStep.
@@ -907,6 +1227,16 @@ func (in *TaskSpec) DeepCopyInto(out *TaskSpec) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.MaxFailures != nil {
+		in, out := &in.MaxFailures, &out.MaxFailures
+		*out = new(int32)
+		**out = **in
+	}
+	if in.MaxFailurePercent != nil {
+		in, out := &in.MaxFailurePercent, &out.MaxFailurePercent
+		*out = new(int32)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TaskSpec.
@@ -947,6 +1277,11 @@ func (in *Variable) DeepCopyInto(out *Variable) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.ValueFrom != nil {
+		in, out := &in.ValueFrom, &out.ValueFrom
+		*out = new(VariableSource)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Variable.
@@ -959,6 +1294,31 @@ func (in *Variable) DeepCopy() *Variable {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VariableSource) DeepCopyInto(out *VariableSource) {
+	*out = *in
+	if in.SecretKeyRef != nil {
+		in, out := &in.SecretKeyRef, &out.SecretKeyRef
+		*out = new(corev1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ConfigMapKeyRef != nil {
+		in, out := &in.ConfigMapKeyRef, &out.ConfigMapKeyRef
+		*out = new(corev1.ConfigMapKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VariableSource.
+func (in *VariableSource) DeepCopy() *VariableSource {
+	if in == nil {
+		return nil
+	}
+	out := new(VariableSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in Variables) DeepCopyInto(out *Variables) {
 	{
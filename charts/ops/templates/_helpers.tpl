@@ -138,3 +138,32 @@ Create the name of the service account to use
 {{- default "default" .Values.serviceAccount.name }}
 {{- end }}
 {{- end }}
+
+{{/*
+Env of the log store of the full outputs of steps - shared by controller and server
+*/}}
+{{- define "ops.logStoreEnv" -}}
+- name: LOG_STORE
+  value: {{ .Values.logStore.type | quote }}
+{{- if eq .Values.logStore.type "local" }}
+- name: LOG_STORE_DIR
+  value: {{ .Values.logStore.local.dir | quote }}
+{{- else if eq .Values.logStore.type "s3" }}
+- name: LOG_STORE_S3_ENDPOINT
+  value: {{ .Values.logStore.s3.endpoint | quote }}
+- name: LOG_STORE_S3_REGION
+  value: {{ .Values.logStore.s3.region | quote }}
+- name: LOG_STORE_S3_BUCKET
+  value: {{ .Values.logStore.s3.bucket | quote }}
+- name: LOG_STORE_S3_AK
+  valueFrom:
+    secretKeyRef:
+      name: {{ .Values.logStore.s3.secretName }}
+      key: ak
+- name: LOG_STORE_S3_SK
+  valueFrom:
+    secretKeyRef:
+      name: {{ .Values.logStore.s3.secretName }}
+      key: sk
+{{- end }}
+{{- end }}
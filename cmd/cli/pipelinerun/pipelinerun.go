@@ -0,0 +1,14 @@
+package pipelinerun
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var PipelineRunCmd = &cobra.Command{
+	Use:   "pipelinerun",
+	Short: "command about PipelineRun",
+}
+
+func init() {
+	PipelineRunCmd.AddCommand(rerunCmd)
+}
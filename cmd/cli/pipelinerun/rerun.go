@@ -0,0 +1,55 @@
+package pipelinerun
+
+import (
+	"context"
+
+	"github.com/shaowenchen/ops/pkg/constants"
+	"github.com/shaowenchen/ops/pkg/kube"
+	"github.com/shaowenchen/ops/pkg/log"
+	"github.com/shaowenchen/ops/pkg/utils"
+	"github.com/spf13/cobra"
+)
+
+var rerunKubeconfig string
+var rerunNamespace string
+var rerunName string
+var rerunFrom string
+var rerunVerbose string
+
+var rerunCmd = &cobra.Command{
+	Use:   "rerun",
+	Short: "rerun a finished pipelinerun from a task, reuse the results of tasks succeeded",
+	Run: func(cmd *cobra.Command, args []string) {
+		logger := log.NewLogger().SetVerbose(rerunVerbose).SetStd().SetFile().Build()
+		ctx, cancel := context.WithTimeout(context.Background(), constants.DefaultShellTimeoutDuration)
+		defer cancel()
+		Rerun(ctx, logger)
+	},
+}
+
+func Rerun(ctx context.Context, logger *log.Logger) (err error) {
+	client, err := kube.GetRuntimeClient(utils.GetAbsoluteFilePath(rerunKubeconfig))
+	if err != nil {
+		logger.Error.Println(err)
+		return
+	}
+	pr, err := kube.RerunPipelineRun(ctx, client, rerunNamespace, rerunName, rerunFrom)
+	if err != nil {
+		logger.Error.Println(err)
+		return
+	}
+	logger.Info.Printf("pipelinerun %s/%s created, rerun of %s from task %s", pr.Namespace, pr.Name, rerunName, rerunFrom)
+	return
+}
+
+func init() {
+	rerunCmd.Flags().StringVarP(&rerunVerbose, "verbose", "v", "", "")
+	rerunCmd.Flags().StringVarP(&rerunKubeconfig, "kubeconfig", "", constants.GetCurrentUserKubeConfigPath(), "")
+	rerunCmd.Flags().StringVarP(&rerunNamespace, "namespace", "", constants.OpsNamespace, "")
+	rerunCmd.Flags().StringVarP(&rerunName, "name", "", "", "name of the pipelinerun to rerun")
+	rerunCmd.MarkFlagRequired("name")
+	rerunCmd.Flags().StringVarP(&rerunFrom, "from", "", "", "name of the task to rerun from")
+	rerunCmd.MarkFlagRequired("from")
+
+	_ = rerunCmd.MarkFlagFilename("kubeconfig")
+}
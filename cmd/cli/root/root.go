@@ -7,6 +7,7 @@ import (
 	"github.com/shaowenchen/ops/cmd/cli/config"
 	"github.com/shaowenchen/ops/cmd/cli/create"
 	"github.com/shaowenchen/ops/cmd/cli/file"
+	"github.com/shaowenchen/ops/cmd/cli/pipelinerun"
 	"github.com/shaowenchen/ops/cmd/cli/shell"
 	"github.com/shaowenchen/ops/cmd/cli/task"
 	"github.com/shaowenchen/ops/cmd/cli/upgrade"
@@ -19,6 +20,7 @@ func Execute() {
 	RootCmd.AddCommand(shell.ShellCmd)
 	RootCmd.AddCommand(create.CreateCmd)
 	RootCmd.AddCommand(task.TaskCmd)
+	RootCmd.AddCommand(pipelinerun.PipelineRunCmd)
 	RootCmd.AddCommand(version.VersionCmd)
 	RootCmd.AddCommand(upgrade.UpgradeCmd)
 	RootCmd.AddCommand(config.ConfigCmd)
@@ -23,6 +23,7 @@ var taskOpt option.TaskOption
 var hostOpt option.HostOption
 var kubeOpt option.KubeOption
 var inventory string
+var forks int
 
 var TaskCmd = &cobra.Command{
 	Use:                "task",
@@ -48,32 +49,79 @@ var TaskCmd = &cobra.Command{
 		}
 		taskOpt.Variables["nodename"] = kubeOpt.NodeName
 		for _, task := range tasks {
+			if err = task.Validate(); err != nil {
+				logger.Error.Println(err)
+				return
+			}
+		}
+		for _, task := range tasks {
+			kubeconfig := availableInventory
+			if inventoryType == constants.InventoryTypeHosts {
+				kubeconfig = ""
+			}
+			runOpt, err := resolveValueFrom(context.Background(), task, taskOpt, kubeconfig)
+			if err != nil {
+				logger.Error.Println(err)
+				return
+			}
 			if inventoryType == constants.InventoryTypeHosts && !task.NeedKubeExecution() {
-				HostTask(context.Background(), logger, task, taskOpt, hostOpt, availableInventory)
+				HostTask(context.Background(), logger, task, runOpt, hostOpt, availableInventory, forks)
 			} else {
-				KubeTask(context.Background(), logger, task, taskOpt, kubeOpt, availableInventory)
+				KubeTask(context.Background(), logger, task, runOpt, kubeOpt, availableInventory)
 			}
 		}
 	},
 }
 
-func HostTask(ctx context.Context, logger *log.Logger, t opsv1.Task, taskOpt option.TaskOption, hostOpt option.HostOption, inventory string) (err error) {
+// HostTask runs the task on the hosts of inventory, forks hosts at the same time if it is greater than 0, else by the parallelism of the task
+func HostTask(ctx context.Context, logger *log.Logger, t opsv1.Task, taskOpt option.TaskOption, hostOpt option.HostOption, inventory string, forks int) (err error) {
 	hs := host.GetHosts(logger, option.ClusterOption{}, hostOpt, inventory)
-	for _, h := range hs {
-		tr := opsv1.NewTaskRun(&t)
-		hc, err := host.NewHostConnBase64(h)
-		if err != nil {
-			logger.Error.Println(err)
-			continue
+	rollout := opstask.NewRollout(&t, forks)
+	skipped := rollout.Run(ctx, len(hs), func(i int) bool {
+		h := hs[i]
+		// the output of a host is printed together when hosts run at the same time
+		hostLogger := logger
+		if rollout.Parallelism > 1 {
+			hostLogger = &log.Logger{Level: logger.Level, Flag: logger.Flag, Std: logger.Std, File: logger.File}
+			hostLogger.WaitFlush().Build()
+			defer hostLogger.Flush()
 		}
+		tr := opsv1.NewTaskRun(&t)
 		newTaskOpt := taskOpt
+		newTaskOpt.Variables = make(map[string]string)
+		for k, v := range taskOpt.Variables {
+			newTaskOpt.Variables[k] = v
+		}
 		newTaskOpt.Variables["host"] = h.GetHostname()
 		newTaskOpt.Variables["proxy"] = taskOpt.Proxy
-		err = opstask.RunTaskOnHost(ctx, logger, &t, &tr, hc, newTaskOpt)
+		if taskOpt.DryRun {
+			err := opstask.PlanTask(hostLogger, &t, &tr, h.GetHostname(), newTaskOpt)
+			printPlan(hostLogger, &tr)
+			if err != nil {
+				hostLogger.Error.Println(err)
+				return false
+			}
+			return true
+		}
+		hc, err := host.NewHostConnBase64(h)
 		if err != nil {
-			logger.Error.Println(err)
-			continue
+			hostLogger.Error.Println(err)
+			return false
+		}
+		err = opstask.RunTaskOnHost(ctx, hostLogger, &t, &tr, hc, newTaskOpt)
+		if err != nil {
+			hostLogger.Error.Println(err)
+			return false
 		}
+		for _, nodeStatus := range tr.Status.TaskRunNodeStatus {
+			if nodeStatus.RunStatus != constants.StatusSuccessed {
+				return false
+			}
+		}
+		return true
+	})
+	if len(skipped) > 0 {
+		logger.Error.Printf("%d of %d hosts skipped after too many hosts failed", len(skipped), len(hs))
 	}
 	return
 }
@@ -149,7 +197,12 @@ func KubeTask(ctx context.Context, logger *log.Logger, t opsv1.Task, taskOpt opt
 		}
 
 		tr := opsv1.NewTaskRun(&t)
-		err = opstask.RunTaskOnKube(logger, &t, &tr, kc, &node, taskOpt, newKubeOpt)
+		if taskOpt.DryRun {
+			err = opstask.PlanTask(logger, &t, &tr, node.GetName(), taskOpt)
+			printPlan(logger, &tr)
+		} else {
+			err = opstask.RunTaskOnKube(ctx, logger, &t, &tr, kc, &node, taskOpt, newKubeOpt)
+		}
 		if err != nil {
 			logger.Error.Println(err)
 		}
@@ -157,6 +210,50 @@ func KubeTask(ctx context.Context, logger *log.Logger, t opsv1.Task, taskOpt opt
 	return
 }
 
+// printPlan prints the rendered steps of a dry run on every node
+func printPlan(logger *log.Logger, tr *opsv1.TaskRun) {
+	for nodeName, nodeStatus := range tr.Status.TaskRunNodeStatus {
+		logger.Info.Printf("> Plan on %s: %s", nodeName, nodeStatus.RunStatus)
+		for i, step := range nodeStatus.TaskRunStep {
+			logger.Info.Printf("(%d/%d) %s [%s]\n%s", i+1, len(nodeStatus.TaskRunStep), step.StepName, step.StepStatus, step.Command)
+		}
+	}
+}
+
+// resolveValueFrom returns the option with the variables of the task from Secrets and ConfigMaps,
+// they are read from the cluster of kubeconfig, or the current user's kubeconfig if it is empty
+func resolveValueFrom(ctx context.Context, t opsv1.Task, taskOpt option.TaskOption, kubeconfig string) (option.TaskOption, error) {
+	if !t.Spec.Variables.HasValueFrom() {
+		return taskOpt, nil
+	}
+	if kubeconfig == "" && utils.IsExistsFile(constants.GetCurrentUserKubeConfigPath()) {
+		kubeconfig = constants.GetCurrentUserKubeConfigPath()
+	}
+	kc, err := kube.NewKubeConnection(kubeconfig)
+	if err != nil {
+		return taskOpt, err
+	}
+	namespace := t.Namespace
+	if namespace == "" {
+		namespace = constants.OpsNamespace
+	}
+	values, secrets, err := opstask.ResolveValueFrom(ctx, *kc.OpsClient, namespace, &t, taskOpt.Variables)
+	if err != nil {
+		return taskOpt, err
+	}
+	runOpt := taskOpt
+	runOpt.Variables = make(map[string]string)
+	for k, v := range taskOpt.Variables {
+		runOpt.Variables[k] = v
+	}
+	for k, v := range values {
+		runOpt.Variables[k] = v
+	}
+	runOpt.Secrets = append(append([]string{}, taskOpt.Secrets...), secrets...)
+	runOpt.SecretVariables = append(append([]string{}, taskOpt.SecretVariables...), opstask.GetSecretVariables(&t, values)...)
+	return runOpt, nil
+}
+
 func parseArgs(args []string) (taskOption option.TaskOption) {
 	taskOption.Variables = make(map[string]string)
 	runtimeImageSetViaCLI := false
@@ -174,6 +271,8 @@ func parseArgs(args []string) (taskOption option.TaskOption) {
 			}
 			if fieldName == "sudo" {
 				taskOption.Sudo = fieldValue == "true"
+			} else if fieldName == "dry-run" {
+				taskOption.DryRun = fieldValue == "true"
 			} else if fieldName == "filepath" || fieldName == "f" {
 				taskOption.FilePath = fieldValue
 			} else if fieldName == "proxy" {
@@ -194,6 +293,8 @@ func parseArgs(args []string) (taskOption option.TaskOption) {
 				hostOpt.Username = fieldValue
 			} else if fieldName == "password" {
 				hostOpt.Password = fieldValue
+			} else if fieldName == "forks" {
+				forks, _ = strconv.Atoi(fieldValue)
 			} else if fieldName == "privatekeypath" {
 				hostOpt.PrivateKeyPath = fieldValue
 			} else {
@@ -235,6 +336,8 @@ func init() {
 	runtimeImage := config.GetValueWithPriority("", constants.EnvDefaultRuntimeImage, "runtimeimage", constants.DefaultRuntimeImage)
 	TaskCmd.Flags().StringVarP(&kubeOpt.RuntimeImage, "runtimeimage", "", runtimeImage, "runtime image")
 
+	TaskCmd.Flags().BoolVarP(&taskOpt.DryRun, "dry-run", "", false, "print the rendered steps on every host without running them")
+	TaskCmd.Flags().IntVarP(&forks, "forks", "", 0, "hosts running at the same time, the parallelism of task if 0")
 	TaskCmd.Flags().IntVarP(&hostOpt.Port, "port", "", 22, "SSH port for host inventory")
 	TaskCmd.Flags().StringVarP(&hostOpt.Username, "username", "", constants.GetCurrentUser(), "SSH username for host inventory")
 	TaskCmd.Flags().StringVarP(&hostOpt.Password, "password", "", "", "SSH password for host inventory")
@@ -0,0 +1,364 @@
+/*
+Copyright 2024 shaowenchen.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	opsv1 "github.com/shaowenchen/ops/api/v1"
+	opsconstants "github.com/shaowenchen/ops/pkg/constants"
+	opsevent "github.com/shaowenchen/ops/pkg/event"
+	opskube "github.com/shaowenchen/ops/pkg/kube"
+	opslog "github.com/shaowenchen/ops/pkg/log"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	toolscache "k8s.io/client-go/tools/cache"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+const (
+	// remoteCacheSyncTimeout is how long to wait for the PipelineRuns of a cluster to be listed
+	remoteCacheSyncTimeout = 30 * time.Second
+	// dispatchRetryInterval is how long to wait before connecting an unreachable cluster again
+	dispatchRetryInterval = 30 * time.Second
+)
+
+// remoteCluster is the connection and the PipelineRun informer of a cluster that PipelineRuns are dispatched to
+type remoteCluster struct {
+	kc              *opskube.KubeConnection
+	cache           cache.Cache
+	cancel          context.CancelFunc
+	resourceVersion string
+	watchErrMutex   sync.RWMutex
+	watchErr        error
+}
+
+func (rc *remoteCluster) getWatchError() error {
+	rc.watchErrMutex.RLock()
+	defer rc.watchErrMutex.RUnlock()
+	return rc.watchErr
+}
+
+// setWatchError records the last error of the informer, returns true if the cluster becomes unreachable
+func (rc *remoteCluster) setWatchError(err error) bool {
+	rc.watchErrMutex.Lock()
+	defer rc.watchErrMutex.Unlock()
+	changed := rc.watchErr == nil && err != nil
+	rc.watchErr = err
+	return changed
+}
+
+// getRemoteCluster returns the informer of a cluster, it is started once and rebuilt if the cluster changes
+// The informer is started and synced without the lock, so a slow cluster does not block the others
+func (r *PipelineRunReconciler) getRemoteCluster(logger *opslog.Logger, cluster *opsv1.Cluster) (*remoteCluster, error) {
+	r.remoteClustersMutex.Lock()
+	rc, ok := r.remoteClusters[cluster.Name]
+	r.remoteClustersMutex.Unlock()
+	if ok && rc.resourceVersion == cluster.ResourceVersion {
+		return rc, nil
+	}
+	rc, err := r.newRemoteCluster(logger, cluster)
+	if err != nil {
+		return nil, err
+	}
+	return r.publishRemoteCluster(logger, cluster.Name, rc), nil
+}
+
+// publishRemoteCluster keeps the synced informer of a cluster, the one published by another reconcile for the same version wins
+func (r *PipelineRunReconciler) publishRemoteCluster(logger *opslog.Logger, clusterName string, rc *remoteCluster) *remoteCluster {
+	r.remoteClustersMutex.Lock()
+	defer r.remoteClustersMutex.Unlock()
+	if r.remoteClusters == nil {
+		r.remoteClusters = make(map[string]*remoteCluster)
+	}
+	if published, ok := r.remoteClusters[clusterName]; ok {
+		if published.resourceVersion == rc.resourceVersion {
+			rc.cancel()
+			return published
+		}
+		logger.Info.Printf("cluster %s changed, restart its pipelinerun informer", clusterName)
+		published.cancel()
+	}
+	r.remoteClusters[clusterName] = rc
+	return rc
+}
+
+// newRemoteCluster starts the PipelineRun informer of a cluster and waits until it is synced
+func (r *PipelineRunReconciler) newRemoteCluster(logger *opslog.Logger, cluster *opsv1.Cluster) (*remoteCluster, error) {
+	kc, err := opskube.NewClusterConnection(cluster)
+	if err != nil {
+		return nil, err
+	}
+	scheme, err := opsv1.SchemeBuilder.Build()
+	if err != nil {
+		return nil, err
+	}
+	remoteCache, err := cache.New(kc.RestConfig, cache.Options{Scheme: scheme})
+	if err != nil {
+		return nil, err
+	}
+	informer, err := remoteCache.GetInformer(context.TODO(), &opsv1.PipelineRun{})
+	if err != nil {
+		return nil, err
+	}
+	rc := &remoteCluster{
+		kc:              kc,
+		cache:           remoteCache,
+		resourceVersion: cluster.ResourceVersion,
+	}
+	clusterName := cluster.Name
+	if sharedInformer, ok := informer.(toolscache.SharedIndexInformer); ok {
+		sharedInformer.SetWatchErrorHandler(func(reflector *toolscache.Reflector, err error) {
+			toolscache.DefaultWatchErrorHandler(reflector, err)
+			if rc.setWatchError(err) {
+				r.enqueueDispatched(logger, clusterName, nil)
+			}
+		})
+	}
+	onEvent := func(obj interface{}) {
+		if tombstone, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+			obj = tombstone.Obj
+		}
+		rc.setWatchError(nil)
+		if remotePr, ok := obj.(*opsv1.PipelineRun); ok {
+			r.enqueueDispatched(logger, clusterName, remotePr)
+		}
+	}
+	informer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		AddFunc:    onEvent,
+		UpdateFunc: func(_, obj interface{}) { onEvent(obj) },
+		DeleteFunc: onEvent,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	rc.cancel = cancel
+	go func() {
+		if err := remoteCache.Start(ctx); err != nil {
+			logger.Error.Println(err, "failed to start pipelinerun informer of cluster "+clusterName)
+		}
+	}()
+	syncCtx, syncCancel := context.WithTimeout(ctx, remoteCacheSyncTimeout)
+	defer syncCancel()
+	if !remoteCache.WaitForCacheSync(syncCtx) {
+		cancel()
+		if err := rc.getWatchError(); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("timeout to list pipelineruns of cluster %s", clusterName)
+	}
+	return rc, nil
+}
+
+// enqueueDispatched triggers the local PipelineRuns dispatched to a cluster, only the one of the remote PipelineRun if given
+// It is called by the informer, so the event is dropped instead of blocking the informer if remoteEvents is full
+func (r *PipelineRunReconciler) enqueueDispatched(logger *opslog.Logger, clusterName string, remotePr *opsv1.PipelineRun) {
+	prList := &opsv1.PipelineRunList{}
+	opts := []client.ListOption{}
+	if remotePr != nil {
+		opts = append(opts, client.InNamespace(remotePr.Namespace))
+	}
+	if err := r.Client.List(context.TODO(), prList, opts...); err != nil {
+		return
+	}
+	for i := range prList.Items {
+		pr := &prList.Items[i]
+		if pr.Status.Dispatch == nil || pr.Status.Dispatch.Cluster != clusterName || opsconstants.IsFinishedStatus(pr.Status.RunStatus) {
+			continue
+		}
+		if remotePr != nil && pr.Name != remotePr.Name {
+			continue
+		}
+		select {
+		case r.remoteEvents <- event.GenericEvent{Object: pr}:
+		default:
+			logger.Error.Printf("remote events are full, drop the event of pipelinerun %s/%s from cluster %s", pr.Namespace, pr.Name, clusterName)
+		}
+	}
+}
+
+// dispatch sends the PipelineRun to another cluster once, then syncs its status from the informer of that cluster
+func (r *PipelineRunReconciler) dispatch(logger *opslog.Logger, ctx context.Context, cluster *opsv1.Cluster, pr *opsv1.PipelineRun) (ctrl.Result, error) {
+	rc, err := r.getRemoteCluster(logger, cluster)
+	if err != nil {
+		logger.Error.Println(err, "failed to connect cluster "+cluster.Name)
+		r.commitDispatchCondition(logger, ctx, pr, metav1.ConditionFalse, opsconstants.ReasonClusterUnreachable, err.Error())
+		return ctrl.Result{RequeueAfter: dispatchRetryInterval}, nil
+	}
+	// send pr
+	if pr.Status.Dispatch == nil || pr.Status.Dispatch.Cluster != cluster.Name {
+		logger.Info.Printf("send pipelinerun %s to cluster %s", pr.Name, cluster.Name)
+		remotePr := pr.DeepCopy()
+		remotePr.SetCurrentCluster()
+		err = rc.kc.CreatePipelineRun(remotePr)
+		if err == nil {
+			err = rc.kc.GetPipelineRun(remotePr)
+		}
+		if err != nil {
+			logger.Error.Println(err, "failed to create pr")
+			r.commitDispatchCondition(logger, ctx, pr, metav1.ConditionFalse, opsconstants.ReasonClusterUnreachable, err.Error())
+			return ctrl.Result{RequeueAfter: dispatchRetryInterval}, nil
+		}
+		dispatch := &opsv1.PipelineRunDispatch{
+			Cluster:      cluster.Name,
+			RemoteUID:    string(remotePr.UID),
+			LastSyncTime: &metav1.Time{Time: time.Now()},
+		}
+		err = r.commitDispatchStatus(logger, ctx, pr, func(latestPr *opsv1.PipelineRun) {
+			latestPr.Status.RunStatus = opsconstants.StatusDispatched
+			latestPr.Status.Dispatch = dispatch
+			setClusterReachable(latestPr, metav1.ConditionTrue, opsconstants.ReasonDispatched, fmt.Sprintf("dispatched to cluster %s", cluster.Name))
+		})
+		// sync the status once the dispatch is recorded, events of the remote PipelineRun before it are dropped
+		return ctrl.Result{Requeue: true}, err
+	}
+	// the informer retries and triggers the sync after the cluster is reachable again
+	if err = rc.getWatchError(); err != nil {
+		r.commitDispatchCondition(logger, ctx, pr, metav1.ConditionFalse, opsconstants.ReasonClusterUnreachable, err.Error())
+		return ctrl.Result{}, nil
+	}
+	remotePr := &opsv1.PipelineRun{}
+	err = rc.cache.Get(ctx, types.NamespacedName{Namespace: pr.Namespace, Name: pr.Name}, remotePr)
+	if isRemoteNotFound(pr, remotePr, err) {
+		// the cache may not have seen the PipelineRun just sent, so check it in the cluster before failing
+		remotePr = &opsv1.PipelineRun{ObjectMeta: metav1.ObjectMeta{Namespace: pr.Namespace, Name: pr.Name}}
+		err = rc.kc.GetPipelineRun(remotePr)
+	}
+	if isRemoteNotFound(pr, remotePr, err) {
+		logger.Error.Printf("pipelinerun %s is not found in cluster %s, mark it failed", pr.Name, cluster.Name)
+		var failedStatus opsv1.PipelineRunStatus
+		message := fmt.Sprintf("pipelinerun %s/%s with uid %s is not found in cluster %s", pr.Namespace, pr.Name, pr.Status.Dispatch.RemoteUID, cluster.Name)
+		err = r.commitDispatchStatus(logger, ctx, pr, func(latestPr *opsv1.PipelineRun) {
+			setRemoteNotFound(latestPr, message)
+			failedStatus = *latestPr.Status.DeepCopy()
+		})
+		if err == nil {
+			// send event
+			go opsevent.FactoryPipelineRun(pr.Namespace, pr.Name, opsconstants.Status).Publish(ctx, &opsevent.EventPipelineRun{
+				PipelineRef:       pr.Spec.PipelineRef,
+				Desc:              pr.Spec.Desc,
+				Variables:         pr.Spec.Variables,
+				PipelineRunStatus: failedStatus,
+			})
+		}
+		return ctrl.Result{}, err
+	}
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	finished := opsconstants.IsFinishedStatus(remotePr.Status.RunStatus)
+	if pr.Spec.Cancelled && !finished && !remotePr.Spec.Cancelled {
+		logger.Info.Printf("cancel pipelinerun %s in cluster %s", pr.Name, cluster.Name)
+		remotePr.Spec.Cancelled = true
+		remotePr.Spec.CancelledBy = pr.Spec.CancelledBy
+		if err = (*rc.kc.OpsClient).Update(ctx, remotePr); err != nil {
+			logger.Error.Println(err, "failed to cancel pipelinerun in cluster "+cluster.Name)
+			return ctrl.Result{}, err
+		}
+	}
+	err = r.commitDispatchStatus(logger, ctx, pr, func(latestPr *opsv1.PipelineRun) {
+		status := *remotePr.Status.DeepCopy()
+		// keep Dispatched until the remote PipelineRun finished
+		if !finished {
+			status.RunStatus = opsconstants.StatusDispatched
+		}
+		if status.StartTime == nil {
+			status.StartTime = latestPr.Status.StartTime
+		}
+		status.Dispatch = latestPr.Status.Dispatch
+		if status.Dispatch != nil {
+			status.Dispatch.LastSyncTime = &metav1.Time{Time: time.Now()}
+		}
+		status.Conditions = latestPr.Status.Conditions
+		status.ScheduleStatus = latestPr.Status.ScheduleStatus
+		latestPr.Status = status
+		setClusterReachable(latestPr, metav1.ConditionTrue, opsconstants.ReasonSynced, fmt.Sprintf("synced from cluster %s", cluster.Name))
+	})
+	if err == nil && finished {
+		// send event
+		go opsevent.FactoryPipelineRun(pr.Namespace, pr.Name, opsconstants.Status).Publish(ctx, &opsevent.EventPipelineRun{
+			PipelineRef:       pr.Spec.PipelineRef,
+			Desc:              pr.Spec.Desc,
+			Variables:         pr.Spec.Variables,
+			PipelineRunStatus: remotePr.Status,
+		})
+	}
+	return ctrl.Result{}, err
+}
+
+// commitDispatchCondition records whether the cluster a PipelineRun is dispatched to can be reached
+func (r *PipelineRunReconciler) commitDispatchCondition(logger *opslog.Logger, ctx context.Context, pr *opsv1.PipelineRun, status metav1.ConditionStatus, reason, message string) error {
+	return r.commitDispatchStatus(logger, ctx, pr, func(latestPr *opsv1.PipelineRun) {
+		setClusterReachable(latestPr, status, reason, message)
+	})
+}
+
+func (r *PipelineRunReconciler) commitDispatchStatus(logger *opslog.Logger, ctx context.Context, pr *opsv1.PipelineRun, mutate func(latestPr *opsv1.PipelineRun)) (err error) {
+	for retries := 0; retries < CommitStatusMaxRetries; retries++ {
+		latestPr := &opsv1.PipelineRun{}
+		err = r.Client.Get(ctx, types.NamespacedName{Namespace: pr.Namespace, Name: pr.Name}, latestPr)
+		if err != nil {
+			logger.Error.Println(err)
+			return
+		}
+		oldStatus := latestPr.Status.RunStatus
+		if latestPr.Status.StartTime == nil {
+			latestPr.Status.StartTime = &metav1.Time{Time: time.Now()}
+		}
+		mutate(latestPr)
+		err = r.Client.Status().Update(ctx, latestPr)
+		if err == nil {
+			if oldStatus != latestPr.Status.RunStatus {
+				recordPipelineRunStatusMetrics(latestPr, oldStatus)
+			}
+			return
+		}
+		if !apierrors.IsConflict(err) {
+			logger.Error.Println(err, "update pipelinerun dispatch status error")
+			return
+		}
+	}
+	logger.Error.Println("update pipelinerun dispatch status failed after retries", err)
+	return
+}
+
+// isRemoteNotFound returns true if the PipelineRun sent to the cluster is deleted, a PipelineRun recreated with the same name is another one
+func isRemoteNotFound(pr, remotePr *opsv1.PipelineRun, err error) bool {
+	return apierrors.IsNotFound(err) || (err == nil && string(remotePr.UID) != pr.Status.Dispatch.RemoteUID)
+}
+
+// setRemoteNotFound fails the PipelineRun whose remote PipelineRun is deleted, it would wait for the sync forever otherwise
+func setRemoteNotFound(pr *opsv1.PipelineRun, message string) {
+	pr.Status.RunStatus = opsconstants.StatusFailed
+	setClusterReachable(pr, metav1.ConditionFalse, opsconstants.ReasonRemoteNotFound, message)
+}
+
+func setClusterReachable(pr *opsv1.PipelineRun, status metav1.ConditionStatus, reason, message string) {
+	apimeta.SetStatusCondition(&pr.Status.Conditions, metav1.Condition{
+		Type:               opsconstants.ConditionClusterReachable,
+		Status:             status,
+		ObservedGeneration: pr.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
+}
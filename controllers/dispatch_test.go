@@ -0,0 +1,87 @@
+/*
+Copyright 2024 shaowenchen.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"errors"
+	"testing"
+
+	opsv1 "github.com/shaowenchen/ops/api/v1"
+	opsconstants "github.com/shaowenchen/ops/pkg/constants"
+	opslog "github.com/shaowenchen/ops/pkg/log"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestRemoteDeletedPipelineRunFails(t *testing.T) {
+	pr := &opsv1.PipelineRun{}
+	pr.Status.RunStatus = opsconstants.StatusDispatched
+	pr.Status.Dispatch = &opsv1.PipelineRunDispatch{Cluster: "remote", RemoteUID: "uid-1"}
+	notFound := apierrors.NewNotFound(schema.GroupResource{Resource: "pipelineruns"}, "run")
+	tests := []struct {
+		name      string
+		remoteUID string
+		err       error
+		want      bool
+	}{
+		{"synced", "uid-1", nil, false},
+		{"deleted", "", notFound, true},
+		{"recreated with the same name", "uid-2", nil, true},
+		{"other error", "", errors.New("connection refused"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			remotePr := &opsv1.PipelineRun{ObjectMeta: metav1.ObjectMeta{UID: types.UID(tt.remoteUID)}}
+			if got := isRemoteNotFound(pr, remotePr, tt.err); got != tt.want {
+				t.Errorf("isRemoteNotFound() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	setRemoteNotFound(pr, "pipelinerun is not found in cluster remote")
+	if pr.Status.RunStatus != opsconstants.StatusFailed || pr.Status.Dispatch == nil {
+		t.Errorf("setRemoteNotFound() status = %s, dispatch = %v, want Failed and the dispatch kept", pr.Status.RunStatus, pr.Status.Dispatch)
+	}
+	condition := apimeta.FindStatusCondition(pr.Status.Conditions, opsconstants.ConditionClusterReachable)
+	if condition == nil || condition.Status != metav1.ConditionFalse || condition.Reason != opsconstants.ReasonRemoteNotFound {
+		t.Errorf("setRemoteNotFound() condition = %+v, want False with reason %s", condition, opsconstants.ReasonRemoteNotFound)
+	}
+}
+
+func TestPublishRemoteCluster(t *testing.T) {
+	logger := opslog.NewLogger().Build()
+	r := &PipelineRunReconciler{}
+	newCluster := func(resourceVersion string, cancelled *bool) *remoteCluster {
+		return &remoteCluster{resourceVersion: resourceVersion, cancel: func() { *cancelled = true }}
+	}
+	var firstCancelled, sameCancelled, changedCancelled bool
+	first := newCluster("1", &firstCancelled)
+	if got := r.publishRemoteCluster(logger, "remote", first); got != first {
+		t.Fatalf("publishRemoteCluster() does not publish the first informer")
+	}
+	// another reconcile synced the same version at the same time
+	if got := r.publishRemoteCluster(logger, "remote", newCluster("1", &sameCancelled)); got != first || !sameCancelled || firstCancelled {
+		t.Errorf("publishRemoteCluster() of the same version = %p, want the published %p and the new one stopped", got, first)
+	}
+	changed := newCluster("2", &changedCancelled)
+	if got := r.publishRemoteCluster(logger, "remote", changed); got != changed || !firstCancelled || changedCancelled {
+		t.Errorf("publishRemoteCluster() of a changed cluster = %p, want %p and the old one stopped", got, changed)
+	}
+}
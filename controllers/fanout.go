@@ -0,0 +1,226 @@
+/*
+Copyright 2024 shaowenchen.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	opsv1 "github.com/shaowenchen/ops/api/v1"
+	opsconstants "github.com/shaowenchen/ops/pkg/constants"
+	opsevent "github.com/shaowenchen/ops/pkg/event"
+	opslog "github.com/shaowenchen/ops/pkg/log"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	reasonClusterNotHealthy = "cluster is not healthy"
+	reasonNoHealthyCluster  = "no healthy cluster matches clusterSelector"
+)
+
+// fanOut runs a copy of the PipelineRun in every healthy Cluster matched by clusterSelector, at most maxParallelClusters at the same time
+// The clusters are selected on the first reconcile, so they are kept if the labels of clusters change or the controller restarts
+func (r *PipelineRunReconciler) fanOut(logger *opslog.Logger, ctx context.Context, pr *opsv1.PipelineRun) (ctrl.Result, error) {
+	origin := pr.Status.DeepCopy()
+	if pr.Status.RunStatus == opsconstants.StatusEmpty {
+		pr.Status.RunStatus = opsconstants.StatusRunning
+		pr.Status.StartTime = &metav1.Time{Time: time.Now()}
+		clusterStatus, err := r.selectClusters(ctx, pr)
+		if err != nil {
+			logger.Error.Println(err, "failed to select clusters")
+			pr.Status.RunStatus = opsconstants.StatusDataInValid
+			pr.Status.Reason = err.Error()
+		}
+		pr.Status.ClusterStatus = clusterStatus
+	}
+	// step the started clusters with their PipelineRuns
+	running, succeeded, failed := 0, 0, 0
+	for i := range pr.Status.ClusterStatus {
+		cs := &pr.Status.ClusterStatus[i]
+		if cs.PipelineRun != "" && !opsconstants.IsFinishedStatus(cs.RunStatus) {
+			child := &opsv1.PipelineRun{}
+			err := r.Client.Get(ctx, types.NamespacedName{Namespace: pr.Namespace, Name: cs.PipelineRun}, child)
+			if apierrors.IsNotFound(err) {
+				cs.RunStatus = opsconstants.StatusFailed
+				cs.Reason = fmt.Sprintf("pipelinerun %s is deleted", cs.PipelineRun)
+			} else if err != nil {
+				return ctrl.Result{}, err
+			} else {
+				if pr.Spec.Cancelled {
+					if err = r.cancelAttemptRun(ctx, attemptRun{pr: child}, "pipelinerun "+pr.Name); err != nil {
+						logger.Error.Println(err, "failed to cancel pipelinerun "+child.Name)
+					}
+				}
+				cs.RunStatus = child.Status.RunStatus
+				if cs.RunStatus == opsconstants.StatusEmpty {
+					cs.RunStatus = opsconstants.StatusRunning
+				}
+				cs.Reason = child.Status.Reason
+				cs.Results = getClusterResults(child)
+			}
+		}
+		switch {
+		case cs.RunStatus == opsconstants.StatusSuccessed:
+			succeeded++
+		case cs.RunStatus == opsconstants.StatusPending || cs.RunStatus == opsconstants.StatusSkipped:
+		case opsconstants.IsFinishedStatus(cs.RunStatus):
+			failed++
+		default:
+			running++
+		}
+	}
+	// start the next clusters
+	tooManyFailed := pr.Spec.MaxFailedClusters != nil && failed > int(*pr.Spec.MaxFailedClusters)
+	for i := range pr.Status.ClusterStatus {
+		cs := &pr.Status.ClusterStatus[i]
+		if cs.RunStatus != opsconstants.StatusPending {
+			continue
+		}
+		if pr.Spec.Cancelled {
+			cs.RunStatus = opsconstants.StatusSkipped
+			cs.Reason = pr.Spec.GetCancelledReason()
+			continue
+		}
+		if tooManyFailed {
+			cs.RunStatus = opsconstants.StatusSkipped
+			cs.Reason = fmt.Sprintf("%d clusters failed, more than maxFailedClusters %d", failed, *pr.Spec.MaxFailedClusters)
+			continue
+		}
+		if pr.Spec.MaxParallelClusters > 0 && running >= pr.Spec.MaxParallelClusters {
+			break
+		}
+		child := opsv1.NewPipelineRunForCluster(pr, cs.Cluster)
+		err := r.Client.Create(ctx, child)
+		if err != nil && !apierrors.IsAlreadyExists(err) {
+			logger.Error.Println(err, "failed to create pipelinerun for cluster "+cs.Cluster)
+			cs.RunStatus = opsconstants.StatusFailed
+			cs.Reason = err.Error()
+			failed++
+			continue
+		}
+		logger.Info.Printf("run pipelinerun %s in cluster %s", child.Name, cs.Cluster)
+		cs.PipelineRun = child.Name
+		cs.RunStatus = opsconstants.StatusRunning
+		cs.StartTime = &metav1.Time{Time: time.Now()}
+		running++
+	}
+	pending := 0
+	for _, cs := range pr.Status.ClusterStatus {
+		if cs.RunStatus == opsconstants.StatusPending {
+			pending++
+		}
+	}
+	finished := opsconstants.IsFinishedStatus(pr.Status.RunStatus) || (running == 0 && pending == 0)
+	if finished && !opsconstants.IsFinishedStatus(pr.Status.RunStatus) {
+		switch {
+		case pr.Spec.Cancelled:
+			pr.Status.RunStatus = opsconstants.StatusAborted
+			pr.Status.Reason = pr.Spec.GetCancelledReason()
+		case failed > 0:
+			pr.Status.RunStatus = opsconstants.StatusFailed
+			pr.Status.Reason = fmt.Sprintf("%d of %d clusters failed", failed, len(pr.Status.ClusterStatus))
+		case succeeded == 0:
+			pr.Status.RunStatus = opsconstants.StatusFailed
+			pr.Status.Reason = reasonNoHealthyCluster
+		default:
+			pr.Status.RunStatus = opsconstants.StatusSuccessed
+		}
+	}
+	if !equality.Semantic.DeepEqual(origin, &pr.Status) {
+		err := r.Client.Status().Update(ctx, pr)
+		if apierrors.IsConflict(err) {
+			// PipelineRuns of clusters have stable names, so fanning out again from the latest status is safe
+			logger.Info.Println("conflict detected, fan out pipelinerun again", pr.GetUniqueKey())
+			return ctrl.Result{Requeue: true}, nil
+		}
+		if err != nil {
+			logger.Error.Println(err, "update pipelinerun status error")
+			return ctrl.Result{}, err
+		}
+		if origin.RunStatus != pr.Status.RunStatus {
+			recordPipelineRunStatusMetrics(pr, origin.RunStatus)
+		}
+	}
+	if finished {
+		logger.Info.Printf("pipelinerun %s finished with %s", pr.GetUniqueKey(), pr.Status.RunStatus)
+		// push event
+		go opsevent.FactoryPipelineRun(pr.Namespace, pr.Name, opsconstants.Status).Publish(ctx, opsevent.EventPipelineRun{
+			PipelineRef:       pr.Spec.PipelineRef,
+			Desc:              pr.Spec.Desc,
+			Variables:         pr.Spec.Variables,
+			PipelineRunStatus: pr.Status,
+		})
+		return ctrl.Result{}, nil
+	}
+	// the PipelineRuns of clusters trigger the next step, resync in case an event is missed
+	return ctrl.Result{RequeueAfter: pipelineRunResyncPeriod}, nil
+}
+
+// getClusterResults returns the results of the tasks of the PipelineRun of a cluster as map[task.result]value
+func getClusterResults(child *opsv1.PipelineRun) map[string]string {
+	var results map[string]string
+	for task, taskResults := range getTaskResults(child) {
+		for result, value := range taskResults {
+			if results == nil {
+				results = make(map[string]string)
+			}
+			results[task+"."+result] = value
+		}
+	}
+	return results
+}
+
+// selectClusters returns the clusters matched by clusterSelector sorted by name, the unhealthy ones are skipped
+func (r *PipelineRunReconciler) selectClusters(ctx context.Context, pr *opsv1.PipelineRun) ([]opsv1.PipelineRunClusterStatus, error) {
+	selector, err := metav1.LabelSelectorAsSelector(pr.Spec.ClusterSelector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid clusterSelector: %v", err)
+	}
+	clusterList := &opsv1.ClusterList{}
+	err = r.Client.List(ctx, clusterList, client.MatchingLabelsSelector{Selector: selector})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(clusterList.Items, func(i, j int) bool {
+		return clusterList.Items[i].Name < clusterList.Items[j].Name
+	})
+	clusterStatus := []opsv1.PipelineRunClusterStatus{}
+	seen := make(map[string]bool)
+	for _, c := range clusterList.Items {
+		if seen[c.Name] {
+			continue
+		}
+		seen[c.Name] = true
+		cs := opsv1.PipelineRunClusterStatus{
+			Cluster:   c.Name,
+			RunStatus: opsconstants.StatusPending,
+		}
+		if !c.IsHealthy() {
+			cs.RunStatus = opsconstants.StatusSkipped
+			cs.Reason = reasonClusterNotHealthy
+		}
+		clusterStatus = append(clusterStatus, cs)
+	}
+	return clusterStatus, nil
+}
@@ -0,0 +1,58 @@
+/*
+Copyright 2024 shaowenchen.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	opsv1 "github.com/shaowenchen/ops/api/v1"
+)
+
+func TestGetClusterResults(t *testing.T) {
+	tests := []struct {
+		name        string
+		taskResults map[string]map[string]string
+		want        map[string]string
+	}{
+		{
+			name: "no results",
+			want: nil,
+		},
+		{
+			name: "results of tasks",
+			taskResults: map[string]map[string]string{
+				"check":   {"version": "v1.2.0", "version.node1": "v1.2.0"},
+				"upgrade": {"upgraded": "true"},
+				"notify":  nil,
+			},
+			want: map[string]string{"check.version": "v1.2.0", "check.version.node1": "v1.2.0", "upgrade.upgraded": "true"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			child := &opsv1.PipelineRun{}
+			for task, results := range tt.taskResults {
+				child.Status.InitPipelineRunTaskStatus(task, task, nil)
+				child.Status.GetPipelineRunTaskStatus(task).Results = results
+			}
+			if got := getClusterResults(child); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getClusterResults() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -116,7 +116,23 @@ func (r *PipelineReconciler) filledVariables(logger *opslog.Logger, ctx context.
 	// get tasks
 	taskList := []opsv1.Task{}
 	taskMap := make(map[string]opsv1.Task) // map[taskRef]Task
-	for _, t := range obj.Spec.Tasks {
+	for _, t := range obj.GetAllTasks() {
+		if t.PipelineRef != "" {
+			// the variables of a nested pipeline are passed by the parent
+			child := opsv1.Pipeline{}
+			err := r.Client.Get(ctx, types.NamespacedName{Namespace: obj.Namespace, Name: t.PipelineRef}, &child)
+			if err != nil {
+				logger.Error.Println(err, "failed to get pipeline")
+				return false
+			}
+			if obj.MergeVariables(child.Spec.Variables) {
+				changed = true
+			}
+			continue
+		}
+		if t.Approval != nil {
+			continue
+		}
 		task := opsv1.Task{}
 		err := r.Client.Get(ctx, types.NamespacedName{Namespace: obj.Namespace, Name: t.TaskRef}, &task)
 		if err != nil {
@@ -19,8 +19,11 @@ package controllers
 import (
 	"context"
 	"fmt"
+	"hash/fnv"
 	"math/rand"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -31,20 +34,25 @@ import (
 	opsv1 "github.com/shaowenchen/ops/api/v1"
 	opsconstants "github.com/shaowenchen/ops/pkg/constants"
 	opsevent "github.com/shaowenchen/ops/pkg/event"
-	opskube "github.com/shaowenchen/ops/pkg/kube"
+	opsexpr "github.com/shaowenchen/ops/pkg/expr"
 	opslog "github.com/shaowenchen/ops/pkg/log"
+	opslogstore "github.com/shaowenchen/ops/pkg/logstore"
 	opsmetrics "github.com/shaowenchen/ops/pkg/metrics"
 	opstask "github.com/shaowenchen/ops/pkg/task"
 	opsutils "github.com/shaowenchen/ops/pkg/utils"
+	"k8s.io/apimachinery/pkg/api/equality"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
 const CommitStatusMaxRetries = 5
@@ -57,6 +65,10 @@ type PipelineRunReconciler struct {
 	crontabMapMutex sync.RWMutex
 	cron            *cron.Cron
 	clearCron       *cron.Cron
+	// informers of the clusters that PipelineRuns are dispatched to, they trigger the sync by remoteEvents
+	remoteClusters      map[string]*remoteCluster
+	remoteClustersMutex sync.Mutex
+	remoteEvents        chan event.GenericEvent
 }
 
 //+kubebuilder:rbac:groups=crd.chenshaowen.com,resources=pipelineruns,verbs=get;list;watch;create;update;patch;delete
@@ -117,68 +129,18 @@ func (r *PipelineRunReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		return ctrl.Result{}, err
 	}
 	if opsconstants.IsFinishedStatus(pr.Status.RunStatus) {
+		// a scheduled pipelinerun is Successed once its timer started, start the timer again after the controller restarted
+		if pr.Spec.Crontab != "" && r.isOtherCluster(pr) == nil {
+			r.addCronTab(logger, ctx, pr)
+		}
 		return ctrl.Result{}, nil
 	}
-	// insert env
+	// insert env, it is generated once and kept in status, the status of a new run is written when it starts
 	pr.SetEnv()
 	// if is others cluster, send and just sync status
 	cluster := r.isOtherCluster(pr)
-	if cluster != nil {
-		// send pr
-		logger.Info.Printf("send pipelinerun %s to cluster %s", pr.Name, cluster.Name)
-		kc, err := opskube.NewClusterConnection(cluster)
-		if err != nil {
-			logger.Error.Println(err, "failed to create cluster connection")
-			return ctrl.Result{}, err
-		}
-		pr.SetCurrentCluster()
-		err = kc.CreatePipelineRun(pr)
-		if err != nil {
-			logger.Error.Println(err, "failed to create pr")
-			return ctrl.Result{}, err
-		}
-		r.commitStatus(logger, ctx, pr, opsconstants.StatusDispatched, "", "", nil)
-		MaxTimes := 60 / 3 * 30
-		go func() {
-			for retries := 0; retries < MaxTimes; retries++ {
-				time.Sleep(3 * time.Second)
-				logger.Info.Printf("check pipelinerun %s status, times %d", pr.Name, retries)
-				othersPr := &opsv1.PipelineRun{
-					ObjectMeta: metav1.ObjectMeta{
-						Namespace: pr.Namespace,
-						Name:      pr.Name,
-					},
-				}
-				err = kc.GetPipelineRun(othersPr)
-				if err != nil {
-					logger.Error.Println(err, "failed to get others pr")
-					return
-				} else if !opsconstants.IsFinishedStatus(othersPr.Status.RunStatus) {
-					continue
-				}
-				currentPR := &opsv1.PipelineRun{}
-				err = r.Client.Get(ctx, req.NamespacedName, currentPR)
-				if err != nil {
-					logger.Error.Println(err, "failed to get current pr")
-					return
-				}
-				currentPR.Status = othersPr.Status
-				err = r.Client.Status().Update(ctx, currentPR)
-				if err != nil {
-					logger.Error.Println(err, "failed to update current pr")
-					return
-				}
-				// send event
-				go opsevent.FactoryPipelineRun(pr.Namespace, pr.Name, opsconstants.Status).Publish(ctx, &opsevent.EventPipelineRun{
-					PipelineRef:       pr.Spec.PipelineRef,
-					Desc:              pr.Spec.Desc,
-					Variables:         pr.Spec.Variables,
-					PipelineRunStatus: pr.Status,
-				})
-				return
-			}
-		}()
-		return ctrl.Result{}, nil
+	if cluster != nil && pr.Spec.ClusterSelector == nil {
+		return r.dispatch(logger, ctx, cluster, pr)
 	}
 	// else is this cluster
 	// add crontab
@@ -190,9 +152,13 @@ func (r *PipelineRunReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		return ctrl.Result{}, nil
 	}
 	// had run once, skip
-	if !(pr.Status.RunStatus == opsconstants.StatusEmpty || pr.Status.RunStatus == opsconstants.StatusRunning) {
+	if !(pr.Status.RunStatus == opsconstants.StatusEmpty || pr.Status.RunStatus == opsconstants.StatusRunning || pr.Status.RunStatus == opsconstants.StatusWaitingApproval) {
 		return ctrl.Result{}, nil
 	}
+	// run in every cluster matched
+	if pr.Spec.ClusterSelector != nil {
+		return r.fanOut(logger, ctx, pr)
+	}
 	// get pipeline
 	p := &opsv1.Pipeline{}
 	err = r.Client.Get(ctx, types.NamespacedName{Namespace: pr.Namespace, Name: pr.Spec.PipelineRef}, p)
@@ -200,15 +166,11 @@ func (r *PipelineRunReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		r.commitStatus(logger, ctx, pr, opsconstants.StatusFailed, "", "", nil)
 		return ctrl.Result{}, err
 	}
+	// the state of a task is kept by its name, eg: restart -> check -> restart
+	p.SetDefaultTaskNames()
 
-	// run pipeline (no crontab)
-	err = r.run(logger, ctx, p, pr)
-	if err != nil {
-		return ctrl.Result{}, err
-	}
-	// send event
-	err = opsevent.FactoryPipelineRun(pr.Namespace, pr.Name).Publish(ctx, pr)
-	return ctrl.Result{}, err
+	// advance pipeline (no crontab), the TaskRuns it owns trigger the next step
+	return r.advance(logger, ctx, p, pr)
 }
 
 func (r *PipelineRunReconciler) isOtherCluster(pr *opsv1.PipelineRun) *opsv1.Cluster {
@@ -276,93 +238,183 @@ func (r *PipelineRunReconciler) addCronTab(logger *opslog.Logger, ctx context.Co
 
 	logger.Info.Println(fmt.Sprintf("add ticker for pipelinerun %s with crontab %s", key, objRun.Spec.Crontab))
 
-	id, err := r.cron.AddFunc(objRun.Spec.Crontab, func() {
-		time.Sleep(time.Duration(rand.Intn(opsconstants.SyncCronRandomBias)) * time.Second)
-		// get the scheduled PipelineRun to verify it still exists
-		scheduledPr := &opsv1.PipelineRun{}
-		err := r.Client.Get(ctx, types.NamespacedName{Namespace: objRun.Namespace, Name: objRun.Name}, scheduledPr)
-		if err != nil {
-			logger.Error.Println(err)
-			return
+	namespacedName := types.NamespacedName{Namespace: objRun.Namespace, Name: objRun.Name}
+	id, err := r.cron.AddFunc(objRun.Spec.GetCronSpec(objRun.Spec.Crontab), func() {
+		r.runSchedule(logger, ctx, namespacedName, time.Now())
+	})
+	if err != nil {
+		logger.Error.Println(err)
+		return
+	}
+
+	r.crontabMapMutex.Lock()
+	r.crontabMap[key] = id
+	r.crontabMapMutex.Unlock()
+
+	// run the schedule missed while the ticker was not added, eg: the controller restarted
+	if !exists && objRun.Spec.StartingDeadlineSeconds != nil {
+		last := objRun.CreationTimestamp.Time
+		if objRun.Status.LastScheduleTime != nil {
+			last = objRun.Status.LastScheduleTime.Time
 		}
-		// verify it still has crontab
-		if scheduledPr.Spec.Crontab == "" {
-			logger.Info.Println(fmt.Sprintf("skip pipelinerun %s: crontab removed", scheduledPr.GetUniqueKey()))
-			return
+		missed, err := getMissedScheduleTime(objRun.Spec.GetCronSpec(objRun.Spec.Crontab), last, time.Now())
+		if err == nil && !missed.IsZero() && !objRun.Spec.IsStartingDeadlineExceeded(missed, time.Now()) {
+			logger.Info.Println(fmt.Sprintf("run missed schedule %s for pipelinerun %s", missed.Format(time.RFC3339), key))
+			go r.runSchedule(logger, ctx, namespacedName, missed)
 		}
-		// check if there's already a running instance created by this scheduled pipelinerun
-		pipelineRunList := &opsv1.PipelineRunList{}
-		labelSelector := client.MatchingLabels{
-			opsconstants.LabelScheduledByKey:   scheduledPr.Name,
-			opsconstants.LabelScheduledKindKey: opsconstants.PipelineRun,
+	}
+}
+
+// runSchedule creates a PipelineRun for a schedule of the scheduled pipelinerun following its concurrency policy,
+// then removes the runs beyond the history limits
+func (r *PipelineRunReconciler) runSchedule(logger *opslog.Logger, ctx context.Context, namespacedName types.NamespacedName, scheduledAt time.Time) {
+	// the random delay does not count for the starting deadline
+	triggeredAt := time.Now()
+	time.Sleep(time.Duration(rand.Intn(opsconstants.SyncCronRandomBias)) * time.Second)
+	// get the scheduled PipelineRun to verify it still exists
+	scheduledPr := &opsv1.PipelineRun{}
+	err := r.Client.Get(ctx, namespacedName, scheduledPr)
+	if err != nil {
+		logger.Error.Println(err)
+		return
+	}
+	// verify it still has crontab
+	if scheduledPr.Spec.Crontab == "" {
+		logger.Info.Println(fmt.Sprintf("skip pipelinerun %s: crontab removed", scheduledPr.GetUniqueKey()))
+		return
+	}
+	if scheduledPr.Spec.Suspend {
+		logger.Info.Println(fmt.Sprintf("skip pipelinerun %s: suspended", scheduledPr.GetUniqueKey()))
+		return
+	}
+	if scheduledPr.Spec.IsStartingDeadlineExceeded(scheduledAt, triggeredAt) {
+		logger.Info.Println(fmt.Sprintf("skip pipelinerun %s: missed starting deadline of schedule %s", scheduledPr.GetUniqueKey(), scheduledAt.Format(time.RFC3339)))
+		return
+	}
+	// check if there's already a running instance created by this scheduled pipelinerun
+	pipelineRunList := &opsv1.PipelineRunList{}
+	labelSelector := client.MatchingLabels{
+		opsconstants.LabelScheduledByKey:   scheduledPr.Name,
+		opsconstants.LabelScheduledKindKey: opsconstants.PipelineRun,
+	}
+	err = r.Client.List(ctx, pipelineRunList, client.InNamespace(scheduledPr.Namespace), labelSelector)
+	if err != nil {
+		logger.Error.Println(fmt.Sprintf("failed to list pipelineruns: %v", err))
+		return
+	}
+	for i := range pipelineRunList.Items {
+		pr := &pipelineRunList.Items[i]
+		if opsconstants.IsFinishedStatus(pr.Status.RunStatus) {
+			continue
 		}
-		err = r.Client.List(ctx, pipelineRunList, client.InNamespace(scheduledPr.Namespace), labelSelector)
-		if err != nil {
-			logger.Error.Println(fmt.Sprintf("failed to list pipelineruns: %v", err))
+		switch scheduledPr.Spec.GetConcurrencyPolicy() {
+		case opsconstants.ConcurrencyPolicyForbid:
+			logger.Info.Println(fmt.Sprintf("skip pipelinerun %s: already has a running instance %s", scheduledPr.GetUniqueKey(), pr.GetUniqueKey()))
 			return
-		}
-		for _, pr := range pipelineRunList.Items {
-			// check if this pipelinerun is running
-			if pr.Status.RunStatus == opsconstants.StatusRunning || pr.Status.RunStatus == opsconstants.StatusEmpty {
-				logger.Info.Println(fmt.Sprintf("skip pipelinerun %s: already has a running instance %s", scheduledPr.GetUniqueKey(), pr.GetUniqueKey()))
-				return
+		case opsconstants.ConcurrencyPolicyReplace:
+			logger.Info.Println(fmt.Sprintf("cancel pipelinerun %s replaced by a new instance of %s", pr.GetUniqueKey(), scheduledPr.GetUniqueKey()))
+			pr.Spec.Cancelled = true
+			pr.Spec.CancelledBy = "schedule " + scheduledPr.Name
+			if err := r.Client.Update(ctx, pr); err != nil {
+				logger.Error.Println(fmt.Sprintf("failed to cancel pipelinerun %s: %v", pr.GetUniqueKey(), err))
 			}
 		}
-		logger.Info.Println(fmt.Sprintf("cron triggered for pipelinerun %s, creating new execution instance", scheduledPr.GetUniqueKey()))
-		// get pipeline
-		p := &opsv1.Pipeline{}
-		err = r.Client.Get(ctx, types.NamespacedName{Namespace: scheduledPr.Namespace, Name: scheduledPr.Spec.PipelineRef}, p)
+	}
+	logger.Info.Println(fmt.Sprintf("cron triggered for pipelinerun %s, creating new execution instance", scheduledPr.GetUniqueKey()))
+	// get pipeline
+	p := &opsv1.Pipeline{}
+	err = r.Client.Get(ctx, types.NamespacedName{Namespace: scheduledPr.Namespace, Name: scheduledPr.Spec.PipelineRef}, p)
+	if err != nil {
+		logger.Error.Println(err)
+		return
+	}
+	// create a new PipelineRun without crontab for execution
+	newPr := opsv1.NewPipelineRun(p)
+	newPr.Spec.Crontab = "" // ensure no crontab
+	// copy variables from scheduled pipelinerun (deep copy)
+	if scheduledPr.Spec.Variables != nil {
+		newPr.Spec.Variables = make(map[string]string)
+		for k, v := range scheduledPr.Spec.Variables {
+			newPr.Spec.Variables[k] = v
+		}
+	}
+	newPr.Spec.Desc = scheduledPr.Spec.Desc // copy desc
+	newPr.Spec.ClusterSelector = scheduledPr.Spec.ClusterSelector
+	newPr.Spec.MaxParallelClusters = scheduledPr.Spec.MaxParallelClusters
+	newPr.Spec.MaxFailedClusters = scheduledPr.Spec.MaxFailedClusters
+	// ensure labels map exists (NewPipelineRun already creates it with LabelPipelineRefKey)
+	if newPr.Labels == nil {
+		newPr.Labels = make(map[string]string)
+	}
+	// ensure pipelineref label is set
+	newPr.Labels[opsconstants.LabelPipelineRefKey] = p.Name
+	// set labels to identify this is created by the scheduled pipelinerun
+	newPr.Labels[opsconstants.LabelScheduledByKey] = scheduledPr.Name
+	newPr.Labels[opsconstants.LabelScheduledKindKey] = opsconstants.PipelineRun
+	// set owner reference to the scheduled pipelinerun
+	if scheduledPr.UID != "" {
+		newPr.OwnerReferences = []metav1.OwnerReference{
+			{
+				APIVersion: opsconstants.APIVersion,
+				Kind:       opsconstants.PipelineRun,
+				Name:       scheduledPr.Name,
+				UID:        scheduledPr.UID,
+			},
+		}
+	}
+	// create the new pipelinerun
+	err = r.Client.Create(ctx, newPr)
+	if err != nil {
+		logger.Error.Println(fmt.Sprintf("failed to create new pipelinerun for scheduled pipelinerun %s: %v", scheduledPr.GetUniqueKey(), err))
+		return
+	}
+	logger.Info.Println(fmt.Sprintf("created new pipelinerun %s for scheduled pipelinerun %s", newPr.GetUniqueKey(), scheduledPr.GetUniqueKey()))
+	r.commitScheduleStatus(logger, ctx, scheduledPr, scheduledAt, newPr.Name)
+	// remove the runs beyond the history limits
+	runs := []scheduledRun{}
+	for i := range pipelineRunList.Items {
+		runs = append(runs, scheduledRun{obj: &pipelineRunList.Items[i], runStatus: pipelineRunList.Items[i].Status.RunStatus})
+	}
+	for _, obj := range getRunsOverHistoryLimit(&scheduledPr.Spec.ScheduleSpec, runs) {
+		logger.Info.Println(fmt.Sprintf("remove pipelinerun %s/%s beyond the history limit of %s", obj.GetNamespace(), obj.GetName(), scheduledPr.GetUniqueKey()))
+		if err := r.Client.Delete(ctx, obj); err != nil && !apierrors.IsNotFound(err) {
+			logger.Error.Println(err)
+		}
+	}
+}
+
+// commitScheduleStatus records the last run created by a scheduled pipelinerun
+func (r *PipelineRunReconciler) commitScheduleStatus(logger *opslog.Logger, ctx context.Context, scheduledPr *opsv1.PipelineRun, scheduledAt time.Time, runName string) (err error) {
+	for retries := 0; retries < CommitStatusMaxRetries; retries++ {
+		latestPr := &opsv1.PipelineRun{}
+		err = r.Client.Get(ctx, types.NamespacedName{Namespace: scheduledPr.Namespace, Name: scheduledPr.Name}, latestPr)
 		if err != nil {
 			logger.Error.Println(err)
 			return
 		}
-		// create a new PipelineRun without crontab for execution
-		newPr := opsv1.NewPipelineRun(p)
-		newPr.Spec.Crontab = "" // ensure no crontab
-		// copy variables from scheduled pipelinerun (deep copy)
-		if scheduledPr.Spec.Variables != nil {
-			newPr.Spec.Variables = make(map[string]string)
-			for k, v := range scheduledPr.Spec.Variables {
-				newPr.Spec.Variables[k] = v
-			}
-		}
-		newPr.Spec.Desc = scheduledPr.Spec.Desc // copy desc
-		// ensure labels map exists (NewPipelineRun already creates it with LabelPipelineRefKey)
-		if newPr.Labels == nil {
-			newPr.Labels = make(map[string]string)
-		}
-		// ensure pipelineref label is set
-		newPr.Labels[opsconstants.LabelPipelineRefKey] = p.Name
-		// set labels to identify this is created by the scheduled pipelinerun
-		newPr.Labels[opsconstants.LabelScheduledByKey] = scheduledPr.Name
-		newPr.Labels[opsconstants.LabelScheduledKindKey] = opsconstants.PipelineRun
-		// set owner reference to the scheduled pipelinerun
-		if scheduledPr.UID != "" {
-			newPr.OwnerReferences = []metav1.OwnerReference{
-				{
-					APIVersion: opsconstants.APIVersion,
-					Kind:       opsconstants.PipelineRun,
-					Name:       scheduledPr.Name,
-					UID:        scheduledPr.UID,
-				},
+		latestPr.Status.LastScheduleTime = &metav1.Time{Time: scheduledAt}
+		latestPr.Status.LastRunName = runName
+		err = r.Client.Status().Update(ctx, latestPr)
+		if !apierrors.IsConflict(err) {
+			if err != nil {
+				logger.Error.Println(err, "update pipelinerun schedule status error")
 			}
-		}
-		// create the new pipelinerun
-		err = r.Client.Create(ctx, newPr)
-		if err != nil {
-			logger.Error.Println(fmt.Sprintf("failed to create new pipelinerun for scheduled pipelinerun %s: %v", scheduledPr.GetUniqueKey(), err))
 			return
 		}
-		logger.Info.Println(fmt.Sprintf("created new pipelinerun %s for scheduled pipelinerun %s", newPr.GetUniqueKey(), scheduledPr.GetUniqueKey()))
-	})
-	if err != nil {
-		logger.Error.Println(err)
-		return
 	}
+	logger.Error.Println("update pipelinerun schedule status failed after retries", err)
+	return
+}
 
-	r.crontabMapMutex.Lock()
-	r.crontabMap[key] = id
-	r.crontabMapMutex.Unlock()
+// isKeptByHistoryLimit returns true if a pipelinerun is created by a scheduled pipelinerun with history limits
+func (r *PipelineRunReconciler) isKeptByHistoryLimit(pr *opsv1.PipelineRun) bool {
+	scheduledBy := pr.Labels[opsconstants.LabelScheduledByKey]
+	if scheduledBy == "" {
+		return false
+	}
+	scheduledPr := &opsv1.PipelineRun{}
+	err := r.Client.Get(context.Background(), types.NamespacedName{Namespace: pr.Namespace, Name: scheduledBy}, scheduledPr)
+	return err == nil && scheduledPr.Spec.HasHistoryLimit()
 }
 
 func (r *PipelineRunReconciler) registerClearCron() {
@@ -377,7 +429,14 @@ func (r *PipelineRunReconciler) registerClearCron() {
 			return
 		}
 		for _, obj := range objs.Items {
-			if obj.Status.RunStatus == opsconstants.StatusRunning || obj.Status.RunStatus == opsconstants.StatusEmpty {
+			if obj.Spec.Crontab != "" {
+				continue
+			}
+			// the runs of a schedule with history limits are removed by the limits
+			if r.isKeptByHistoryLimit(&obj) {
+				continue
+			}
+			if obj.Status.RunStatus == opsconstants.StatusRunning || obj.Status.RunStatus == opsconstants.StatusWaitingApproval || obj.Status.RunStatus == opsconstants.StatusEmpty {
 				continue
 			}
 			if obj.GetObjectMeta().GetCreationTimestamp().Add(opsconstants.DefaultTTLSecondsAfterFinished * time.Second).After(time.Now()) {
@@ -391,9 +450,9 @@ func (r *PipelineRunReconciler) registerClearCron() {
 
 // buildTaskRunVariables builds variables for TaskRun by filtering pipeline variables
 // based on task requirements and merging TaskRef variables
-func (r *PipelineRunReconciler) buildTaskRunVariables(pr *opsv1.PipelineRun, t *opsv1.Task, tRef opsv1.TaskRef, ctx context.Context) map[string]string {
+func (r *PipelineRunReconciler) buildTaskRunVariables(pr *opsv1.PipelineRun, t *opsv1.Task, tRef opsv1.TaskRef) map[string]string {
 	requiredVars := opstask.GetTaskRequiredVariables(t)
-	taskResults := r.getTaskResults(pr, ctx)
+	taskResults := getTaskResults(pr)
 	vars := make(map[string]string)
 
 	// Filter pipeline variables to only include what task needs
@@ -408,18 +467,20 @@ func (r *PipelineRunReconciler) buildTaskRunVariables(pr *opsv1.PipelineRun, t *
 		vars[opsconstants.HostLower] = opstask.RenderStringWithPathRefs(hostVal, pr.Spec.Variables, taskResults)
 	}
 
+	// Include the outcome of tasks for finally tasks
+	for _, k := range []string{opsconstants.VariablePipelineStatus, opsconstants.VariablePipelineFailedTask} {
+		if v, ok := pr.Spec.Variables[k]; ok {
+			vars[k] = v
+		}
+	}
+
 	return vars
 }
 
 // getTaskResults extracts task results from PipelineRun status
-func (r *PipelineRunReconciler) getTaskResults(pr *opsv1.PipelineRun, ctx context.Context) map[string]map[string]string {
-	latestPr := &opsv1.PipelineRun{}
-	if err := r.Client.Get(ctx, types.NamespacedName{Namespace: pr.Namespace, Name: pr.Name}, latestPr); err != nil {
-		return nil
-	}
-
+func getTaskResults(pr *opsv1.PipelineRun) map[string]map[string]string {
 	taskResults := make(map[string]map[string]string)
-	for _, taskStatus := range latestPr.Status.PipelineRunStatus {
+	for _, taskStatus := range pr.Status.PipelineRunStatus {
 		if len(taskStatus.Results) > 0 {
 			taskResults[taskStatus.TaskName] = taskStatus.Results
 		}
@@ -427,135 +488,918 @@ func (r *PipelineRunReconciler) getTaskResults(pr *opsv1.PipelineRun, ctx contex
 	return taskResults
 }
 
-func (r *PipelineRunReconciler) run(logger *opslog.Logger, ctx context.Context, p *opsv1.Pipeline, pr *opsv1.PipelineRun) (err error) {
-	runAlways := false
+const (
+	reasonPipelineTimeout = "pipeline timeout"
+	reasonTaskTimeout     = "task timeout"
+	reasonPreviousFailed  = "previous task failed"
+	reasonApprovalTimeout = "approval timeout"
+	reasonDryRun          = "approved in dry run"
+	// pipelineRunResyncPeriod reconciles a running PipelineRun again in case an event of TaskRun is missed
+	pipelineRunResyncPeriod = time.Minute
+	// taskRunNotFoundGracePeriod tolerates the cache missing a TaskRun just created
+	taskRunNotFoundGracePeriod = time.Minute
+)
+
+// pipelineRunState is rebuilt on every reconcile, the progress of a PipelineRun is only kept in its status
+type pipelineRunState struct {
+	pr        *opsv1.PipelineRun
+	p         *opsv1.Pipeline
+	variables map[string]string // variables of spec, results of tasks are added on top of them
+	outcome   map[string]string // pipeline.status and pipeline.failedTask for finally tasks
+	finally   bool              // finally tasks are advanced
+	requeueAt time.Time
+}
+
+// isCancelled returns true if the tasks advanced are cancelled, finally tasks still run once the PipelineRun is cancelled
+func (s *pipelineRunState) isCancelled() bool {
+	return s.pr.Spec.Cancelled && !s.finally
+}
+
+// wakeAt asks for a reconcile at t if no TaskRun changes before
+func (s *pipelineRunState) wakeAt(t time.Time) {
+	if t.IsZero() {
+		return
+	}
+	if s.requeueAt.IsZero() || t.Before(s.requeueAt) {
+		s.requeueAt = t
+	}
+}
+
+// getTaskState returns the state of a task in the graph
+func (s *pipelineRunState) getTaskState(name string) string {
+	ts := s.pr.Status.GetPipelineRunTaskStatus(name)
+	if ts == nil {
+		return opsconstants.StatusPending
+	}
+	return ts.RunStatus
+}
+
+// getTaskStates returns the state of each task in the graph
+func (s *pipelineRunState) getTaskStates(tasks []opsv1.TaskRef) []string {
+	states := make([]string, len(tasks))
+	for i, tRef := range tasks {
+		states[i] = s.getTaskState(tRef.GetName())
+	}
+	return states
+}
+
+// setTaskState sets the state of a task in the graph and why
+func (s *pipelineRunState) setTaskState(tRef opsv1.TaskRef, state, reason string) {
+	s.pr.Status.SetPipelineRunTaskState(tRef.GetName(), tRef.TaskRef, state, reason)
+}
+
+// isWaitingApproval returns true if any approval task waits for a decision
+func (s *pipelineRunState) isWaitingApproval() bool {
+	for _, ts := range s.pr.Status.PipelineRunStatus {
+		if ts.RunStatus == opsconstants.StatusWaitingApproval {
+			return true
+		}
+	}
+	return false
+}
+
+// refreshVariables rebuilds the variables of PipelineRun with the results of finished tasks
+func (s *pipelineRunState) refreshVariables() {
+	vars := make(map[string]string, len(s.variables))
+	for k, v := range s.variables {
+		vars[k] = v
+	}
 	latestTrOuput := ""
-	for _, tRef := range p.Spec.Tasks {
-		if runAlways && !tRef.RunAlways {
+	for _, tRef := range s.p.GetAllTasks() {
+		ts := s.pr.Status.GetPipelineRunTaskStatus(tRef.GetName())
+		if ts == nil || ts.RunStatus != opsconstants.StatusSuccessed || len(tRef.Matrix) > 0 {
 			continue
 		}
-		// create taskrun
-		t := &opsv1.Task{}
-		err = r.Client.Get(ctx, types.NamespacedName{Namespace: pr.Namespace, Name: tRef.TaskRef}, t)
+		// Also add results to PipelineRun variables for direct reference, the values per node are only referenced by path
+		for k := range tRef.Results {
+			if v, ok := ts.Results[k]; ok {
+				vars[k] = v
+			}
+		}
+		if ts.TaskRunStatus != nil && len(ts.TaskRunStatus.TaskRunNodeStatus) == 1 {
+			for _, nodeStatus := range ts.TaskRunStatus.TaskRunNodeStatus {
+				if len(nodeStatus.TaskRunStep) > 0 {
+					latestTrOuput = nodeStatus.TaskRunStep[len(nodeStatus.TaskRunStep)-1].StepOutput
+				}
+			}
+		}
+	}
+	// patch latest tr ouput var:value to variables (backward compatibility)
+	// Todo: support multi vars
+	if latestTrOuput != "" {
+		latestTrOuputArr := strings.Split(latestTrOuput, ":")
+		if len(latestTrOuputArr) == 2 {
+			key := strings.TrimSpace(latestTrOuputArr[0])
+			value := strings.TrimSpace(latestTrOuputArr[1])
+			vars[key] = value
+		}
+	}
+	for k, v := range s.outcome {
+		vars[k] = v
+	}
+	s.pr.Spec.Variables = vars
+}
+
+// pipelineLeafTask is a task or a combination of a matrix task, each of them runs its own TaskRuns
+type pipelineLeafTask struct {
+	tRef   opsv1.TaskRef
+	parent string            // name of the matrix task, empty if not a combination
+	index  int               // index of the combination
+	matrix map[string]string // variables of the combination
+}
+
+func newMatrixLeafTask(tRef opsv1.TaskRef, index int, matrix map[string]string) pipelineLeafTask {
+	child := tRef
+	child.Name = tRef.GetMatrixName(index)
+	child.Matrix = nil
+	return pipelineLeafTask{tRef: child, parent: tRef.GetName(), index: index, matrix: matrix}
+}
+
+// advance moves a PipelineRun forward as a state machine, it is triggered again by changes of its TaskRuns
+// Everything is kept in the status, so a reconcile never waits for a TaskRun
+func (r *PipelineRunReconciler) advance(logger *opslog.Logger, ctx context.Context, p *opsv1.Pipeline, pr *opsv1.PipelineRun) (ctrl.Result, error) {
+	deps, err := p.GetTaskDependencies()
+	if err != nil {
+		logger.Error.Println(err)
+		r.commitStatus(logger, ctx, pr, opsconstants.StatusDataInValid, "", "", nil)
+		return ctrl.Result{}, nil
+	}
+	origin := pr.Status.DeepCopy()
+	if pr.Status.StartTime == nil {
+		pr.Status.StartTime = &metav1.Time{Time: time.Now()}
+	}
+	pr.Status.RunStatus = opsconstants.StatusRunning
+	// record every task and finally task of the pipeline as pending with the tasks it runs after
+	for i, tRef := range p.Spec.Tasks {
+		pr.Status.InitPipelineRunTaskStatus(tRef.GetName(), tRef.TaskRef, p.GetTaskRunAfter(i))
+	}
+	for i, tRef := range p.Spec.Finally {
+		pr.Status.InitPipelineRunTaskStatus(tRef.GetName(), tRef.TaskRef, p.GetFinallyRunAfter(i))
+	}
+	s := &pipelineRunState{pr: pr, p: p, variables: pr.Spec.Variables, outcome: make(map[string]string)}
+	// tasks are bounded by the timeout, finally tasks only by their own
+	deadline := time.Time{}
+	if timeout := pr.GetTimeout(p); timeout > 0 {
+		deadline = pr.Status.StartTime.Add(timeout)
+	}
+	finished := r.advanceTasks(logger, ctx, s, p.Spec.Tasks, deps, false, deadline)
+	if finished {
+		finallyStatus, failedTask := getPipelineStatus(pr, p.Spec.Tasks)
+		if isTimedOut(pr, p.Spec.Tasks) {
+			finallyStatus = opsconstants.StatusTimeout
+		}
+		if pr.Spec.Cancelled {
+			finallyStatus = opsconstants.StatusAborted
+		}
+		if len(p.Spec.Finally) > 0 {
+			// finally tasks can react to the outcome of tasks
+			s.outcome[opsconstants.VariablePipelineStatus] = finallyStatus
+			s.outcome[opsconstants.VariablePipelineFailedTask] = failedTask
+			finished = r.advanceTasks(logger, ctx, s, p.Spec.Finally, p.GetFinallyDependencies(), true, time.Time{})
+			if finished && finallyStatus == opsconstants.StatusSuccessed {
+				finallyStatus, _ = getPipelineStatus(pr, p.Spec.Finally)
+			}
+		}
+		if finished {
+			pr.Status.RunStatus = finallyStatus
+		}
+	}
+	if !finished && s.isWaitingApproval() {
+		pr.Status.RunStatus = opsconstants.StatusWaitingApproval
+	}
+	if finished && pr.Spec.Cancelled {
+		pr.Status.RunStatus = opsconstants.StatusAborted
+		pr.Status.Reason = pr.Spec.GetCancelledReason()
+	}
+	if !equality.Semantic.DeepEqual(origin, &pr.Status) {
+		err = r.Client.Status().Update(ctx, pr)
+		if apierrors.IsConflict(err) {
+			// TaskRuns have stable names, so advancing again from the latest status is safe
+			logger.Info.Println("conflict detected, advance pipelinerun again", pr.GetUniqueKey())
+			return ctrl.Result{Requeue: true}, nil
+		}
 		if err != nil {
-			logger.Error.Println(err)
-			runAlways = true
-			r.commitStatus(logger, ctx, pr, opsconstants.StatusDataInValid, tRef.Name, tRef.TaskRef, &opsv1.TaskRunStatus{
-				RunStatus: opsconstants.StatusDataInValid,
+			logger.Error.Println(err, "update pipelinerun status error")
+			return ctrl.Result{}, err
+		}
+		if origin.RunStatus != pr.Status.RunStatus {
+			recordPipelineRunStatusMetrics(pr, origin.RunStatus)
+		}
+		if origin.RunStatus != pr.Status.RunStatus && pr.Status.RunStatus == opsconstants.StatusWaitingApproval {
+			logger.Info.Printf("pipelinerun %s is waiting for approval", pr.GetUniqueKey())
+			// push event, so approvers are notified
+			go opsevent.FactoryPipelineRun(pr.Namespace, pr.Name, opsconstants.Status).Publish(ctx, opsevent.EventPipelineRun{
+				PipelineRef:       pr.Spec.PipelineRef,
+				Desc:              pr.Spec.Desc,
+				Variables:         pr.Spec.Variables,
+				PipelineRunStatus: pr.Status,
 			})
+		}
+	}
+	if finished {
+		logger.Info.Printf("pipelinerun %s finished with %s", pr.GetUniqueKey(), pr.Status.RunStatus)
+		// push event
+		go opsevent.FactoryPipelineRun(pr.Namespace, pr.Name, opsconstants.Status).Publish(ctx, opsevent.EventPipelineRun{
+			PipelineRef:       pr.Spec.PipelineRef,
+			Desc:              pr.Spec.Desc,
+			Variables:         pr.Spec.Variables,
+			PipelineRunStatus: pr.Status,
+		})
+		return ctrl.Result{}, opsevent.FactoryPipelineRun(pr.Namespace, pr.Name).Publish(ctx, pr)
+	}
+	requeueAfter := pipelineRunResyncPeriod
+	if !s.requeueAt.IsZero() && time.Until(s.requeueAt) < requeueAfter {
+		requeueAfter = time.Until(s.requeueAt)
+		if requeueAfter < time.Second {
+			requeueAfter = time.Second
+		}
+	}
+	return ctrl.Result{RequeueAfter: requeueAfter}, nil
+}
+
+// advanceTasks steps the running tasks with their TaskRuns, then starts every ready task
+// Once a task fails, the tasks not started yet are skipped unless runAlways is set or they are finally tasks
+// Once the deadline is passed, running TaskRuns are aborted and the tasks not started yet are skipped
+// Once the PipelineRun is cancelled, running TaskRuns are cancelled and the tasks not started yet are skipped, except finally tasks
+// Returns true if all tasks are finished
+func (r *PipelineRunReconciler) advanceTasks(logger *opslog.Logger, ctx context.Context, s *pipelineRunState, tasks []opsv1.TaskRef, deps [][]int, finally bool, deadline time.Time) bool {
+	s.finally = finally
+	for _, tRef := range tasks {
+		if isRunningTaskState(s.getTaskState(tRef.GetName())) {
+			r.stepTask(logger, ctx, s, tRef, deadline)
+		}
+	}
+	// start every ready task, loop until no state changes because skipped tasks may unblock others
+	for changed := true; changed; {
+		changed = false
+		for i, tRef := range tasks {
+			states := s.getTaskStates(tasks)
+			if states[i] != opsconstants.StatusPending || !isTaskReady(deps[i], states) {
+				continue
+			}
+			changed = true
+			if s.isCancelled() {
+				s.setTaskState(tRef, opsconstants.StatusSkipped, s.pr.Spec.GetCancelledReason())
+				continue
+			}
+			if reused := s.pr.Spec.GetReusedTask(tRef.GetName()); reused != nil && !finally {
+				s.setTaskState(tRef, opsconstants.StatusSuccessed, "reused from "+s.pr.Annotations[opsconstants.AnnotationRerunOfKey])
+				s.pr.Status.GetPipelineRunTaskStatus(tRef.GetName()).Results = reused.Results
+				continue
+			}
+			if isDeadlineExceeded(deadline) {
+				s.setTaskState(tRef, opsconstants.StatusSkipped, reasonPipelineTimeout)
+				continue
+			}
+			if !finally && !tRef.RunAlways && hasFailedTask(states) {
+				s.setTaskState(tRef, opsconstants.StatusSkipped, reasonPreviousFailed)
+				continue
+			}
+			s.refreshVariables()
+			when, ok, err := r.evaluateTaskWhen(s.pr, tRef)
+			if err != nil {
+				logger.Error.Println(err)
+				s.setTaskState(tRef, opsconstants.StatusDataInValid, err.Error())
+				continue
+			}
+			if !ok {
+				logger.Info.Printf("skip task %s in pipelinerun %s, when %s is false", tRef.GetName(), s.pr.GetUniqueKey(), when)
+				s.setTaskState(tRef, opsconstants.StatusSkipped, fmt.Sprintf("when %s is false", when))
+				continue
+			}
+			r.startTask(logger, ctx, s, tRef, deadline)
+		}
+	}
+	for _, state := range s.getTaskStates(tasks) {
+		if state == opsconstants.StatusPending || isRunningTaskState(state) {
+			return false
+		}
+	}
+	return true
+}
+
+// getPipelineStatus returns the status of the pipeline and the name of the first failed task
+func getPipelineStatus(pr *opsv1.PipelineRun, tasks []opsv1.TaskRef) (string, string) {
+	for _, tRef := range tasks {
+		ts := pr.Status.GetPipelineRunTaskStatus(tRef.GetName())
+		if ts != nil && isFailedTaskState(ts.RunStatus) {
+			return ts.RunStatus, tRef.GetName()
+		}
+	}
+	return opsconstants.StatusSuccessed, ""
+}
+
+// isTimedOut returns true if any task is stopped by the timeout of pipeline
+func isTimedOut(pr *opsv1.PipelineRun, tasks []opsv1.TaskRef) bool {
+	for _, tRef := range tasks {
+		ts := pr.Status.GetPipelineRunTaskStatus(tRef.GetName())
+		if ts != nil && ts.Reason == reasonPipelineTimeout {
+			return true
+		}
+	}
+	return false
+}
+
+// isRunningTaskState returns true if a task is started and not finished, an approval task waits for a decision
+func isRunningTaskState(state string) bool {
+	return state == opsconstants.StatusRunning || state == opsconstants.StatusWaitingApproval
+}
+
+// isFailedTaskState returns true if a task is finished without success
+func isFailedTaskState(state string) bool {
+	return state == opsconstants.StatusFailed || state == opsconstants.StatusDataInValid || state == opsconstants.StatusTimeout || state == opsconstants.StatusAborted
+}
+
+// hasFailedTask returns true if any task is finished without success
+func hasFailedTask(states []string) bool {
+	for _, state := range states {
+		if isFailedTaskState(state) {
+			return true
+		}
+	}
+	return false
+}
+
+// isTaskReady returns true if all the tasks it depends on are finished
+func isTaskReady(deps []int, states []string) bool {
+	for _, j := range deps {
+		if states[j] == opsconstants.StatusEmpty || states[j] == opsconstants.StatusPending || isRunningTaskState(states[j]) {
+			return false
+		}
+	}
+	return true
+}
+
+// startTask starts a ready task, a matrix task records its combinations then starts them
+func (r *PipelineRunReconciler) startTask(logger *opslog.Logger, ctx context.Context, s *pipelineRunState, tRef opsv1.TaskRef, deadline time.Time) {
+	if tRef.Approval != nil {
+		s.setTaskState(tRef, opsconstants.StatusWaitingApproval, tRef.Approval.Message)
+		s.pr.Status.GetPipelineRunTaskStatus(tRef.GetName()).Approval = &opsv1.PipelineRunTaskApproval{StartTime: &metav1.Time{Time: time.Now()}}
+		logger.Info.Printf("task %s in pipelinerun %s is waiting for approval", tRef.GetName(), s.pr.GetUniqueKey())
+		r.stepApproval(logger, s, tRef, deadline)
+		return
+	}
+	if len(tRef.Matrix) == 0 {
+		r.startAttempt(logger, ctx, s, pipelineLeafTask{tRef: tRef}, 1, deadline)
+		return
+	}
+	taskResults := getTaskResults(s.pr)
+	combinations := tRef.GetMatrixCombinations(func(value string) string {
+		return opstask.RenderStringWithPathRefs(value, s.pr.Spec.Variables, taskResults)
+	})
+	if len(combinations) == 0 {
+		s.setTaskState(tRef, opsconstants.StatusSkipped, "matrix is empty")
+		return
+	}
+	for i, combination := range combinations {
+		s.pr.Status.InitPipelineRunMatrixStatus(tRef.GetName(), tRef.GetMatrixName(i), tRef.TaskRef, combination)
+	}
+	s.setTaskState(tRef, opsconstants.StatusRunning, "")
+	r.stepMatrix(logger, ctx, s, tRef, deadline)
+}
+
+// stepTask checks a running task with its TaskRuns
+func (r *PipelineRunReconciler) stepTask(logger *opslog.Logger, ctx context.Context, s *pipelineRunState, tRef opsv1.TaskRef, deadline time.Time) {
+	if tRef.Approval != nil {
+		r.stepApproval(logger, s, tRef, deadline)
+		return
+	}
+	if len(tRef.Matrix) > 0 {
+		r.stepMatrix(logger, ctx, s, tRef, deadline)
+		return
+	}
+	r.stepAttempt(logger, ctx, s, pipelineLeafTask{tRef: tRef}, deadline)
+}
+
+// stepApproval checks the decision of an approval task in spec.approvals, it fails if it is rejected or not decided in time
+func (r *PipelineRunReconciler) stepApproval(logger *opslog.Logger, s *pipelineRunState, tRef opsv1.TaskRef, deadline time.Time) {
+	ts := s.pr.Status.GetPipelineRunTaskStatus(tRef.GetName())
+	if ts.Approval == nil || ts.Approval.StartTime == nil {
+		ts.Approval = &opsv1.PipelineRunTaskApproval{StartTime: &metav1.Time{Time: time.Now()}}
+	}
+	if s.isCancelled() {
+		s.setTaskState(tRef, opsconstants.StatusAborted, s.pr.Spec.GetCancelledReason())
+		return
+	}
+	// a dry run does not wait for approvals, the tasks after them are planned too
+	if s.pr.Spec.DryRun {
+		s.setTaskState(tRef, opsconstants.StatusSuccessed, reasonDryRun)
+		return
+	}
+	if approval := s.pr.Spec.GetApproval(tRef.GetName(), tRef.Approval); approval != nil {
+		ts.Approval.Decision, ts.Approval.Approver, ts.Approval.Comment = approval.Decision, approval.Approver, approval.Comment
+		ts.Approval.DecideTime = &metav1.Time{Time: time.Now()}
+		logger.Info.Printf("task %s in pipelinerun %s is %s by %s", tRef.GetName(), s.pr.GetUniqueKey(), strings.ToLower(approval.Decision), approval.Approver)
+		reason := strings.ToLower(approval.Decision) + " by " + approval.Approver
+		if approval.Decision == opsconstants.ApprovalApproved {
+			s.setTaskState(tRef, opsconstants.StatusSuccessed, reason)
+		} else {
+			s.setTaskState(tRef, opsconstants.StatusFailed, reason)
+		}
+		return
+	}
+	approvalDeadline, reason := deadline, reasonPipelineTimeout
+	if timeout, _ := opsv1.ParseTimeout(tRef.Approval.Timeout); timeout > 0 {
+		if d := ts.Approval.StartTime.Add(timeout); approvalDeadline.IsZero() || d.Before(approvalDeadline) {
+			approvalDeadline, reason = d, reasonApprovalTimeout
+		}
+	}
+	if isDeadlineExceeded(approvalDeadline) {
+		logger.Info.Printf("task %s in pipelinerun %s is not approved in time", tRef.GetName(), s.pr.GetUniqueKey())
+		if reason == reasonPipelineTimeout {
+			s.setTaskState(tRef, opsconstants.StatusTimeout, reason)
+		} else {
+			s.setTaskState(tRef, opsconstants.StatusFailed, reason)
+		}
+		return
+	}
+	s.wakeAt(approvalDeadline)
+}
+
+// stepMatrix steps the running combinations and starts pending ones, at most maxParallel at the same time
+// Once all combinations are finished, the task gets the first failed status and the results joined by comma as key[*]
+func (r *PipelineRunReconciler) stepMatrix(logger *opslog.Logger, ctx context.Context, s *pipelineRunState, tRef opsv1.TaskRef, deadline time.Time) {
+	children := getMatrixChildren(s.pr, tRef.GetName())
+	running := 0
+	for i, child := range children {
+		if child.RunStatus != opsconstants.StatusRunning {
+			continue
+		}
+		if r.stepAttempt(logger, ctx, s, newMatrixLeafTask(tRef, i, child.Matrix), deadline) == opsconstants.StatusRunning {
+			running++
+		}
+	}
+	for i, child := range children {
+		if child.RunStatus != opsconstants.StatusPending {
+			continue
+		}
+		leaf := newMatrixLeafTask(tRef, i, child.Matrix)
+		if s.isCancelled() {
+			s.setTaskState(leaf.tRef, opsconstants.StatusSkipped, s.pr.Spec.GetCancelledReason())
+			continue
+		}
+		if isDeadlineExceeded(deadline) {
+			s.setTaskState(leaf.tRef, opsconstants.StatusSkipped, reasonPipelineTimeout)
 			continue
 		}
-		// patch latest tr ouput var:value to variables (backward compatibility)
-		// Todo: support multi vars
-		if latestTrOuput != "" {
-			latestTrOuputArr := strings.Split(latestTrOuput, ":")
-			if len(latestTrOuputArr) == 2 {
-				key := strings.TrimSpace(latestTrOuputArr[0])
-				value := strings.TrimSpace(latestTrOuputArr[1])
-				pr.Spec.Variables[key] = value
+		if tRef.MaxParallel > 0 && running >= tRef.MaxParallel {
+			break
+		}
+		if r.startAttempt(logger, ctx, s, leaf, 1, deadline) == opsconstants.StatusRunning {
+			running++
+		}
+	}
+	status, reason := opsconstants.StatusSuccessed, ""
+	childResults := make([]map[string]string, 0)
+	for _, child := range getMatrixChildren(s.pr, tRef.GetName()) {
+		if child.RunStatus == opsconstants.StatusPending || child.RunStatus == opsconstants.StatusRunning {
+			return
+		}
+		if status == opsconstants.StatusSuccessed {
+			if child.Reason == reasonPipelineTimeout {
+				status, reason = opsconstants.StatusTimeout, reasonPipelineTimeout
+			} else if child.RunStatus != opsconstants.StatusSuccessed {
+				status, reason = child.RunStatus, fmt.Sprintf("%s is %s", child.TaskName, child.RunStatus)
 			}
 		}
+		childResults = append(childResults, child.Results)
+	}
+	results := make(map[string]string)
+	for resultKey := range tRef.Results {
+		values := []string{}
+		for _, childResult := range childResults {
+			if value, ok := childResult[resultKey]; ok {
+				values = append(values, value)
+			}
+		}
+		results[resultKey+"[*]"] = strings.Join(values, ",")
+	}
+	s.setTaskState(tRef, status, reason)
+	if ts := s.pr.Status.GetPipelineRunTaskStatus(tRef.GetName()); ts != nil && len(results) > 0 {
+		ts.Results = results
+	}
+}
+
+// getMatrixChildren returns a copy of the combinations of a matrix task in order
+func getMatrixChildren(pr *opsv1.PipelineRun, parent string) []opsv1.PipelineRunTaskStatus {
+	children := []opsv1.PipelineRunTaskStatus{}
+	for _, ts := range pr.Status.PipelineRunStatus {
+		if ts.Parent == parent {
+			children = append(children, ts)
+		}
+	}
+	return children
+}
+
+// startAttempt creates the TaskRun of an attempt, or the child PipelineRun for pipelineRef
+// They have a stable name so creating them again is safe
+func (r *PipelineRunReconciler) startAttempt(logger *opslog.Logger, ctx context.Context, s *pipelineRunState, leaf pipelineLeafTask, attempt int, deadline time.Time) string {
+	tRef := leaf.tRef
+	s.refreshVariables()
+	var obj client.Object
+	taskAttempt := opsv1.PipelineRunTaskAttempt{Attempt: attempt}
+	if tRef.PipelineRef != "" {
+		child, status, reason := r.newChildPipelineRun(logger, ctx, s.pr, tRef)
+		if child == nil {
+			s.setTaskState(tRef, status, reason)
+			return status
+		}
+		child.Name = getPipelineTaskRunName(s.pr, tRef, attempt)
+		child.Labels[opsconstants.LabelAttemptKey] = strconv.Itoa(attempt)
+		if leaf.parent != "" {
+			child.Labels[opsconstants.LabelPipelineTaskKey] = leaf.parent
+			child.Labels[opsconstants.LabelMatrixKey] = strconv.Itoa(leaf.index)
+		}
+		for k, v := range leaf.matrix {
+			child.Spec.Variables[k] = v
+		}
+		obj, taskAttempt.PipelineRun = child, child.Name
+	} else {
+		tr, status := r.newTaskRun(logger, ctx, s.p, s.pr, tRef)
+		if tr == nil {
+			s.setTaskState(tRef, status, "task not found")
+			return status
+		}
+		tr.GenerateName = ""
+		tr.Name = getPipelineTaskRunName(s.pr, tRef, attempt)
+		tr.Labels[opsconstants.LabelAttemptKey] = strconv.Itoa(attempt)
+		if leaf.parent != "" {
+			tr.Labels[opsconstants.LabelPipelineTaskKey] = leaf.parent
+			tr.Labels[opsconstants.LabelMatrixKey] = strconv.Itoa(leaf.index)
+		}
+		for k, v := range leaf.matrix {
+			tr.Spec.Variables[k] = v
+		}
+		obj, taskAttempt.TaskRun = tr, tr.Name
+	}
+	err := r.Client.Create(ctx, obj)
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		logger.Error.Println(err)
+		s.setTaskState(tRef, opsconstants.StatusDataInValid, err.Error())
+		return opsconstants.StatusDataInValid
+	}
+	now := &metav1.Time{Time: time.Now()}
+	taskAttempt.RunStatus, taskAttempt.StartTime = opsconstants.StatusRunning, now
+	s.pr.Status.SetPipelineRunTaskAttempt(tRef.GetName(), tRef.TaskRef, taskAttempt)
+	s.setTaskState(tRef, opsconstants.StatusRunning, "")
+	attemptDeadline, _ := getAttemptDeadline(tRef, now, deadline)
+	s.wakeAt(attemptDeadline)
+	return opsconstants.StatusRunning
+}
 
-		// Build variables for TaskRun: filter pipeline variables by task requirements and merge TaskRef variables
-		tr := opsv1.NewTaskRunWithPipelineRun(pr, t, tRef, p)
-		tr.Spec.Variables = r.buildTaskRunVariables(pr, t, tRef, ctx)
-		err = r.Client.Create(ctx, tr)
+// attemptRun is what an attempt runs, a TaskRun for taskRef or a child PipelineRun for pipelineRef
+type attemptRun struct {
+	tr *opsv1.TaskRun
+	pr *opsv1.PipelineRun
+}
+
+func (a attemptRun) getRunStatus() string {
+	if a.pr != nil {
+		return a.pr.Status.RunStatus
+	}
+	return a.tr.Status.RunStatus
+}
+
+func (a attemptRun) getReason() string {
+	if a.pr != nil {
+		return a.pr.Status.Reason
+	}
+	return a.tr.Status.Reason
+}
+
+func (a attemptRun) getOutput() string {
+	if a.pr != nil {
+		return a.pr.Status.GetOutput()
+	}
+	return a.tr.Status.GetOutput()
+}
+
+func (a attemptRun) getUniqueKey() string {
+	if a.pr != nil {
+		return a.pr.GetUniqueKey()
+	}
+	return a.tr.GetUniqueKey()
+}
+
+// getAttemptRun gets the TaskRun or the child PipelineRun of an attempt
+func (r *PipelineRunReconciler) getAttemptRun(ctx context.Context, namespace string, cur opsv1.PipelineRunTaskAttempt) (attemptRun, error) {
+	if cur.PipelineRun != "" {
+		pr := &opsv1.PipelineRun{}
+		err := r.Client.Get(ctx, types.NamespacedName{Namespace: namespace, Name: cur.PipelineRun}, pr)
+		return attemptRun{pr: pr}, err
+	}
+	tr := &opsv1.TaskRun{}
+	err := r.Client.Get(ctx, types.NamespacedName{Namespace: namespace, Name: cur.TaskRun}, tr)
+	return attemptRun{tr: tr}, err
+}
+
+// stepAttempt checks the run of the current attempt, then aborts it on timeout, retries it on failure or records its results
+// Returns the state of the task
+func (r *PipelineRunReconciler) stepAttempt(logger *opslog.Logger, ctx context.Context, s *pipelineRunState, leaf pipelineLeafTask, pipelineDeadline time.Time) string {
+	tRef := leaf.tRef
+	ts := s.pr.Status.GetPipelineRunTaskStatus(tRef.GetName())
+	if ts == nil || len(ts.Attempts) == 0 {
+		// the attempt is not recorded, create it again
+		return r.startAttempt(logger, ctx, s, leaf, 1, pipelineDeadline)
+	}
+	cur := ts.Attempts[len(ts.Attempts)-1]
+	run, err := r.getAttemptRun(ctx, s.pr.Namespace, cur)
+	if apierrors.IsNotFound(err) && cur.StartTime != nil && time.Since(cur.StartTime.Time) < taskRunNotFoundGracePeriod {
+		s.wakeAt(time.Now().Add(3 * time.Second))
+		return opsconstants.StatusRunning
+	}
+	if apierrors.IsNotFound(err) {
+		cur.RunStatus, cur.Reason, cur.FinishTime = opsconstants.StatusFailed, "taskrun not found", &metav1.Time{Time: time.Now()}
+		if cur.PipelineRun != "" {
+			cur.Reason = "pipelinerun not found"
+		}
+		s.pr.Status.SetPipelineRunTaskAttempt(tRef.GetName(), tRef.TaskRef, cur)
+		s.setTaskState(tRef, opsconstants.StatusFailed, cur.Reason)
+		return opsconstants.StatusFailed
+	}
+	if err != nil {
+		logger.Error.Println(err)
+		s.wakeAt(time.Now().Add(3 * time.Second))
+		return opsconstants.StatusRunning
+	}
+	// Commit status with execution logs (TaskRunNodeStatus)
+	if run.tr != nil {
+		s.pr.Status.AddPipelineRunTaskStatus(tRef.GetName(), tRef.TaskRef, run.tr.Status.DeepCopy())
+	}
+	runStatus := run.getRunStatus()
+	if !opsconstants.IsFinishedStatus(runStatus) {
+		s.setTaskState(tRef, opsconstants.StatusRunning, ts.Reason)
+		if s.isCancelled() {
+			// the run ends as Aborted, then its change triggers the next step
+			if err := r.cancelAttemptRun(ctx, run, s.pr.Spec.CancelledBy); err != nil {
+				logger.Error.Println(err, "cancel attempt error")
+				s.wakeAt(time.Now().Add(time.Second))
+			}
+			return opsconstants.StatusRunning
+		}
+		deadline, reason := getAttemptDeadline(tRef, cur.StartTime, pipelineDeadline)
+		if !isDeadlineExceeded(deadline) {
+			s.wakeAt(deadline)
+			return opsconstants.StatusRunning
+		}
+		logger.Info.Printf("%s of task %s timeout", run.getUniqueKey(), tRef.GetName())
+		if run.tr != nil {
+			err = r.abortTaskRun(ctx, run.tr, opsconstants.StatusTimeout)
+		} else {
+			// the child PipelineRun cancels its own TaskRuns
+			err = r.cancelAttemptRun(ctx, run, "pipelinerun "+s.pr.Name)
+		}
 		if err != nil {
-			logger.Error.Println(err)
-			runAlways = true
-			r.commitStatus(logger, ctx, pr, opsconstants.StatusDataInValid, tRef.Name, tRef.TaskRef, nil)
+			logger.Error.Println(err, "abort attempt error")
+			s.wakeAt(time.Now().Add(time.Second))
+			return opsconstants.StatusRunning
+		}
+		if run.tr != nil {
+			s.pr.Status.AddPipelineRunTaskStatus(tRef.GetName(), tRef.TaskRef, run.tr.Status.DeepCopy())
+		}
+		runStatus, cur.Reason = opsconstants.StatusTimeout, reason
+	}
+	if cur.Reason == "" {
+		cur.Reason = run.getReason()
+	}
+	if cur.FinishTime == nil {
+		cur.RunStatus, cur.FinishTime = runStatus, &metav1.Time{Time: time.Now()}
+		s.pr.Status.SetPipelineRunTaskAttempt(tRef.GetName(), tRef.TaskRef, cur)
+	}
+	if runStatus == opsconstants.StatusSuccessed {
+		// Extract and store task results
+		var taskResults map[string]string
+		if run.pr != nil {
+			taskResults = extractPipelineRunResults(tRef, run.pr)
+		} else {
+			taskResults = extractTaskResults(tRef, run.tr)
+		}
+		if len(taskResults) > 0 {
+			s.pr.Status.GetPipelineRunTaskStatus(tRef.GetName()).Results = taskResults
+		}
+		s.setTaskState(tRef, opsconstants.StatusSuccessed, "")
+		return opsconstants.StatusSuccessed
+	}
+	if runStatus == opsconstants.StatusFailed && cur.Attempt <= tRef.Retries && !isDeadlineExceeded(pipelineDeadline) && !s.isCancelled() {
+		if tRef.ShouldRetryOn(run.getOutput()) {
+			next := cur.FinishTime.Add(tRef.GetRetryBackoff(cur.Attempt))
+			if time.Now().Before(next) {
+				s.setTaskState(tRef, opsconstants.StatusRunning, fmt.Sprintf("retry attempt %d at %s", cur.Attempt+1, next.Format(time.RFC3339)))
+				s.wakeAt(next)
+				s.wakeAt(pipelineDeadline)
+				return opsconstants.StatusRunning
+			}
+			logger.Info.Printf("retry task %s in pipelinerun %s, attempt %d/%d", tRef.GetName(), s.pr.GetUniqueKey(), cur.Attempt+1, tRef.Retries+1)
+			return r.startAttempt(logger, ctx, s, leaf, cur.Attempt+1, pipelineDeadline)
+		}
+		logger.Info.Printf("not retry task %s in pipelinerun %s, output does not match retryOn %s", tRef.GetName(), s.pr.GetUniqueKey(), tRef.RetryOn)
+	}
+	s.setTaskState(tRef, runStatus, cur.Reason)
+	return runStatus
+}
+
+// getAttemptDeadline returns when an attempt times out and why, bounded by the timeout of task and the deadline of pipeline
+func getAttemptDeadline(tRef opsv1.TaskRef, startTime *metav1.Time, pipelineDeadline time.Time) (time.Time, string) {
+	deadline, reason := pipelineDeadline, reasonPipelineTimeout
+	if timeout := tRef.GetTimeout(); timeout > 0 && startTime != nil {
+		if taskDeadline := startTime.Add(timeout); deadline.IsZero() || taskDeadline.Before(deadline) {
+			deadline, reason = taskDeadline, reasonTaskTimeout
+		}
+	}
+	return deadline, reason
+}
+
+// getPipelineTaskRunName returns a stable name for an attempt of a task, so creating it again is safe
+func getPipelineTaskRunName(pr *opsv1.PipelineRun, tRef opsv1.TaskRef, attempt int) string {
+	h := fnv.New32a()
+	h.Write([]byte(fmt.Sprintf("%s/%s/%d", pr.UID, tRef.GetName(), attempt)))
+	if tRef.PipelineRef != "" {
+		// not prefixed by the parent, so the names and labels of nested PipelineRuns stay short
+		return fmt.Sprintf("%s-%08x", tRef.PipelineRef, h.Sum32())
+	}
+	return fmt.Sprintf("%s-%s-%08x", pr.Name, tRef.TaskRef, h.Sum32())
+}
+
+// evaluateTaskWhen renders TaskRef.When with pipeline variables and upstream results, then evaluates it
+// Returns the rendered expression and whether the task should run
+func (r *PipelineRunReconciler) evaluateTaskWhen(pr *opsv1.PipelineRun, tRef opsv1.TaskRef) (string, bool, error) {
+	if strings.TrimSpace(tRef.When) == "" {
+		return "", true, nil
+	}
+	taskResults := getTaskResults(pr)
+	when := opstask.RenderStringWithPathRefs(tRef.When, pr.Spec.Variables, taskResults)
+	ok, err := opsexpr.EvalBool(tRef.When, true, opstask.NewPathResolver(pr.Spec.Variables, taskResults))
+	if err != nil {
+		return when, false, fmt.Errorf("invalid when %s of task %s: %v", tRef.When, tRef.GetName(), err)
+	}
+	return when, ok, nil
+}
+
+// isDeadlineExceeded returns true if the deadline is set and passed
+func isDeadlineExceeded(deadline time.Time) bool {
+	return !deadline.IsZero() && time.Now().After(deadline)
+}
+
+// abortTaskRun sets the status of a TaskRun that is not finished, the TaskRun controller stops running it
+func (r *PipelineRunReconciler) abortTaskRun(ctx context.Context, tr *opsv1.TaskRun, status string) error {
+	if opsconstants.IsFinishedStatus(tr.Status.RunStatus) {
+		return nil
+	}
+	tr.Status.RunStatus = status
+	return r.Client.Status().Update(ctx, tr)
+}
+
+// cancelTaskRun cancels a TaskRun that is not finished, the TaskRun controller stops running it
+func (r *PipelineRunReconciler) cancelTaskRun(ctx context.Context, tr *opsv1.TaskRun, cancelledBy string) error {
+	if tr.Spec.Cancelled || opsconstants.IsFinishedStatus(tr.Status.RunStatus) {
+		return nil
+	}
+	tr.Spec.Cancelled = true
+	tr.Spec.CancelledBy = cancelledBy
+	return r.Client.Update(ctx, tr)
+}
+
+// cancelAttemptRun cancels the TaskRun or the child PipelineRun of an attempt
+func (r *PipelineRunReconciler) cancelAttemptRun(ctx context.Context, run attemptRun, cancelledBy string) error {
+	if run.tr != nil {
+		return r.cancelTaskRun(ctx, run.tr, cancelledBy)
+	}
+	if run.pr.Spec.Cancelled || opsconstants.IsFinishedStatus(run.pr.Status.RunStatus) {
+		return nil
+	}
+	run.pr.Spec.Cancelled = true
+	run.pr.Spec.CancelledBy = cancelledBy
+	return r.Client.Update(ctx, run.pr)
+}
+
+// newChildPipelineRun builds the child PipelineRun of a task referencing a pipeline without creating it
+// Returns nil, the task status and why if the pipeline is not found, or it makes a cycle or nests too deep
+func (r *PipelineRunReconciler) newChildPipelineRun(logger *opslog.Logger, ctx context.Context, pr *opsv1.PipelineRun, tRef opsv1.TaskRef) (*opsv1.PipelineRun, string, string) {
+	if err := opsv1.CheckPipelineChain(pr.GetPipelineChain(), tRef.PipelineRef); err != nil {
+		logger.Error.Println(err)
+		return nil, opsconstants.StatusDataInValid, err.Error()
+	}
+	p := &opsv1.Pipeline{}
+	err := r.Client.Get(ctx, types.NamespacedName{Namespace: pr.Namespace, Name: tRef.PipelineRef}, p)
+	if err != nil {
+		logger.Error.Println(err)
+		return nil, opsconstants.StatusDataInValid, "pipeline not found"
+	}
+	child := opsv1.NewPipelineRunWithPipelineRun(pr, p, tRef)
+	child.Spec.Variables = r.buildChildPipelineRunVariables(pr, p, child.Spec.Variables)
+	return child, opsconstants.StatusRunning, ""
+}
+
+// buildChildPipelineRunVariables builds variables for a child PipelineRun, the variables of parent declared by the child pipeline override its defaults
+// The child always runs in the cluster of its parent
+func (r *PipelineRunReconciler) buildChildPipelineRunVariables(pr *opsv1.PipelineRun, p *opsv1.Pipeline, defaults map[string]string) map[string]string {
+	taskResults := getTaskResults(pr)
+	vars := make(map[string]string)
+	for k, v := range defaults {
+		vars[k] = v
+	}
+	for k, v := range pr.Spec.Variables {
+		if _, ok := p.Spec.Variables[k]; ok {
+			vars[k] = opstask.RenderStringWithPathRefs(v, pr.Spec.Variables, taskResults)
+		}
+	}
+	// Always include host variable if it exists
+	if hostVal, ok := pr.Spec.Variables[opsconstants.HostLower]; ok {
+		vars[opsconstants.HostLower] = opstask.RenderStringWithPathRefs(hostVal, pr.Spec.Variables, taskResults)
+	}
+	delete(vars, opsconstants.ClusterLower)
+	return vars
+}
+
+// extractPipelineRunResults extracts the results defined in TaskRef.Results from a finished child PipelineRun
+// TaskRef.Results is map[resultKey]task.result, or map[resultKey]result to take the first task having it
+func extractPipelineRunResults(tRef opsv1.TaskRef, child *opsv1.PipelineRun) map[string]string {
+	results := make(map[string]string)
+	for resultKey, ref := range tRef.Results {
+		if task, result, ok := strings.Cut(ref, "."); ok {
+			if value, ok := opstask.ResolvePathReference("tasks."+task+".results."+result, getTaskResults(child)); ok {
+				results[resultKey] = value
+			}
 			continue
 		}
-		// run task and commit status
-		for {
-			time.Sleep(time.Second * 3)
-			trRunning := &opsv1.TaskRun{}
-			if err = r.Client.Get(ctx, types.NamespacedName{Namespace: tr.Namespace, Name: tr.Name}, trRunning); err != nil {
-				logger.Error.Println(err)
+		for _, ts := range child.Status.PipelineRunStatus {
+			if value, ok := ts.Results[ref]; ok && ts.Parent == "" {
+				results[resultKey] = value
 				break
 			}
-			r.commitStatus(logger, ctx, pr, opsconstants.StatusRunning, tRef.Name, trRunning.Spec.TaskRef, &trRunning.Status)
-			if trRunning.Status.RunStatus == opsconstants.StatusRunning || trRunning.Status.RunStatus == opsconstants.StatusEmpty {
+		}
+	}
+	return results
+}
+
+// newTaskRun builds the TaskRun of a TaskRef without creating it, returns nil and the task status if the task is not found
+func (r *PipelineRunReconciler) newTaskRun(logger *opslog.Logger, ctx context.Context, p *opsv1.Pipeline, pr *opsv1.PipelineRun, tRef opsv1.TaskRef) (*opsv1.TaskRun, string) {
+	t := &opsv1.Task{}
+	err := r.Client.Get(ctx, types.NamespacedName{Namespace: pr.Namespace, Name: tRef.TaskRef}, t)
+	if err != nil {
+		logger.Error.Println(err)
+		return nil, opsconstants.StatusDataInValid
+	}
+	// Build variables for TaskRun: filter pipeline variables by task requirements and merge TaskRef variables
+	tr := opsv1.NewTaskRunWithPipelineRun(pr, t, tRef, p)
+	tr.Spec.Variables = r.buildTaskRunVariables(pr, t, tRef)
+	return tr, opsconstants.StatusRunning
+}
+
+// extractTaskResults extracts the results defined in TaskRef.Results from a finished TaskRun
+// A result is kept per node as key.node, and key is the value of the first node in order of node name
+func extractTaskResults(tRef opsv1.TaskRef, trRunning *opsv1.TaskRun) map[string]string {
+	taskResults := make(map[string]string)
+	if len(tRef.Results) == 0 {
+		return taskResults
+	}
+	nodes := make([]string, 0, len(trRunning.Status.TaskRunNodeStatus))
+	for node := range trRunning.Status.TaskRunNodeStatus {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	// Extract results from TaskRunStatus based on TaskRef.Results definition
+	// TaskRef.Results is map[resultKey]stepName
+	for _, node := range nodes {
+		nodeStatus := trRunning.Status.TaskRunNodeStatus[node]
+		if nodeStatus == nil {
+			continue
+		}
+		// Build a map of stepName -> stepOutput for quick lookup
+		// a truncated output is read from the log store, so that the results at the middle of it are kept
+		stepOutputs := make(map[string]string)
+		for _, step := range nodeStatus.TaskRunStep {
+			stepOutputs[step.StepName] = getFullStepOutput(step)
+		}
+		// Extract results according to TaskRef.Results
+		for resultKey, stepName := range tRef.Results {
+			output, ok := stepOutputs[stepName]
+			if !ok {
 				continue
-			} else if trRunning.Status.RunStatus == opsconstants.StatusSuccessed {
-				// Extract and store task results
-				if tRef.Results != nil && len(tRef.Results) > 0 {
-					taskResults := make(map[string]string)
-					// Extract results from TaskRunStatus based on TaskRef.Results definition
-					// TaskRef.Results is map[resultKey]stepName
-					if len(trRunning.Status.TaskRunNodeStatus) == 1 {
-						for _, nodeStatus := range trRunning.Status.TaskRunNodeStatus {
-							// Build a map of stepName -> stepOutput for quick lookup
-							stepOutputs := make(map[string]string)
-							for _, step := range nodeStatus.TaskRunStep {
-								stepOutputs[step.StepName] = step.StepOutput
-							}
-							// Extract results according to TaskRef.Results
-							for resultKey, stepName := range tRef.Results {
-								if output, ok := stepOutputs[stepName]; ok {
-									// Try to extract result using special markers
-									extractedValue := extractResultFromOutput(output, resultKey)
-									if extractedValue != "" {
-										taskResults[resultKey] = extractedValue
-									} else {
-										// Fallback to full output (backward compatibility)
-										taskResults[resultKey] = strings.TrimSpace(output)
-									}
-								}
-							}
-						}
-					}
-					// Store results in PipelineRunTaskStatus
-					if len(taskResults) > 0 {
-						r.updateTaskResults(logger, ctx, pr, tRef.Name, taskResults)
-						// Also add results to PipelineRun variables for direct reference
-						if pr.Spec.Variables == nil {
-							pr.Spec.Variables = make(map[string]string)
-						}
-						for k, v := range taskResults {
-							pr.Spec.Variables[k] = v
-						}
-					}
-				}
-				// patch latest tr ouput var:value to variables (backward compatibility)
-				// This maintains the existing key:value mechanism
-				if len(trRunning.Status.TaskRunNodeStatus) == 1 {
-					for _, nodeStatus := range trRunning.Status.TaskRunNodeStatus {
-						if len(nodeStatus.TaskRunStep) > 0 {
-							latestTrOuput = nodeStatus.TaskRunStep[len(nodeStatus.TaskRunStep)-1].StepOutput
-						}
-					}
-				}
-				// Commit final status with execution logs (TaskRunNodeStatus)
-				r.commitStatus(logger, ctx, pr, opsconstants.StatusRunning, tRef.Name, trRunning.Spec.TaskRef, &trRunning.Status)
-				break
-			} else {
-				// TaskRun failed or other status, commit final status with execution logs
-				r.commitStatus(logger, ctx, pr, opsconstants.StatusRunning, tRef.Name, trRunning.Spec.TaskRef, &trRunning.Status)
-				runAlways = true
-				break
 			}
+			// Try to extract result using special markers
+			value := extractResultFromOutput(output, resultKey)
+			if value == "" {
+				// Fallback to full output (backward compatibility)
+				value = strings.TrimSpace(output)
+			}
+			if _, ok := taskResults[resultKey]; !ok {
+				taskResults[resultKey] = value
+			}
+			taskResults[resultKey+"."+node] = value
 		}
 	}
-	finallyStatus := opsconstants.StatusSuccessed
-	for _, status := range pr.Status.PipelineRunStatus {
-		if status.TaskRunStatus.RunStatus == opsconstants.StatusFailed {
-			finallyStatus = opsconstants.StatusFailed
-			break
-		} else if status.TaskRunStatus.RunStatus == opsconstants.StatusDataInValid {
-			finallyStatus = opsconstants.StatusDataInValid
-			break
-		}
+	return taskResults
+}
+
+// getFullStepOutput returns the full output of a step, or the truncated one if the log store can not be read
+func getFullStepOutput(step *opsv1.TaskRunStep) string {
+	store, err := opslogstore.Default()
+	if err != nil {
+		return step.StepOutput
 	}
-	r.commitStatus(logger, ctx, pr, finallyStatus, "", "", nil)
-	// push event
-	go opsevent.FactoryPipelineRun(pr.Namespace, pr.Name, opsconstants.Status).Publish(ctx, opsevent.EventPipelineRun{
-		PipelineRef:       pr.Spec.PipelineRef,
-		Desc:              pr.Spec.Desc,
-		Variables:         pr.Spec.Variables,
-		PipelineRunStatus: pr.Status,
-	})
-	return
+	output, _ := opslogstore.GetStepOutput(context.TODO(), store, step)
+	return output
 }
 
 // SetupWithManager sets up the controller with the Manager.
@@ -574,17 +1418,13 @@ func (r *PipelineRunReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	}); err != nil {
 		return err
 	}
+	r.remoteEvents = make(chan event.GenericEvent, 1024)
 
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&crdv1.PipelineRun{}).
-		WithEventFilter(
+		For(&crdv1.PipelineRun{}, builder.WithPredicates(
 			predicate.Funcs{
 				// drop reconcile for status updates
 				UpdateFunc: func(e event.UpdateEvent) bool {
-					if _, ok := e.ObjectOld.(*opsv1.PipelineRun); !ok {
-						return true
-					}
-
 					oldObject := e.ObjectOld.(*opsv1.PipelineRun).DeepCopy()
 					newObject := e.ObjectNew.(*opsv1.PipelineRun).DeepCopy()
 
@@ -597,7 +1437,12 @@ func (r *PipelineRunReconciler) SetupWithManager(mgr ctrl.Manager) error {
 					return !cmp.Equal(oldObjectCmp, newObjectCmp)
 				},
 			},
-		).
+		)).
+		// status changes of TaskRuns and child PipelineRuns trigger their parent
+		Owns(&opsv1.TaskRun{}).
+		Owns(&opsv1.PipelineRun{}).
+		// changes of the PipelineRuns dispatched to other clusters
+		Watches(&source.Channel{Source: r.remoteEvents}, &handler.EnqueueRequestForObject{}).
 		WithOptions(controller.Options{
 			MaxConcurrentReconciles: opsconstants.MaxTaskrunConcurrentReconciles}).
 		Complete(r)
@@ -621,21 +1466,8 @@ func (r *PipelineRunReconciler) commitStatus(logger *opslog.Logger, ctx context.
 		}
 		err = r.Client.Status().Update(ctx, latestPr)
 		if err == nil {
-			// Record CRD resource status change metrics - record every status change (value=1 for existing resource)
 			if oldStatus != latestPr.Status.RunStatus {
-				// Record PipelineRun info metrics (static fields only)
-				opsmetrics.RecordPipelineRunInfo(latestPr.Namespace, latestPr.Name, latestPr.Spec.PipelineRef, latestPr.Spec.Crontab, 1)
-				// Record PipelineRun status metrics (dynamic fields)
-				opsmetrics.RecordPipelineRunStatus(latestPr.Namespace, latestPr.Name, latestPr.Status.RunStatus)
-				// Record scheduled task status change if this is a scheduled task (has Crontab)
-				if latestPr.Spec.Crontab != "" {
-					opsmetrics.RecordPipelineRunInfo(latestPr.Namespace, latestPr.Name, latestPr.Spec.PipelineRef, latestPr.Spec.Crontab, 1)
-					opsmetrics.RecordPipelineRunStatus(latestPr.Namespace, latestPr.Name, latestPr.Status.RunStatus)
-				}
-				// Record PipelineRef status phase change (decrement old status, increment new status)
-				if latestPr.Spec.PipelineRef != "" {
-					opsmetrics.RecordPipelineRunStatusPhase(latestPr.Namespace, latestPr.Spec.PipelineRef, oldStatus, latestPr.Status.RunStatus)
-				}
+				recordPipelineRunStatusMetrics(latestPr, oldStatus)
 			}
 			return
 		}
@@ -644,56 +1476,26 @@ func (r *PipelineRunReconciler) commitStatus(logger *opslog.Logger, ctx context.
 			return
 		}
 		logger.Info.Println("try commit times ", retries+1, "conflict detected, retrying...", err)
-		time.Sleep(3 * time.Second)
 	}
 	logger.Error.Println("update pipelinerun taskrun status failed after retries", err)
 	return
 }
 
-// updateTaskResults updates the results for a specific task in PipelineRunStatus
-func (r *PipelineRunReconciler) updateTaskResults(logger *opslog.Logger, ctx context.Context, pr *opsv1.PipelineRun, taskName string, results map[string]string) error {
-	for retries := 0; retries < CommitStatusMaxRetries; retries++ {
-		latestPr := &opsv1.PipelineRun{}
-		err := r.Client.Get(ctx, types.NamespacedName{Namespace: pr.GetNamespace(), Name: pr.GetName()}, latestPr)
-		if err != nil {
-			logger.Error.Println(err)
-			return err
-		}
-		// Find and update the task status
-		found := false
-		for i, taskStatus := range latestPr.Status.PipelineRunStatus {
-			if taskStatus.TaskName == taskName {
-				if taskStatus.Results == nil {
-					taskStatus.Results = make(map[string]string)
-				}
-				for k, v := range results {
-					taskStatus.Results[k] = v
-				}
-				latestPr.Status.PipelineRunStatus[i] = taskStatus
-				found = true
-				break
-			}
-		}
-		if !found {
-			// Task status not found, create a new one
-			latestPr.Status.PipelineRunStatus = append(latestPr.Status.PipelineRunStatus, opsv1.PipelineRunTaskStatus{
-				TaskName: taskName,
-				Results:  results,
-			})
-		}
-		err = r.Client.Status().Update(ctx, latestPr)
-		if err == nil {
-			return nil
-		}
-		if !apierrors.IsConflict(err) {
-			logger.Error.Println(err, "update pipelinerun task results error")
-			return err
-		}
-		logger.Info.Println("try update task results times ", retries+1, "conflict detected, retrying...", err)
-		time.Sleep(3 * time.Second)
+// recordPipelineRunStatusMetrics records a status change of PipelineRun
+func recordPipelineRunStatusMetrics(pr *opsv1.PipelineRun, oldStatus string) {
+	// Record PipelineRun info metrics (static fields only)
+	opsmetrics.RecordPipelineRunInfo(pr.Namespace, pr.Name, pr.Spec.PipelineRef, pr.Spec.Crontab, 1)
+	// Record PipelineRun status metrics (dynamic fields)
+	opsmetrics.RecordPipelineRunStatus(pr.Namespace, pr.Name, pr.Status.RunStatus)
+	// Record scheduled task status change if this is a scheduled task (has Crontab)
+	if pr.Spec.Crontab != "" {
+		opsmetrics.RecordPipelineRunInfo(pr.Namespace, pr.Name, pr.Spec.PipelineRef, pr.Spec.Crontab, 1)
+		opsmetrics.RecordPipelineRunStatus(pr.Namespace, pr.Name, pr.Status.RunStatus)
+	}
+	// Record PipelineRef status phase change (decrement old status, increment new status)
+	if pr.Spec.PipelineRef != "" {
+		opsmetrics.RecordPipelineRunStatusPhase(pr.Namespace, pr.Spec.PipelineRef, oldStatus, pr.Status.RunStatus)
 	}
-	logger.Error.Println("update pipelinerun task results failed after retries")
-	return fmt.Errorf("update pipelinerun task results failed after retries")
 }
 
 // extractResultFromOutput extracts result value from step output using special markers
@@ -0,0 +1,78 @@
+/*
+Copyright 2022 shaowenchen.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	opsv1 "github.com/shaowenchen/ops/api/v1"
+	opsconstants "github.com/shaowenchen/ops/pkg/constants"
+)
+
+func newResultTaskRun(outputs map[string]string) *opsv1.TaskRun {
+	tr := &opsv1.TaskRun{}
+	for node, output := range outputs {
+		tr.Status.SetNodeStatus(node, &opsv1.TaskRunNodeStatus{
+			NodeName:    node,
+			TaskRunStep: []*opsv1.TaskRunStep{{StepName: "check", StepOutput: output}},
+		})
+	}
+	return tr
+}
+
+func TestExtractTaskResults(t *testing.T) {
+	tRef := opsv1.TaskRef{Results: map[string]string{"healthy": "check", "missing": "unknown"}}
+	tests := []struct {
+		name    string
+		outputs map[string]string
+		want    map[string]string
+	}{
+		{
+			name:    "single node",
+			outputs: map[string]string{"node1": "OPS_RESULT:healthy=true"},
+			want:    map[string]string{"healthy": "true", "healthy.node1": "true"},
+		},
+		{
+			name:    "multiple nodes",
+			outputs: map[string]string{"node2": "OPS_RESULT:healthy=false", "node1": "true\n"},
+			want:    map[string]string{"healthy": "true", "healthy.node1": "true", "healthy.node2": "false"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := extractTaskResults(tRef, newResultTaskRun(tt.outputs)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("extractTaskResults() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRefreshVariablesCopiesReducedResults(t *testing.T) {
+	p := &opsv1.Pipeline{Spec: opsv1.PipelineSpec{Tasks: []opsv1.TaskRef{{Name: "check", TaskRef: "check", Results: map[string]string{"healthy": "check"}}}}}
+	pr := &opsv1.PipelineRun{}
+	pr.Status.InitPipelineRunTaskStatus("check", "check", nil)
+	ts := pr.Status.GetPipelineRunTaskStatus("check")
+	ts.RunStatus = opsconstants.StatusSuccessed
+	ts.Results = map[string]string{"healthy": "true", "healthy.node1": "true", "healthy.node2": "false"}
+	s := &pipelineRunState{pr: pr, p: p, variables: map[string]string{"name": "ops"}}
+	s.refreshVariables()
+	want := map[string]string{"name": "ops", "healthy": "true"}
+	if !reflect.DeepEqual(pr.Spec.Variables, want) {
+		t.Errorf("refreshVariables() = %v, want %v", pr.Spec.Variables, want)
+	}
+}
@@ -0,0 +1,75 @@
+/*
+Copyright 2022 shaowenchen.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"sort"
+	"time"
+
+	cron "github.com/robfig/cron/v3"
+	opsv1 "github.com/shaowenchen/ops/api/v1"
+	opsconstants "github.com/shaowenchen/ops/pkg/constants"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// maxMissedSchedules bounds the schedules checked for a missed one, like a CronJob
+const maxMissedSchedules = 100
+
+// getMissedScheduleTime returns the latest schedule after last and not after now, zero if no schedule is missed
+func getMissedScheduleTime(cronSpec string, last, now time.Time) (time.Time, error) {
+	schedule, err := cron.ParseStandard(cronSpec)
+	if err != nil {
+		return time.Time{}, err
+	}
+	missed := time.Time{}
+	for t, i := schedule.Next(last), 0; !t.IsZero() && !t.After(now) && i < maxMissedSchedules; t, i = schedule.Next(t), i+1 {
+		missed = t
+	}
+	return missed, nil
+}
+
+// scheduledRun is a run created by crontab
+type scheduledRun struct {
+	obj       client.Object
+	runStatus string
+}
+
+// getRunsOverHistoryLimit returns the finished runs beyond the history limits, the newest runs are kept
+func getRunsOverHistoryLimit(s *opsv1.ScheduleSpec, runs []scheduledRun) []client.Object {
+	sort.SliceStable(runs, func(i, j int) bool {
+		return runs[i].obj.GetCreationTimestamp().After(runs[j].obj.GetCreationTimestamp().Time)
+	})
+	overs := []client.Object{}
+	successful, failed := 0, 0
+	for _, run := range runs {
+		if !opsconstants.IsFinishedStatus(run.runStatus) {
+			continue
+		}
+		if run.runStatus == opsconstants.StatusSuccessed {
+			successful++
+			if s.SuccessfulRunsHistoryLimit != nil && successful > int(*s.SuccessfulRunsHistoryLimit) {
+				overs = append(overs, run.obj)
+			}
+			continue
+		}
+		failed++
+		if s.FailedRunsHistoryLimit != nil && failed > int(*s.FailedRunsHistoryLimit) {
+			overs = append(overs, run.obj)
+		}
+	}
+	return overs
+}
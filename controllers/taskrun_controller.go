@@ -32,6 +32,7 @@ import (
 	opshost "github.com/shaowenchen/ops/pkg/host"
 	opskube "github.com/shaowenchen/ops/pkg/kube"
 	opslog "github.com/shaowenchen/ops/pkg/log"
+	opslogstore "github.com/shaowenchen/ops/pkg/logstore"
 	opsmetrics "github.com/shaowenchen/ops/pkg/metrics"
 	opsoption "github.com/shaowenchen/ops/pkg/option"
 	opstask "github.com/shaowenchen/ops/pkg/task"
@@ -124,6 +125,15 @@ func (r *TaskRunReconciler) Reconcile(ctx context.Context, req ctrl.Request) (re
 		r.commitStatus(logger, ctx, tr, opsconstants.StatusDataInValid)
 		return ctrl.Result{}, err
 	}
+	// an invalid task fails before any step runs
+	if tr.Status.RunStatus == opsconstants.StatusEmpty {
+		if err = t.Validate(); err != nil {
+			logger.Error.Println(err)
+			tr.Status.Reason = err.Error()
+			r.commitStatus(logger, ctx, tr, opsconstants.StatusDataInValid)
+			return ctrl.Result{}, nil
+		}
+	}
 	// add crontab
 	// if has crontab, start timer and set status to Successed (don't run)
 	if tr.Spec.Crontab != "" {
@@ -132,11 +142,23 @@ func (r *TaskRunReconciler) Reconcile(ctx context.Context, req ctrl.Request) (re
 		r.commitStatus(logger, ctx, tr, opsconstants.StatusSuccessed)
 		return ctrl.Result{}, nil
 	}
+	// cancelled before it runs or while the controller restarted
+	if tr.Spec.Cancelled && !opsconstants.IsFinishedStatus(tr.Status.RunStatus) {
+		logger.Info.Printf("taskrun %s is %s", tr.GetUniqueKey(), tr.Spec.GetCancelledReason())
+		tr.Status.Reason = tr.Spec.GetCancelledReason()
+		r.commitStatus(logger, ctx, tr, opsconstants.StatusAborted)
+		err = opsevent.FactoryTaskRun(tr.Namespace, tr.Name, opsconstants.Status).Publish(ctx, tr)
+		return ctrl.Result{}, nil
+	}
 	// check run status
 	if tr.Status.RunStatus != opsconstants.StatusEmpty {
-		// abort running taskrun if restart or modified
+		// a running taskrun is only seen here if the controller restarted while running it,
+		// the steps may have been partly run on the nodes, so it is failed instead of run again
 		if tr.Status.RunStatus == opsconstants.StatusRunning {
-			r.commitStatus(logger, ctx, tr, opsconstants.StatusAborted)
+			logger.Info.Printf("taskrun %s was interrupted by controller restart", tr.GetUniqueKey())
+			tr.Status.Reason = opsconstants.ReasonControllerRestarted
+			r.commitStatus(logger, ctx, tr, opsconstants.StatusFailed)
+			err = opsevent.FactoryTaskRun(tr.Namespace, tr.Name, opsconstants.Status).Publish(ctx, tr)
 		}
 		return ctrl.Result{}, nil
 	}
@@ -192,94 +214,181 @@ func (r *TaskRunReconciler) addCronTab(logger *opslog.Logger, ctx context.Contex
 
 	logger.Info.Println(fmt.Sprintf("add ticker for taskrun %s with crontab %s", key, objRun.Spec.Crontab))
 
-	id, err := r.cron.AddFunc(objRun.Spec.Crontab, func() {
-		time.Sleep(time.Duration(rand.Intn(opsconstants.SyncCronRandomBias)) * time.Second)
-		// get the scheduled TaskRun to verify it still exists
-		scheduledTr := &opsv1.TaskRun{}
-		err := r.Client.Get(ctx, types.NamespacedName{Namespace: objRun.Namespace, Name: objRun.Name}, scheduledTr)
-		if err != nil {
-			logger.Error.Println(err)
-			return
+	namespacedName := types.NamespacedName{Namespace: objRun.Namespace, Name: objRun.Name}
+	id, err := r.cron.AddFunc(objRun.Spec.GetCronSpec(objRun.Spec.Crontab), func() {
+		r.runSchedule(logger, ctx, namespacedName, time.Now())
+	})
+
+	if err != nil {
+		logger.Error.Println(err)
+		return
+	}
+
+	r.crontabMapMutex.Lock()
+	r.crontabMap[key] = id
+	r.crontabMapMutex.Unlock()
+
+	// run the schedule missed while the ticker was not added, eg: the controller restarted
+	if !exists && objRun.Spec.StartingDeadlineSeconds != nil {
+		last := objRun.CreationTimestamp.Time
+		if objRun.Status.LastScheduleTime != nil {
+			last = objRun.Status.LastScheduleTime.Time
 		}
-		// verify it still has crontab
-		if scheduledTr.Spec.Crontab == "" {
-			logger.Info.Println(fmt.Sprintf("skip taskrun %s: crontab removed", scheduledTr.GetUniqueKey()))
-			return
+		missed, err := getMissedScheduleTime(objRun.Spec.GetCronSpec(objRun.Spec.Crontab), last, time.Now())
+		if err == nil && !missed.IsZero() && !objRun.Spec.IsStartingDeadlineExceeded(missed, time.Now()) {
+			logger.Info.Println(fmt.Sprintf("run missed schedule %s for taskrun %s", missed.Format(time.RFC3339), key))
+			go r.runSchedule(logger, ctx, namespacedName, missed)
 		}
-		// check if there's already a running instance created by this scheduled taskrun
-		taskRunList := &opsv1.TaskRunList{}
-		labelSelector := client.MatchingLabels{
-			opsconstants.LabelScheduledByKey:   scheduledTr.Name,
-			opsconstants.LabelScheduledKindKey: opsconstants.TaskRun,
+	}
+}
+
+// runSchedule creates a TaskRun for a schedule of the scheduled taskrun following its concurrency policy,
+// then removes the runs beyond the history limits
+func (r *TaskRunReconciler) runSchedule(logger *opslog.Logger, ctx context.Context, namespacedName types.NamespacedName, scheduledAt time.Time) {
+	// the random delay does not count for the starting deadline
+	triggeredAt := time.Now()
+	time.Sleep(time.Duration(rand.Intn(opsconstants.SyncCronRandomBias)) * time.Second)
+	// get the scheduled TaskRun to verify it still exists
+	scheduledTr := &opsv1.TaskRun{}
+	err := r.Client.Get(ctx, namespacedName, scheduledTr)
+	if err != nil {
+		logger.Error.Println(err)
+		return
+	}
+	// verify it still has crontab
+	if scheduledTr.Spec.Crontab == "" {
+		logger.Info.Println(fmt.Sprintf("skip taskrun %s: crontab removed", scheduledTr.GetUniqueKey()))
+		return
+	}
+	if scheduledTr.Spec.Suspend {
+		logger.Info.Println(fmt.Sprintf("skip taskrun %s: suspended", scheduledTr.GetUniqueKey()))
+		return
+	}
+	if scheduledTr.Spec.IsStartingDeadlineExceeded(scheduledAt, triggeredAt) {
+		logger.Info.Println(fmt.Sprintf("skip taskrun %s: missed starting deadline of schedule %s", scheduledTr.GetUniqueKey(), scheduledAt.Format(time.RFC3339)))
+		return
+	}
+	// check if there's already a running instance created by this scheduled taskrun
+	taskRunList := &opsv1.TaskRunList{}
+	labelSelector := client.MatchingLabels{
+		opsconstants.LabelScheduledByKey:   scheduledTr.Name,
+		opsconstants.LabelScheduledKindKey: opsconstants.TaskRun,
+	}
+	err = r.Client.List(ctx, taskRunList, client.InNamespace(scheduledTr.Namespace), labelSelector)
+	if err != nil {
+		logger.Error.Println(fmt.Sprintf("failed to list taskruns: %v", err))
+		return
+	}
+	for i := range taskRunList.Items {
+		tr := &taskRunList.Items[i]
+		if opsconstants.IsFinishedStatus(tr.Status.RunStatus) {
+			continue
 		}
-		err = r.Client.List(ctx, taskRunList, client.InNamespace(scheduledTr.Namespace), labelSelector)
-		if err != nil {
-			logger.Error.Println(fmt.Sprintf("failed to list taskruns: %v", err))
+		switch scheduledTr.Spec.GetConcurrencyPolicy() {
+		case opsconstants.ConcurrencyPolicyForbid:
+			logger.Info.Println(fmt.Sprintf("skip taskrun %s: already has a running instance %s", scheduledTr.GetUniqueKey(), tr.GetUniqueKey()))
 			return
-		}
-		for _, tr := range taskRunList.Items {
-			// check if this taskrun is running
-			if tr.Status.RunStatus == opsconstants.StatusRunning || tr.Status.RunStatus == opsconstants.StatusEmpty {
-				logger.Info.Println(fmt.Sprintf("skip taskrun %s: already has a running instance %s", scheduledTr.GetUniqueKey(), tr.GetUniqueKey()))
-				return
+		case opsconstants.ConcurrencyPolicyReplace:
+			logger.Info.Println(fmt.Sprintf("cancel taskrun %s replaced by a new instance of %s", tr.GetUniqueKey(), scheduledTr.GetUniqueKey()))
+			tr.Spec.Cancelled = true
+			tr.Spec.CancelledBy = "schedule " + scheduledTr.Name
+			if err := r.Client.Update(ctx, tr); err != nil {
+				logger.Error.Println(fmt.Sprintf("failed to cancel taskrun %s: %v", tr.GetUniqueKey(), err))
 			}
 		}
-		logger.Info.Println(fmt.Sprintf("cron triggered for taskrun %s, creating new execution instance", scheduledTr.GetUniqueKey()))
-		// get task
-		t := &opsv1.Task{}
-		err = r.Client.Get(ctx, types.NamespacedName{Namespace: scheduledTr.Namespace, Name: scheduledTr.Spec.TaskRef}, t)
+	}
+	logger.Info.Println(fmt.Sprintf("cron triggered for taskrun %s, creating new execution instance", scheduledTr.GetUniqueKey()))
+	// get task
+	t := &opsv1.Task{}
+	err = r.Client.Get(ctx, types.NamespacedName{Namespace: scheduledTr.Namespace, Name: scheduledTr.Spec.TaskRef}, t)
+	if err != nil {
+		logger.Error.Println(err)
+		return
+	}
+	// create a new TaskRun without crontab for execution
+	newTr := opsv1.NewTaskRun(t)
+	newTr.Spec.Crontab = "" // ensure no crontab
+	// copy variables from scheduled taskrun (deep copy)
+	if scheduledTr.Spec.Variables != nil {
+		newTr.Spec.Variables = make(map[string]string)
+		for k, v := range scheduledTr.Spec.Variables {
+			newTr.Spec.Variables[k] = v
+		}
+	}
+	newTr.Spec.Desc = scheduledTr.Spec.Desc // copy desc
+	// ensure labels map exists (NewTaskRun already creates it with LabelTaskRefKey)
+	if newTr.Labels == nil {
+		newTr.Labels = make(map[string]string)
+	}
+	// ensure taskref label is set
+	newTr.Labels[opsconstants.LabelTaskRefKey] = t.ObjectMeta.GetName()
+	// set labels to identify this is created by the scheduled taskrun
+	newTr.Labels[opsconstants.LabelScheduledByKey] = scheduledTr.Name
+	newTr.Labels[opsconstants.LabelScheduledKindKey] = opsconstants.TaskRun
+	// set owner reference to the scheduled taskrun
+	if scheduledTr.UID != "" {
+		newTr.OwnerReferences = []metav1.OwnerReference{
+			{
+				APIVersion: opsconstants.APIVersion,
+				Kind:       opsconstants.TaskRun,
+				Name:       scheduledTr.Name,
+				UID:        scheduledTr.UID,
+			},
+		}
+	}
+	// create the new taskrun
+	err = r.Client.Create(ctx, &newTr)
+	if err != nil {
+		logger.Error.Println(fmt.Sprintf("failed to create new taskrun for scheduled taskrun %s: %v", scheduledTr.GetUniqueKey(), err))
+		return
+	}
+	logger.Info.Println(fmt.Sprintf("created new taskrun %s for scheduled taskrun %s", newTr.GetUniqueKey(), scheduledTr.GetUniqueKey()))
+	r.commitScheduleStatus(logger, ctx, scheduledTr, scheduledAt, newTr.Name)
+	// remove the runs beyond the history limits
+	runs := []scheduledRun{}
+	for i := range taskRunList.Items {
+		runs = append(runs, scheduledRun{obj: &taskRunList.Items[i], runStatus: taskRunList.Items[i].Status.RunStatus})
+	}
+	for _, obj := range getRunsOverHistoryLimit(&scheduledTr.Spec.ScheduleSpec, runs) {
+		logger.Info.Println(fmt.Sprintf("remove taskrun %s/%s beyond the history limit of %s", obj.GetNamespace(), obj.GetName(), scheduledTr.GetUniqueKey()))
+		if err := r.Client.Delete(ctx, obj); err != nil && !apierrors.IsNotFound(err) {
+			logger.Error.Println(err)
+		}
+	}
+}
+
+// commitScheduleStatus records the last run created by a scheduled taskrun
+func (r *TaskRunReconciler) commitScheduleStatus(logger *opslog.Logger, ctx context.Context, scheduledTr *opsv1.TaskRun, scheduledAt time.Time, runName string) (err error) {
+	for retries := 0; retries < CommitStatusMaxRetries; retries++ {
+		latestTr := &opsv1.TaskRun{}
+		err = r.Client.Get(ctx, types.NamespacedName{Namespace: scheduledTr.Namespace, Name: scheduledTr.Name}, latestTr)
 		if err != nil {
 			logger.Error.Println(err)
 			return
 		}
-		// create a new TaskRun without crontab for execution
-		newTr := opsv1.NewTaskRun(t)
-		newTr.Spec.Crontab = "" // ensure no crontab
-		// copy variables from scheduled taskrun (deep copy)
-		if scheduledTr.Spec.Variables != nil {
-			newTr.Spec.Variables = make(map[string]string)
-			for k, v := range scheduledTr.Spec.Variables {
-				newTr.Spec.Variables[k] = v
-			}
-		}
-		newTr.Spec.Desc = scheduledTr.Spec.Desc // copy desc
-		// ensure labels map exists (NewTaskRun already creates it with LabelTaskRefKey)
-		if newTr.Labels == nil {
-			newTr.Labels = make(map[string]string)
-		}
-		// ensure taskref label is set
-		newTr.Labels[opsconstants.LabelTaskRefKey] = t.ObjectMeta.GetName()
-		// set labels to identify this is created by the scheduled taskrun
-		newTr.Labels[opsconstants.LabelScheduledByKey] = scheduledTr.Name
-		newTr.Labels[opsconstants.LabelScheduledKindKey] = opsconstants.TaskRun
-		// set owner reference to the scheduled taskrun
-		if scheduledTr.UID != "" {
-			newTr.OwnerReferences = []metav1.OwnerReference{
-				{
-					APIVersion: opsconstants.APIVersion,
-					Kind:       opsconstants.TaskRun,
-					Name:       scheduledTr.Name,
-					UID:        scheduledTr.UID,
-				},
+		latestTr.Status.LastScheduleTime = &metav1.Time{Time: scheduledAt}
+		latestTr.Status.LastRunName = runName
+		err = r.Client.Status().Update(ctx, latestTr)
+		if !apierrors.IsConflict(err) {
+			if err != nil {
+				logger.Error.Println(err, "update taskrun schedule status error")
 			}
-		}
-		// create the new taskrun
-		err = r.Client.Create(ctx, &newTr)
-		if err != nil {
-			logger.Error.Println(fmt.Sprintf("failed to create new taskrun for scheduled taskrun %s: %v", scheduledTr.GetUniqueKey(), err))
 			return
 		}
-		logger.Info.Println(fmt.Sprintf("created new taskrun %s for scheduled taskrun %s", newTr.GetUniqueKey(), scheduledTr.GetUniqueKey()))
-	})
-
-	if err != nil {
-		logger.Error.Println(err)
-		return
 	}
+	logger.Error.Println("update taskrun schedule status failed after retries", err)
+	return
+}
 
-	r.crontabMapMutex.Lock()
-	r.crontabMap[key] = id
-	r.crontabMapMutex.Unlock()
+// isKeptByHistoryLimit returns true if a taskrun is created by a scheduled taskrun with history limits
+func (r *TaskRunReconciler) isKeptByHistoryLimit(tr *opsv1.TaskRun) bool {
+	scheduledBy := tr.Labels[opsconstants.LabelScheduledByKey]
+	if scheduledBy == "" {
+		return false
+	}
+	scheduledTr := &opsv1.TaskRun{}
+	err := r.Client.Get(context.Background(), types.NamespacedName{Namespace: tr.Namespace, Name: scheduledBy}, scheduledTr)
+	return err == nil && scheduledTr.Spec.HasHistoryLimit()
 }
 
 func (r *TaskRunReconciler) registerClearCron() {
@@ -297,6 +406,10 @@ func (r *TaskRunReconciler) registerClearCron() {
 			if obj.Spec.Crontab != "" {
 				continue
 			}
+			// the runs of a schedule with history limits are removed by the limits
+			if r.isKeptByHistoryLimit(&obj) {
+				continue
+			}
 			if obj.Status.RunStatus == opsconstants.StatusRunning || obj.Status.RunStatus == opsconstants.StatusEmpty {
 				continue
 			}
@@ -311,28 +424,72 @@ func (r *TaskRunReconciler) registerClearCron() {
 
 func (r *TaskRunReconciler) run(logger *opslog.Logger, ctx context.Context, t *opsv1.Task, tr *opsv1.TaskRun) (err error) {
 	tr.Status.ClearNodeStatus()
+	tr.Status.Reason = ""
 	r.commitStatus(logger, ctx, tr, opsconstants.StatusRunning)
+	// stop running once the taskrun is aborted by others, eg: timeout of pipelinerun
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go r.watchAborted(runCtx, cancel, tr)
 
 	tr.MergeVariables(t)
+	// the variables from Secrets and ConfigMaps are only used to run, they are not saved in the taskrun or events
+	valueFrom, secrets, err := opstask.ResolveValueFrom(ctx, r.Client, tr.Namespace, t, tr.Spec.Variables)
+	if err != nil {
+		logger.Error.Println(err)
+		tr.Status.Reason = err.Error()
+		r.commitStatus(logger, ctx, tr, opsconstants.StatusFailed)
+		return nil
+	}
+	runOpt := opsoption.TaskOption{Variables: valueFrom, Secrets: secrets, SecretVariables: opstask.GetSecretVariables(t, valueFrom)}
 	hosts := r.getAvaliableHosts(logger, ctx, t, tr)
-
-	cliLogger := opslog.NewLogger().SetStd().WaitFlush().Build()
+	// the nodes of a dry run are Planned instead of Successed
+	doneStatus := opsconstants.StatusSuccessed
+	if tr.Spec.DryRun {
+		doneStatus = opsconstants.StatusPlanned
+	}
 
 	// only run script
 	if len(hosts) > 0 && t.OnlyScript() && !t.NeedKubeExecution() {
-		for _, h := range hosts {
-			logger.Info.Printf("run task %s on host %s", t.GetUniqueKey(), t.Spec.Host)
-			err = r.runTaskOnHost(cliLogger, ctx, r.Client, t, tr, &h)
-			if err != nil {
-				logger.Error.Println(err)
+		var statusMutex sync.Mutex
+		// tr is updated by the hosts finished, so the copies of hosts are made from a snapshot taken before the rollout
+		snapshot := tr.DeepCopy()
+		snapshot.Status.ClearNodeStatus()
+		skipped := opstask.NewRollout(t, 0).Run(runCtx, len(hosts), func(i int) bool {
+			h := hosts[i]
+			// every host runs with its own copy of the taskrun, the status of its node is merged once it finished
+			hostTr := snapshot.DeepCopy()
+			hostLogger := opslog.NewLogger().SetStd().WaitFlush().Build()
+			logger.Info.Printf("run task %s on host %s", t.GetUniqueKey(), h.Name)
+			hostErr := r.runTaskOnHost(hostLogger, runCtx, r.Client, t, hostTr, &h, runOpt)
+			if hostErr != nil {
+				logger.Error.Println(hostErr)
+			}
+			hostLogger.Flush()
+			statusMutex.Lock()
+			defer statusMutex.Unlock()
+			ok := hostErr == nil && len(hostTr.Status.TaskRunNodeStatus) > 0
+			for nodeName, nodeStatus := range hostTr.Status.TaskRunNodeStatus {
+				tr.Status.SetNodeStatus(nodeName, nodeStatus)
+				if nodeStatus.RunStatus != doneStatus {
+					ok = false
+				}
 			}
-			cliLogger.Flush()
+			// persist the finished host as progress
+			r.commitStatus(logger, ctx, tr, "")
+			return ok
+		})
+		for _, i := range skipped {
+			tr.Status.AddSkippedNode(hosts[i].Name)
+		}
+		if len(skipped) > 0 && runCtx.Err() == nil {
+			tr.Status.Reason = fmt.Sprintf("%d of %d hosts skipped after too many hosts failed", len(skipped), len(hosts))
 		}
 	} else {
+		cliLogger := opslog.NewLogger().SetStd().WaitFlush().Build()
 		cluster := opsv1.NewCurrentCluster()
 
 		logger.Info.Printf("run task %s on cluster %s", t.GetUniqueKey(), cluster.Name)
-		err = r.runTaskOnKube(cliLogger, ctx, t, tr, &cluster)
+		err = r.runTaskOnKube(cliLogger, runCtx, t, tr, &cluster, runOpt)
 		if err != nil {
 			logger.Error.Println(err)
 		}
@@ -341,7 +498,7 @@ func (r *TaskRunReconciler) run(logger *opslog.Logger, ctx context.Context, t *o
 	// get taskrun status
 	finallyStatus := opsconstants.StatusSuccessed
 	for _, node := range tr.Status.TaskRunNodeStatus {
-		if node.RunStatus != opsconstants.StatusSuccessed {
+		if node.RunStatus != doneStatus {
 			finallyStatus = opsconstants.StatusFailed
 		}
 	}
@@ -356,7 +513,27 @@ func (r *TaskRunReconciler) run(logger *opslog.Logger, ctx context.Context, t *o
 	return
 }
 
-func (r *TaskRunReconciler) runTaskOnHost(logger *opslog.Logger, ctx context.Context, client client.Client, t *opsv1.Task, tr *opsv1.TaskRun, h *opsv1.Host) (err error) {
+// watchAborted polls the taskrun and cancels the run once it is aborted or cancelled by others
+func (r *TaskRunReconciler) watchAborted(ctx context.Context, cancel context.CancelFunc, tr *opsv1.TaskRun) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(3 * time.Second):
+		}
+		latestTr := &opsv1.TaskRun{}
+		err := r.Client.Get(ctx, types.NamespacedName{Namespace: tr.Namespace, Name: tr.Name}, latestTr)
+		if err != nil {
+			continue
+		}
+		if opsconstants.IsAbortedStatus(latestTr.Status.RunStatus) || latestTr.Spec.Cancelled {
+			cancel()
+			return
+		}
+	}
+}
+
+func (r *TaskRunReconciler) runTaskOnHost(logger *opslog.Logger, ctx context.Context, client client.Client, t *opsv1.Task, tr *opsv1.TaskRun, h *opsv1.Host, runOpt opsoption.TaskOption) (err error) {
 	// fill variables
 	if tr.Spec.Variables == nil {
 		tr.Spec.Variables = make(map[string]string)
@@ -380,6 +557,10 @@ func (r *TaskRunReconciler) runTaskOnHost(logger *opslog.Logger, ctx context.Con
 		vars[k] = v
 	}
 
+	// a dry run only renders the steps, it does not connect to the host
+	if tr.Spec.DryRun {
+		return opstask.PlanTask(logger, t, tr, h.Name, withRunOption(vars, runOpt))
+	}
 	// filled host
 	if h.Spec.SecretRef != "" {
 		err = filledHostFromSecret(h, client, h.Spec.SecretRef)
@@ -393,13 +574,27 @@ func (r *TaskRunReconciler) runTaskOnHost(logger *opslog.Logger, ctx context.Con
 	if err != nil {
 		return err
 	}
-	err = opstask.RunTaskOnHost(ctx, logger, t, tr, hc, opsoption.TaskOption{
-		Variables: vars,
-	})
+	err = opstask.RunTaskOnHost(ctx, logger, t, tr, hc, withRunOption(vars, runOpt))
 	return err
 }
 
-func (r *TaskRunReconciler) runTaskOnKube(logger *opslog.Logger, ctx context.Context, t *opsv1.Task, tr *opsv1.TaskRun, cluster *opsv1.Cluster) (err error) {
+// withRunOption returns the option to run a task with the variables of the taskrun and the values from Secrets and ConfigMaps
+func withRunOption(vars map[string]string, runOpt opsoption.TaskOption) opsoption.TaskOption {
+	allVars := make(map[string]string, len(vars)+len(runOpt.Variables))
+	for k, v := range vars {
+		allVars[k] = v
+	}
+	for k, v := range runOpt.Variables {
+		allVars[k] = v
+	}
+	return opsoption.TaskOption{
+		Variables:       allVars,
+		Secrets:         runOpt.Secrets,
+		SecretVariables: runOpt.SecretVariables,
+	}
+}
+
+func (r *TaskRunReconciler) runTaskOnKube(logger *opslog.Logger, ctx context.Context, t *opsv1.Task, tr *opsv1.TaskRun, cluster *opsv1.Cluster, runOpt opsoption.TaskOption) (err error) {
 	// connecting
 	kc, err := opskube.NewClusterConnection(cluster)
 	if err != nil {
@@ -411,7 +606,7 @@ func (r *TaskRunReconciler) runTaskOnKube(logger *opslog.Logger, ctx context.Con
 	host, _ := kc.GetHost(opsconstants.OpsNamespace, tr.GetHost(t))
 	if host != nil && !t.NeedKubeExecution() {
 		logger.Debug.Println("use host credentials to run cluster task " + tr.Name)
-		return r.runTaskOnHost(logger, ctx, *kc.OpsClient, t, tr, host)
+		return r.runTaskOnHost(logger, ctx, *kc.OpsClient, t, tr, host, runOpt)
 	}
 	// else use pod to run task
 	// build options
@@ -483,6 +678,9 @@ func (r *TaskRunReconciler) runTaskOnKube(logger *opslog.Logger, ctx context.Con
 	}
 	r.commitStatus(logger, ctx, tr, opsconstants.StatusRunning)
 	for _, node := range nodes {
+		if ctx.Err() != nil {
+			break
+		}
 		if tr.Spec.Variables == nil {
 			tr.Spec.Variables = make(map[string]string)
 		}
@@ -498,10 +696,16 @@ func (r *TaskRunReconciler) runTaskOnKube(logger *opslog.Logger, ctx context.Con
 		}
 		vars["TASK"] = t.Name
 		vars["TASKRUN"] = tr.Name
-		opstask.RunTaskOnKube(logger, t, tr, kc, &node,
-			opsoption.TaskOption{
-				Variables: vars,
-			}, kubeOpt)
+		if tr.Spec.DryRun {
+			// no pod is created in a dry run
+			if err = opstask.PlanTask(logger, t, tr, node.Name, withRunOption(vars, runOpt)); err != nil {
+				logger.Error.Println(err)
+			}
+		} else {
+			opstask.RunTaskOnKube(ctx, logger, t, tr, kc, &node, withRunOption(vars, runOpt), kubeOpt)
+		}
+		// persist the finished node as progress
+		r.commitStatus(logger, ctx, tr, "")
 	}
 	return
 }
@@ -583,9 +787,11 @@ func (r *TaskRunReconciler) commitStatus(logger *opslog.Logger, ctx context.Cont
 	if status != "" {
 		tr.Status.RunStatus = status
 	}
-	if tr.Status.RunStatus == opsconstants.StatusRunning {
+	if status == opsconstants.StatusRunning {
 		tr.Status.StartTime = &metav1.Time{Time: time.Now()}
 	}
+	// keep the status under the size limit of etcd, eg: a chatty task on many nodes
+	r.offloadStepOutputs(logger, ctx, tr)
 
 	for retries := 0; retries < CommitStatusMaxRetries; retries++ {
 		latestTr := &opsv1.TaskRun{}
@@ -594,8 +800,18 @@ func (r *TaskRunReconciler) commitStatus(logger *opslog.Logger, ctx context.Cont
 			logger.Error.Println(err)
 			return
 		}
+		// keep the status if the taskrun is aborted by others, eg: timeout of pipelinerun
+		if opsconstants.IsAbortedStatus(latestTr.Status.RunStatus) {
+			tr.Status.RunStatus = latestTr.Status.RunStatus
+		}
+		// a cancelled taskrun ends as aborted
+		if latestTr.Spec.Cancelled && opsconstants.IsFinishedStatus(tr.Status.RunStatus) {
+			tr.Status.RunStatus = opsconstants.StatusAborted
+			tr.Status.Reason = latestTr.Spec.GetCancelledReason()
+		}
 		// merge status - always use tr.Status which contains the latest execution logs
 		latestTr.Status.RunStatus = tr.Status.RunStatus
+		latestTr.Status.Reason = tr.Status.Reason
 		latestTr.Status.StartTime = tr.Status.StartTime
 		// Always update TaskRunNodeStatus from tr.Status (contains execution logs)
 		// This ensures execution logs are preserved even if tr.Status.TaskRunNodeStatus is empty
@@ -618,8 +834,6 @@ func (r *TaskRunReconciler) commitStatus(logger *opslog.Logger, ctx context.Cont
 					opsmetrics.RecordTaskRunStatusPhase(latestTr.Namespace, latestTr.Spec.TaskRef, oldStatus, latestTr.Status.RunStatus)
 				}
 			}
-			//need to improve
-			time.Sleep(3 * time.Second)
 			return
 		}
 		if !apierrors.IsConflict(err) {
@@ -633,6 +847,25 @@ func (r *TaskRunReconciler) commitStatus(logger *opslog.Logger, ctx context.Cont
 	return
 }
 
+// offloadStepOutputs writes the large outputs of steps to the log store and truncates them, nothing is truncated without a log store
+func (r *TaskRunReconciler) offloadStepOutputs(logger *opslog.Logger, ctx context.Context, tr *opsv1.TaskRun) {
+	maxBytes := opsconstants.GetEnvStepOutputMaxBytes()
+	if maxBytes <= 0 {
+		return
+	}
+	store, err := opslogstore.Default()
+	if err != nil {
+		logger.Error.Println(err)
+	}
+	if store == nil {
+		return
+	}
+	err = opslogstore.OffloadStepOutputs(ctx, store, tr, maxBytes)
+	if err != nil {
+		logger.Error.Println(err)
+	}
+}
+
 func (r *TaskRunReconciler) getAvaliableHosts(logger *opslog.Logger, ctx context.Context, t *opsv1.Task, tr *opsv1.TaskRun) (hosts []opsv1.Host) {
 	selectHosts := r.getHosts(logger, ctx, t, tr)
 	for _, host := range selectHosts {
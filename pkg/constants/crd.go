@@ -38,12 +38,33 @@ const StatusSuccessed = "Successed"
 const StatusFailed = "Failed"
 const StatusRunning = "Running"
 const StatusAborted = "Aborted"
+const StatusTimeout = "Timeout"
 const StatusDataInValid = "DataInValid"
 const StatusDispatched = "Dispatched"
+const StatusPending = "Pending"
+const StatusSkipped = "Skipped"
+const StatusPlanned = "Planned"
+const StatusWaitingApproval = "WaitingApproval"
 const StatusEmpty = ""
 
+// ReasonControllerRestarted is the reason of a run that was interrupted by a restart of the controller
+const ReasonControllerRestarted = "ControllerRestarted"
+
+// ConditionClusterReachable tells whether the cluster a PipelineRun is dispatched to can be reached
+const ConditionClusterReachable = "ClusterReachable"
+
+const ReasonDispatched = "Dispatched"
+const ReasonSynced = "Synced"
+const ReasonClusterUnreachable = "ClusterUnreachable"
+const ReasonRemoteNotFound = "RemoteNotFound"
+
 func IsFinishedStatus(status string) bool {
-	return status == StatusSuccessed || status == StatusFailed || status == StatusAborted || status == StatusDataInValid
+	return status == StatusSuccessed || status == StatusFailed || status == StatusAborted || status == StatusDataInValid || status == StatusTimeout
+}
+
+// IsAbortedStatus returns true if the run was stopped before it finished
+func IsAbortedStatus(status string) bool {
+	return status == StatusAborted || status == StatusTimeout
 }
 
 const (
@@ -54,6 +75,32 @@ const (
 	LabelPipelineRefKey            = "ops/pipelineref"
 	LabelScheduledByKey            = "ops/scheduledby"
 	LabelScheduledKindKey          = "ops/scheduledkind"
+	LabelPipelineRunKey            = "ops/pipelinerun"
+	LabelPipelineTaskKey           = "ops/pipelinetask"
+	LabelAttemptKey                = "ops/attempt"
+	LabelMatrixKey                 = "ops/matrix"
+	LabelClusterKey                = "ops/cluster"
+	AnnotationRerunOfKey           = "ops/rerun-of"
+	AnnotationPipelineChainKey     = "ops/pipeline-chain"
+	MaxPipelineDepth               = 5
+	MaxRetryBackoffSeconds         = 60 * 10
 	DefaultTTLSecondsAfterFinished = 60 * 10
 	ClearCronTab                   = "*/30 * * * *"
 )
+
+// ConcurrencyPolicy of the runs created by crontab
+const (
+	ConcurrencyPolicyAllow   = "Allow"   // runs may overlap
+	ConcurrencyPolicyForbid  = "Forbid"  // skip a schedule if the last run is not finished
+	ConcurrencyPolicyReplace = "Replace" // cancel the runs not finished, then start a new one
+)
+
+const (
+	ApprovalApproved = "Approved"
+	ApprovalRejected = "Rejected"
+)
+
+const (
+	VariablePipelineStatus     = "pipeline.status"
+	VariablePipelineFailedTask = "pipeline.failedTask"
+)
@@ -17,6 +17,24 @@ const (
 	EnvEventListSubjectsTimeoutKey = "EVENT_LIST_SUBJECTS_TIMEOUT"
 	// EnvEventhookKeywordLogKey: set to true/1/yes/on to enable EventHooks keyword match judgment Info logs (controller). Default: off.
 	EnvEventhookKeywordLogKey = "EVENTHOOK_KEYWORD_LOG"
+	// log store of the full outputs of steps, shared by controller and server
+	EnvLogStoreKey           = "LOG_STORE"
+	EnvLogStoreDirKey        = "LOG_STORE_DIR"
+	EnvLogStoreS3EndpointKey = "LOG_STORE_S3_ENDPOINT"
+	EnvLogStoreS3RegionKey   = "LOG_STORE_S3_REGION"
+	EnvLogStoreS3BucketKey   = "LOG_STORE_S3_BUCKET"
+	EnvLogStoreS3AKKey       = "LOG_STORE_S3_AK"
+	EnvLogStoreS3SKKey       = "LOG_STORE_S3_SK"
+	EnvStepOutputMaxBytesKey = "STEP_OUTPUT_MAX_BYTES"
+)
+
+const (
+	LogStoreLocal = "local"
+	LogStoreS3    = "s3"
+
+	DefaultLogStoreDir = "/data/ops/logs"
+	// DefaultStepOutputMaxBytes keeps the status of a TaskRun on many nodes under the size limit of etcd
+	DefaultStepOutputMaxBytes = 32 * 1024
 )
 
 // just for controller
@@ -110,3 +128,28 @@ func GetEnvEventQueryTimeout() uint {
 func GetEnvEventListSubjectsTimeout() uint {
 	return GetEventListSubjectsTimeout(0)
 }
+
+// GetEnvLogStore returns the type of the log store, local or s3, empty means the full outputs of steps are not kept
+func GetEnvLogStore() string {
+	return strings.ToLower(strings.TrimSpace(os.Getenv(EnvLogStoreKey)))
+}
+
+func GetEnvLogStoreDir() string {
+	if dir := os.Getenv(EnvLogStoreDirKey); dir != "" {
+		return dir
+	}
+	return DefaultLogStoreDir
+}
+
+// GetEnvStepOutputMaxBytes returns the max bytes of a step output kept in the status of a TaskRun, 0 means no limit
+// it only applies with a log store, the outputs are not truncated if LOG_STORE is empty
+func GetEnvStepOutputMaxBytes() int {
+	value := os.Getenv(EnvStepOutputMaxBytesKey)
+	if value != "" {
+		result, err := strconv.Atoi(value)
+		if err == nil && result >= 0 {
+			return result
+		}
+	}
+	return DefaultStepOutputMaxBytes
+}
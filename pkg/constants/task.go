@@ -1,3 +1,6 @@
 package constants
 
 const NoOutput = "no output"
+
+// MaskedValue replaces the values from Secrets in the outputs and logs of tasks
+const MaskedValue = "***"
@@ -0,0 +1,374 @@
+// Package expr implements the expressions of when, until and allowfailure
+//
+// An expression compares operands with ==, !=, >, >=, <, <=, contains, matches and in,
+// and combines the comparisons with &&, ||, ! (or and, or, not) and parentheses.
+// An operand is a string in quotes, a number, true or false, a list like [a, b],
+// a function call like startwith(${name}, prod), a variable like ${output} or ${steps.check.output},
+// or a word that is not quoted, which is a string, eg: Successed in ${status} == Successed.
+// Variables are resolved when the expression is evaluated, their values are typed by their content,
+// eg: ${count} > 3 compares numbers, and ${enabled} is a boolean.
+package expr
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Resolver returns the value of a variable referenced by ${name}
+type Resolver func(name string) (string, bool)
+
+// MapResolver resolves variables from vars
+func MapResolver(vars map[string]string) Resolver {
+	return func(name string) (string, bool) {
+		value, ok := vars[name]
+		return value, ok
+	}
+}
+
+// Expression is a parsed expression
+type Expression struct {
+	raw  string
+	root node
+}
+
+// Validate returns the error if exp can not be parsed, an empty exp is valid
+func Validate(exp string) error {
+	if strings.TrimSpace(exp) == "" {
+		return nil
+	}
+	_, err := Parse(exp)
+	return err
+}
+
+// EvalBool evaluates exp as a condition, ifEmptyDefault is returned if exp is empty
+// A variable that resolve can not resolve is kept as it is, eg: ${name} is the string "${name}"
+func EvalBool(exp string, ifEmptyDefault bool, resolve Resolver) (bool, error) {
+	if strings.TrimSpace(exp) == "" {
+		return ifEmptyDefault, nil
+	}
+	e, err := Parse(exp)
+	if err != nil {
+		return false, err
+	}
+	return e.EvalBool(resolve)
+}
+
+// EvalBool evaluates the expression as a condition
+func (e *Expression) EvalBool(resolve Resolver) (bool, error) {
+	value, err := e.root.eval(resolve)
+	if err != nil {
+		return false, fmt.Errorf("failed to evaluate %q: %v", e.raw, err)
+	}
+	result, err := toBool(value)
+	if err != nil {
+		return false, fmt.Errorf("failed to evaluate %q: %v", e.raw, err)
+	}
+	return result, nil
+}
+
+// the value of an operand is a string, float64, bool or []interface{}
+type node interface {
+	eval(resolve Resolver) (interface{}, error)
+}
+
+type literalNode struct {
+	value interface{}
+}
+
+func (n *literalNode) eval(resolve Resolver) (interface{}, error) {
+	return n.value, nil
+}
+
+// varNode is a variable, its value is trimmed, eg: the newline at the end of an output
+type varNode struct {
+	name string
+}
+
+func (n *varNode) eval(resolve Resolver) (interface{}, error) {
+	return resolveVar(n.name, resolve), nil
+}
+
+func resolveVar(name string, resolve Resolver) string {
+	if resolve != nil {
+		if value, ok := resolve(name); ok {
+			return strings.TrimSpace(value)
+		}
+	}
+	return "${" + name + "}"
+}
+
+// textNode is a string with variables, eg: "${name}-prod"
+type textNode struct {
+	text string
+}
+
+func newTextNode(text string) node {
+	if !strings.Contains(text, "${") {
+		return &literalNode{value: text}
+	}
+	return &textNode{text: text}
+}
+
+func (n *textNode) eval(resolve Resolver) (interface{}, error) {
+	return varRegexp.ReplaceAllStringFunc(n.text, func(ref string) string {
+		return resolveVar(strings.TrimSpace(ref[2:len(ref)-1]), resolve)
+	}), nil
+}
+
+type listNode struct {
+	items []node
+}
+
+func (n *listNode) eval(resolve Resolver) (interface{}, error) {
+	list := make([]interface{}, 0, len(n.items))
+	for _, item := range n.items {
+		value, err := item.eval(resolve)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, value)
+	}
+	return list, nil
+}
+
+type notNode struct {
+	x node
+}
+
+func (n *notNode) eval(resolve Resolver) (interface{}, error) {
+	x, err := evalBool(n.x, resolve)
+	if err != nil {
+		return nil, err
+	}
+	return !x, nil
+}
+
+type logicNode struct {
+	or   bool
+	x, y node
+}
+
+func (n *logicNode) eval(resolve Resolver) (interface{}, error) {
+	x, err := evalBool(n.x, resolve)
+	if err != nil {
+		return nil, err
+	}
+	if x == n.or {
+		return x, nil
+	}
+	return evalBool(n.y, resolve)
+}
+
+func evalBool(n node, resolve Resolver) (bool, error) {
+	value, err := n.eval(resolve)
+	if err != nil {
+		return false, err
+	}
+	return toBool(value)
+}
+
+type compareNode struct {
+	op   string
+	x, y node
+}
+
+func (n *compareNode) eval(resolve Resolver) (interface{}, error) {
+	x, err := n.x.eval(resolve)
+	if err != nil {
+		return nil, err
+	}
+	y, err := n.y.eval(resolve)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "==":
+		return isEqual(x, y), nil
+	case "!=":
+		return !isEqual(x, y), nil
+	case "contains":
+		if list, ok := x.([]interface{}); ok {
+			return isInList(y, list), nil
+		}
+		return strings.Contains(toString(x), toString(y)), nil
+	case "matches":
+		re, err := regexp.Compile(toString(y))
+		if err != nil {
+			return nil, fmt.Errorf("invalid regexp %q: %v", toString(y), err)
+		}
+		return re.MatchString(toString(x)), nil
+	case "in":
+		return isInList(x, toList(y)), nil
+	}
+	left, ok := toNumber(x)
+	if !ok {
+		return nil, fmt.Errorf("%q is not a number", toString(x))
+	}
+	right, ok := toNumber(y)
+	if !ok {
+		return nil, fmt.Errorf("%q is not a number", toString(y))
+	}
+	switch n.op {
+	case ">":
+		return left > right, nil
+	case ">=":
+		return left >= right, nil
+	case "<":
+		return left < right, nil
+	case "<=":
+		return left <= right, nil
+	}
+	return nil, fmt.Errorf("unknown operator %s", n.op)
+}
+
+type callNode struct {
+	name string
+	fn   func(args []interface{}) interface{}
+	args []node
+}
+
+type function struct {
+	args int
+	fn   func(args []interface{}) interface{}
+}
+
+var functions = map[string]function{
+	"startwith": {args: 2, fn: func(args []interface{}) interface{} {
+		return strings.HasPrefix(toString(args[0]), toString(args[1]))
+	}},
+	"endwith": {args: 2, fn: func(args []interface{}) interface{} {
+		return strings.HasSuffix(toString(args[0]), toString(args[1]))
+	}},
+	"len": {args: 1, fn: func(args []interface{}) interface{} {
+		if list, ok := args[0].([]interface{}); ok {
+			return float64(len(list))
+		}
+		return float64(len(toString(args[0])))
+	}},
+}
+
+func newCallNode(t token, args []node) (node, error) {
+	f, ok := functions[t.text]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %s at %d", t.text, t.pos)
+	}
+	if len(args) != f.args {
+		return nil, fmt.Errorf("function %s wants %d arguments, got %d", t.text, f.args, len(args))
+	}
+	return &callNode{name: t.text, fn: f.fn, args: args}, nil
+}
+
+func (n *callNode) eval(resolve Resolver) (interface{}, error) {
+	args := make([]interface{}, 0, len(n.args))
+	for _, arg := range n.args {
+		value, err := arg.eval(resolve)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, value)
+	}
+	return n.fn(args), nil
+}
+
+func mustParseNumber(s string) float64 {
+	f, _ := strconv.ParseFloat(s, 64)
+	return f
+}
+
+func toNumber(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case string:
+		if numberRegexp.MatchString(v) {
+			return mustParseNumber(v), true
+		}
+	}
+	return 0, false
+}
+
+// toBool converts a value to a boolean, strings like true, false, 1 and 0 are booleans
+func toBool(value interface{}) (bool, error) {
+	switch v := value.(type) {
+	case bool:
+		return v, nil
+	case float64:
+		return v != 0, nil
+	case string:
+		switch strings.ToLower(strings.TrimSpace(v)) {
+		case "true", "1":
+			return true, nil
+		case "false", "0", "":
+			return false, nil
+		}
+	}
+	return false, fmt.Errorf("%q is not a boolean", toString(value))
+}
+
+func toString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			items = append(items, toString(item))
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	}
+	return fmt.Sprint(value)
+}
+
+// toList converts a value to a list, a string is a json array or separated by comma
+func toList(value interface{}) []interface{} {
+	switch v := value.(type) {
+	case []interface{}:
+		return v
+	case string:
+		list := []interface{}{}
+		if strings.HasPrefix(strings.TrimSpace(v), "[") && json.Unmarshal([]byte(v), &list) == nil {
+			return list
+		}
+		for _, item := range strings.Split(v, ",") {
+			list = append(list, strings.TrimSpace(item))
+		}
+		return list
+	}
+	return []interface{}{value}
+}
+
+func isInList(value interface{}, list []interface{}) bool {
+	for _, item := range list {
+		if isEqual(value, item) {
+			return true
+		}
+	}
+	return false
+}
+
+// isEqual compares numbers if both values are numbers, booleans if one of them is a boolean, else strings
+func isEqual(x, y interface{}) bool {
+	if left, ok := toNumber(x); ok {
+		if right, ok := toNumber(y); ok {
+			return left == right
+		}
+	}
+	_, isLeftBool := x.(bool)
+	_, isRightBool := y.(bool)
+	if isLeftBool || isRightBool {
+		left, err := toBool(x)
+		if err != nil {
+			return false
+		}
+		right, err := toBool(y)
+		return err == nil && left == right
+	}
+	return toString(x) == toString(y)
+}
@@ -0,0 +1,157 @@
+package expr
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEvalBool(t *testing.T) {
+	vars := map[string]string{
+		"output":  "  Ready\n",
+		"status":  "Successed",
+		"count":   "5",
+		"enabled": "true",
+		"list":    `["amd64", "arm64"]`,
+		"csv":     "a, b,c",
+		"quoted":  `say "hi"`,
+		"version": "v1.28.3",
+	}
+	tests := []struct {
+		exp  string
+		want bool
+	}{
+		// precedence of and, or, not and parentheses
+		{"true || false && false", true},
+		{"(true || false) && false", false},
+		{"false and true or true", true},
+		{"false and (true or true)", false},
+		{"not false and false", false},
+		{"not (false and false)", true},
+		{"!true || true", true},
+		{"!(true || true)", false},
+		{"not not true", true},
+		// comparisons of numbers and strings
+		{"${count} > 3", true},
+		{"${count} >= 5", true},
+		{"${count} <= 4.5", false},
+		{"${count} =< 5", true},
+		{"'10' >= 9", true},
+		{"10 == 10.0", true},
+		{"${count} == '5'", true},
+		{"abc == abc", true},
+		{"abc != ABC", true},
+		// in, not in, contains and matches
+		{"amd64 in ${list}", true},
+		{"s390x not in ${list}", true},
+		{"b in ${csv}", true},
+		{"${status} in [Successed, Skipped]", true},
+		{"5 in [1, 5]", true},
+		{"${output} contains ead", true},
+		{"${output} not contains Failed", true},
+		{"${list} contains arm64", true},
+		{"${version} matches '^v1\\.2[0-9]'", true},
+		{"${version} not matches '^v2'", true},
+		{"${version} matches ^v1", true},
+		// keywords are case insensitive
+		{"${status} == Successed AND NOT false", true},
+		// variables are trimmed and typed
+		{"${output} == Ready", true},
+		{"${enabled}", true},
+		{"${enabled} == true", true},
+		// quoting and escapes
+		{`${quoted} == 'say "hi"'`, true},
+		{`${quoted} == "say \"hi\""`, true},
+		{`'a\'b' == "a'b"`, true},
+		{`"a b" == 'a b'`, true},
+		{`"${status}-prod" == Successed-prod`, true},
+		{`"a||b" contains "||"`, true},
+		// functions
+		{"startwith(${version}, v1)", true},
+		{"endwith(${version}, '.3')", true},
+		{"len(${version}) == 7", true},
+		{"len([a, b]) == 2", true},
+		// unknown identifiers are strings, unresolved variables are kept as they are
+		{"unknown == unknown", true},
+		{"${missing} == '${missing}'", true},
+		{"${missing} != ''", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.exp, func(t *testing.T) {
+			got, err := EvalBool(tt.exp, false, MapResolver(vars))
+			if err != nil {
+				t.Fatalf("EvalBool(%q) error = %v", tt.exp, err)
+			}
+			if got != tt.want {
+				t.Errorf("EvalBool(%q) = %v, want %v", tt.exp, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvalBoolEmpty(t *testing.T) {
+	for _, def := range []bool{true, false} {
+		got, err := EvalBool("  ", def, nil)
+		if err != nil || got != def {
+			t.Errorf("EvalBool of empty with default %v = %v, %v", def, got, err)
+		}
+	}
+}
+
+func TestEvalBoolErrors(t *testing.T) {
+	vars := map[string]string{"name": "abc", "count": "5", "re": "["}
+	tests := []struct {
+		exp     string
+		wantErr string
+	}{
+		{"${name} > 3", "is not a number"},
+		{"${count} >= abc", "is not a number"},
+		{"unknown", "is not a boolean"},
+		{"${name}", "is not a boolean"},
+		{"${name} and true", "is not a boolean"},
+		{"${name} matches ${re}", "invalid regexp"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.exp, func(t *testing.T) {
+			_, err := EvalBool(tt.exp, false, MapResolver(vars))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("EvalBool(%q) error = %v, want %q", tt.exp, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		exp     string
+		wantErr string
+	}{
+		{"", ""},
+		{"${output} == ok && (${status} in [Successed, Skipped])", ""},
+		{"a = b", "unexpected"},
+		{"a == ", "unexpected end"},
+		{"(a == b", "unexpected end"},
+		{"a == b)", "unexpected \")\""},
+		{"'abc", "unterminated string"},
+		{"${output == a", "unterminated variable"},
+		{"a & b", "unexpected"},
+		{"[a, b", "unexpected end"},
+		{"a matches '['", "invalid regexp"},
+		{"unknown(a)", "unknown function"},
+		{"len(a, b)", "wants 1 arguments"},
+		{"a == b c", "unexpected \"c\""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.exp, func(t *testing.T) {
+			err := Validate(tt.exp)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate(%q) error = %v", tt.exp, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate(%q) error = %v, want %q", tt.exp, err, tt.wantErr)
+			}
+		})
+	}
+}
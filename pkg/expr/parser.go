@@ -0,0 +1,298 @@
+package expr
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenOp
+	tokenString
+	tokenVar
+	tokenWord
+)
+
+type token struct {
+	kind  tokenKind
+	text  string
+	value string
+	pos   int
+}
+
+// wordDelimiters end a word, eg: active==ready is a comparison of two words
+const wordDelimiters = "()[],=!<>&|\"'"
+
+var (
+	identRegexp  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	numberRegexp = regexp.MustCompile(`^[+-]?(\d+\.?\d*|\.\d+)([eE][+-]?\d+)?$`)
+	varRegexp    = regexp.MustCompile(`\${[^}]*}`)
+)
+
+func lex(exp string) (tokens []token, err error) {
+	for i := 0; i < len(exp); {
+		c := exp[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '"' || c == '\'':
+			var sb strings.Builder
+			j := i + 1
+			for ; j < len(exp) && exp[j] != c; j++ {
+				if exp[j] == '\\' && j+1 < len(exp) {
+					j++
+				}
+				sb.WriteByte(exp[j])
+			}
+			if j >= len(exp) {
+				return nil, fmt.Errorf("unterminated string at %d", i)
+			}
+			tokens = append(tokens, token{kind: tokenString, text: exp[i : j+1], value: sb.String(), pos: i})
+			i = j + 1
+		case strings.HasPrefix(exp[i:], "==") || strings.HasPrefix(exp[i:], "!=") || strings.HasPrefix(exp[i:], ">=") ||
+			strings.HasPrefix(exp[i:], "<=") || strings.HasPrefix(exp[i:], "=<") || strings.HasPrefix(exp[i:], "&&") || strings.HasPrefix(exp[i:], "||"):
+			tokens = append(tokens, token{kind: tokenOp, text: exp[i : i+2], pos: i})
+			i += 2
+		case strings.IndexByte("()[],<>!", c) >= 0:
+			tokens = append(tokens, token{kind: tokenOp, text: exp[i : i+1], pos: i})
+			i++
+		case strings.IndexByte("=&|", c) >= 0:
+			return nil, fmt.Errorf("unexpected %q at %d", c, i)
+		default:
+			j := i
+			for j < len(exp) && !strings.ContainsAny(exp[j:j+1], " \t\n\r"+wordDelimiters) {
+				if strings.HasPrefix(exp[j:], "${") {
+					end := strings.IndexByte(exp[j:], '}')
+					if end < 0 {
+						return nil, fmt.Errorf("unterminated variable at %d", j)
+					}
+					j += end + 1
+					continue
+				}
+				j++
+			}
+			word := exp[i:j]
+			if loc := varRegexp.FindStringIndex(word); loc != nil && loc[0] == 0 && loc[1] == len(word) {
+				tokens = append(tokens, token{kind: tokenVar, text: word, value: strings.TrimSpace(word[2 : len(word)-1]), pos: i})
+			} else {
+				tokens = append(tokens, token{kind: tokenWord, text: word, value: word, pos: i})
+			}
+			i = j
+		}
+	}
+	tokens = append(tokens, token{kind: tokenEOF, pos: len(exp)})
+	return
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+// Parse parses exp into an expression that can be evaluated many times
+func Parse(exp string) (*Expression, error) {
+	tokens, err := lex(exp)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %v", exp, err)
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.parseOr()
+	if err == nil && p.peek().kind != tokenEOF {
+		err = p.unexpected()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %v", exp, err)
+	}
+	return &Expression{raw: exp, root: root}, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+// isKeyword returns true if the token is the operator or the keyword, keywords are case insensitive
+func (p *parser) isKeyword(t token, keywords ...string) bool {
+	for _, k := range keywords {
+		if (t.kind == tokenOp && t.text == k) || (t.kind == tokenWord && strings.EqualFold(t.text, k)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *parser) unexpected() error {
+	t := p.peek()
+	if t.kind == tokenEOF {
+		return fmt.Errorf("unexpected end")
+	}
+	return fmt.Errorf("unexpected %q at %d", t.text, t.pos)
+}
+
+func (p *parser) parseOr() (node, error) {
+	x, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword(p.peek(), "||", "or") {
+		p.next()
+		y, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		x = &logicNode{or: true, x: x, y: y}
+	}
+	return x, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	x, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword(p.peek(), "&&", "and") {
+		p.next()
+		y, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		x = &logicNode{x: x, y: y}
+	}
+	return x, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.isKeyword(p.peek(), "!", "not") {
+		p.next()
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{x: x}, nil
+	}
+	return p.parseComparison()
+}
+
+var comparisonOps = []string{"==", "!=", ">", ">=", "<", "<=", "=<", "contains", "matches", "in"}
+
+func (p *parser) parseComparison() (node, error) {
+	x, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	negated := false
+	// not in, not contains, not matches
+	if p.isKeyword(p.peek(), "not") && p.isKeyword(p.tokens[p.pos+1], "contains", "matches", "in") {
+		p.next()
+		negated = true
+	}
+	if !p.isKeyword(p.peek(), comparisonOps...) {
+		return x, nil
+	}
+	op := strings.ToLower(p.next().text)
+	if op == "=<" {
+		op = "<="
+	}
+	y, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	if op == "matches" {
+		if lit, ok := y.(*literalNode); ok {
+			if _, err := regexp.Compile(toString(lit.value)); err != nil {
+				return nil, fmt.Errorf("invalid regexp %q: %v", toString(lit.value), err)
+			}
+		}
+	}
+	var n node = &compareNode{op: op, x: x, y: y}
+	if negated {
+		n = &notNode{x: n}
+	}
+	return n, nil
+}
+
+func (p *parser) parseOperand() (node, error) {
+	t := p.peek()
+	switch t.kind {
+	case tokenString:
+		p.next()
+		return newTextNode(t.value), nil
+	case tokenVar:
+		p.next()
+		return &varNode{name: t.value}, nil
+	case tokenOp:
+		switch t.text {
+		case "(":
+			p.next()
+			x, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if p.peek().text != ")" || p.peek().kind != tokenOp {
+				return nil, p.unexpected()
+			}
+			p.next()
+			return x, nil
+		case "[":
+			p.next()
+			items, err := p.parseList("]")
+			if err != nil {
+				return nil, err
+			}
+			return &listNode{items: items}, nil
+		}
+	case tokenWord:
+		p.next()
+		if strings.EqualFold(t.text, "true") || strings.EqualFold(t.text, "false") {
+			return &literalNode{value: strings.EqualFold(t.text, "true")}, nil
+		}
+		if numberRegexp.MatchString(t.text) {
+			return &literalNode{value: mustParseNumber(t.text)}, nil
+		}
+		next := p.peek()
+		if identRegexp.MatchString(t.text) && next.kind == tokenOp && next.text == "(" {
+			p.next()
+			args, err := p.parseList(")")
+			if err != nil {
+				return nil, err
+			}
+			return newCallNode(t, args)
+		}
+		// a word is a string, eg: Successed in ${status} == Successed
+		return newTextNode(t.value), nil
+	}
+	return nil, p.unexpected()
+}
+
+// parseList parses the operands separated by comma until end
+func (p *parser) parseList(end string) (items []node, err error) {
+	if t := p.peek(); t.kind == tokenOp && t.text == end {
+		p.next()
+		return
+	}
+	for {
+		item, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+		t := p.peek()
+		if t.kind != tokenOp || (t.text != end && t.text != ",") {
+			return nil, p.unexpected()
+		}
+		p.next()
+		if t.text == end {
+			return items, nil
+		}
+	}
+}
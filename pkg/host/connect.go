@@ -4,8 +4,10 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"crypto/rand"
 	"encoding/base64"
 	"fmt"
+	"math"
 	"net"
 	"os"
 	"os/exec"
@@ -27,6 +29,9 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+// hostKillDelaySeconds is how long after the deadline of ctx a command is killed on the host
+const hostKillDelaySeconds = 5
+
 type HostConnectionCache struct {
 	cache map[string]*HostConnection
 	Mutex *sync.RWMutex
@@ -79,18 +84,29 @@ func NewHostConnBase64(h *opsv1.Host) (hc *HostConnection, err error) {
 }
 
 func (c *HostConnection) Shell(ctx context.Context, sudo bool, content string) (stdout string, err error) {
+	result, err := c.ShellWithResult(ctx, sudo, content, nil)
+	return c.combinedOutput(result, err), err
+}
+
+// ShellWithResult runs the content as a script, the stdout, stderr and exit code of it are returned separately
+// the secrets are masked in the output printed while running
+func (c *HostConnection) ShellWithResult(ctx context.Context, sudo bool, content string, secrets []string) (result ExecResult, err error) {
 	reg := regexp.MustCompile(`\${[^\}]*}`)
 	funcStrList := reg.FindAllString(content, -1)
 	for _, callFunc := range funcStrList {
 		rawCallFunc := callFunc
 		callFunc = callFunc[2 : len(callFunc)-1]
-		stdout, err = c.shellFuncMap(ctx, sudo, callFunc)
+		stdout, err := c.shellFuncMap(ctx, sudo, callFunc)
 		if err != nil {
-			return stdout, err
+			return ExecResult{Stdout: stdout, ExitCode: -1}, err
 		}
 		content = strings.ReplaceAll(content, rawCallFunc, stdout)
 	}
-	return c.execScript(ctx, sudo, content)
+	lines := strings.Split(content, "\n")
+	if len(lines) > 1 && strings.Contains(lines[0], "python") {
+		return c.ExecWithResult(ctx, sudo, "python3", "-c", content, secrets)
+	}
+	return c.ExecWithResult(ctx, sudo, "sh", "-c", content, secrets)
 }
 
 func (c *HostConnection) shellFuncMap(ctx context.Context, sudo bool, funcFull string) (stdout string, err error) {
@@ -396,46 +412,126 @@ func (c *HostConnection) execScript(ctx context.Context, sudo bool, cmd string)
 	return c.execSh(ctx, sudo, cmd)
 }
 
+// ExecResult is the result of a command run on a host
+type ExecResult struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int // -1 if the command did not exit, eg: it is cancelled or the connection is lost
+}
+
+// newStderrMarker returns a random marker for a run, it prefixes the stderr lines of a command run over ssh as the pty merges both streams
+// It is random, so the output of the command can not fake it
+func newStderrMarker() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return fmt.Sprintf("ops-stderr-%x:", b)
+}
+
+// withStderrMarker prefixes each line of the stderr of cmd with marker as it is written, so stderr is streamed with stdout
+// the exit code of cmd is kept, it needs bash for PIPESTATUS
+func withStderrMarker(cmd, marker string) string {
+	return fmt.Sprintf(`{ { %s; } 2>&1 1>&3 3>&- | while IFS= read -r ops_line || [ -n "$ops_line" ]; do printf '%%s%%s\n' '%s' "$ops_line"; done; } 3>&1; exit ${PIPESTATUS[0]}`, cmd, marker)
+}
+
+// splitStderrLine writes a line of the output to stdout or stderr by marker and returns the text to print
+// a line of stdout without the line break may be followed by a line of stderr
+func splitStderrLine(stdout, stderr *strings.Builder, line, marker string) string {
+	out, errLine, isStderr := strings.Cut(line, marker)
+	if !isStderr {
+		stdout.WriteString(line)
+		return line
+	}
+	stdout.WriteString(out)
+	stderr.WriteString(errLine)
+	if out == "" {
+		return errLine
+	}
+	return out + "\n" + errLine
+}
+
 func (c *HostConnection) ExecWithExecutor(ctx context.Context, sudo bool, executor, param, rawCmd string) (stdout string, err error) {
+	result, err := c.ExecWithResult(ctx, sudo, executor, param, rawCmd, nil)
+	return c.combinedOutput(result, err), err
+}
+
+// combinedOutput returns the output of a command like before stderr is captured separately
+// the stderr is returned on localhost if the command failed, and it follows the stdout over ssh
+func (c *HostConnection) combinedOutput(result ExecResult, err error) string {
+	if c.Host.Spec.Address == opsconstants.LocalHostIP {
+		if err != nil {
+			return result.Stderr
+		}
+		return result.Stdout
+	}
+	if result.Stderr == "" {
+		return result.Stdout
+	}
+	if result.Stdout == "" {
+		return result.Stderr
+	}
+	return result.Stdout + "\n" + result.Stderr
+}
+
+// ExecWithResult runs rawCmd with the executor, the stdout, stderr and exit code of it are returned separately
+// the secrets are masked in the output printed while running
+func (c *HostConnection) ExecWithResult(ctx context.Context, sudo bool, executor, param, rawCmd string, secrets []string) (result ExecResult, err error) {
+	result.ExitCode = -1
 	cmd := opsutils.BuildBase64CmdWithExecutor(sudo, rawCmd, executor)
+	// the host kills the command a little after the deadline, in case the ssh signal is ignored
+	if deadline, ok := ctx.Deadline(); ok {
+		cmd = opsutils.BuildBase64CmdWithTimeout(sudo, rawCmd, executor, int(math.Ceil(time.Until(deadline).Seconds()))+hostKillDelaySeconds)
+	}
 	// run in localhost
 	if c.Host.Spec.Address == opsconstants.LocalHostIP {
-		runner := exec.Command("bash", "-c", cmd)
+		runner := exec.CommandContext(ctx, "bash", "-c", cmd)
 		if sudo {
-			runner = exec.Command("sudo", "bash", "-c", cmd)
+			runner = exec.CommandContext(ctx, "sudo", "bash", "-c", cmd)
 		}
 		var out, errout bytes.Buffer
 		runner.Stdout = &out
 		runner.Stderr = &errout
 		err = runner.Run()
-		if err != nil {
-			stdout = errout.String()
-			return
+		result.Stdout = out.String()
+		result.Stderr = errout.String()
+		if runner.ProcessState != nil {
+			result.ExitCode = runner.ProcessState.ExitCode()
 		}
-		stdout = out.String()
 		return
 	}
 	sess, err := c.session()
 	if err != nil {
-		return "", errors.Wrap(err, "failed to get SSH session")
+		return result, errors.Wrap(err, "failed to get SSH session")
 	}
 	defer sess.Close()
 
 	in, _ := sess.StdinPipe()
 	out, _ := sess.StdoutPipe()
-	err = sess.Start(cmd)
+	// the stderr is streamed with the stdout by the pty, its lines are told apart by the marker of this run
+	marker := newStderrMarker()
+	err = sess.Start(withStderrMarker(cmd, marker))
 	if err != nil {
-		return "", err
+		return result, err
 	}
+	// close the session once cancelled, the remote command is stopped and the reading below returns
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			sess.Signal(ssh.SIGKILL)
+			sess.Close()
+		case <-done:
+		}
+	}()
 	isRebootCommand := strings.Contains(rawCmd, "reboot") || strings.Contains(rawCmd, "halt") || strings.Contains(rawCmd, "shutdown") || strings.Contains(rawCmd, "ipmitool")
 
 	var (
-		output []byte
-		line   = ""
-		r      = bufio.NewReader(out)
+		stdout, stderr strings.Builder
+		cache          []string
+		line           = ""
+		r              = bufio.NewReader(out)
 	)
 	printLogStream := false
-	hasPrintCache := false
 	time.AfterFunc(time.Second*3, func() {
 		printLogStream = true
 	})
@@ -448,14 +544,15 @@ func (c *HostConnection) ExecWithExecutor(ctx context.Context, sudo bool, execut
 			if err != nil {
 				goto END
 			}
-			output = append(output, b)
-			if printLogStream && !hasPrintCache {
-				fmt.Print(string(output))
-				hasPrintCache = !hasPrintCache
-			}
+			// the output is printed by lines, so a secret is masked before any part of it is printed
 			if b == byte('\n') {
+				text := opsutils.MaskSecrets(splitStderrLine(&stdout, &stderr, line+"\n", marker), secrets)
+				cache = append(cache, strings.TrimRight(text, "\r\n"))
 				if printLogStream {
-					fmt.Print(line)
+					for _, cached := range cache {
+						fmt.Println(cached)
+					}
+					cache = nil
 				}
 				line = ""
 				continue
@@ -473,10 +570,22 @@ func (c *HostConnection) ExecWithExecutor(ctx context.Context, sudo bool, execut
 	}
 END:
 	if isRebootCommand {
-		return "Reboot command triggered successfully", nil
+		return ExecResult{Stdout: "Reboot command triggered successfully"}, nil
 	}
 	err = sess.Wait()
-	return strings.TrimRight(string(output), "\r\n"), err
+	if err == nil {
+		result.ExitCode = 0
+	} else if exitErr, ok := err.(*ssh.ExitError); ok {
+		result.ExitCode = exitErr.ExitStatus()
+	}
+	if ctx.Err() != nil {
+		err = ctx.Err()
+		result.ExitCode = -1
+	}
+	splitStderrLine(&stdout, &stderr, line, marker)
+	result.Stdout = strings.TrimRight(stdout.String(), "\r\n")
+	result.Stderr = strings.Trim(stderr.String(), "\r\n")
+	return
 }
 
 func (c *HostConnection) mv(ctx context.Context, sudo bool, src, dst string) (stdout string, err error) {
@@ -0,0 +1,61 @@
+package host
+
+import (
+	"bufio"
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestWithStderrMarker(t *testing.T) {
+	marker := newStderrMarker()
+	if marker == newStderrMarker() {
+		t.Fatalf("newStderrMarker() is not random")
+	}
+	cmd := `echo out1; echo err1 >&2; echo out2; printf 'progress'; echo err2 >&2; echo; printf 'err3' >&2; exit 3`
+	runner := exec.Command("bash", "-c", withStderrMarker(cmd, marker))
+	output, err := runner.Output()
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 3 {
+		t.Fatalf("exit code = %v, want 3", err)
+	}
+	var stdout, stderr strings.Builder
+	r := bufio.NewReader(strings.NewReader(string(output)))
+	for {
+		line, err := r.ReadString('\n')
+		splitStderrLine(&stdout, &stderr, line, marker)
+		if err != nil {
+			break
+		}
+	}
+	// the order of the lines of stdout and stderr depends on the scheduling, the lines of each stream are in order
+	if got, want := stdout.String(), "out1\nout2\nprogress\n"; got != want {
+		t.Errorf("stdout = %q, want %q", got, want)
+	}
+	if got, want := stderr.String(), "err1\nerr2\nerr3\n"; got != want {
+		t.Errorf("stderr = %q, want %q", got, want)
+	}
+}
+
+func TestSplitStderrLine(t *testing.T) {
+	marker := "ops-stderr-1:"
+	tests := []struct {
+		line       string
+		wantStdout string
+		wantStderr string
+		wantText   string
+	}{
+		{"out\n", "out\n", "", "out\n"},
+		{marker + "err\n", "", "err\n", "err\n"},
+		{"progress" + marker + "err\n", "progress", "err\n", "progress\nerr\n"},
+		{"ops-stderr-2:err\n", "ops-stderr-2:err\n", "", "ops-stderr-2:err\n"},
+	}
+	for _, tt := range tests {
+		var stdout, stderr strings.Builder
+		text := splitStderrLine(&stdout, &stderr, tt.line, marker)
+		if stdout.String() != tt.wantStdout || stderr.String() != tt.wantStderr || text != tt.wantText {
+			t.Errorf("splitStderrLine(%q) = %q, %q, %q, want %q, %q, %q", tt.line, stdout.String(), stderr.String(), text, tt.wantStdout, tt.wantStderr, tt.wantText)
+		}
+	}
+}
@@ -12,6 +12,7 @@ import (
 
 	opsv1 "github.com/shaowenchen/ops/api/v1"
 	opsconstants "github.com/shaowenchen/ops/pkg/constants"
+	opsexpr "github.com/shaowenchen/ops/pkg/expr"
 	opslog "github.com/shaowenchen/ops/pkg/log"
 	opsopt "github.com/shaowenchen/ops/pkg/option"
 	opsutils "github.com/shaowenchen/ops/pkg/utils"
@@ -352,17 +353,21 @@ func (kc *KubeConnection) FileNodes(logger *opslog.Logger, runtimeImage string,
 }
 
 // RunTaskStepsOnNode creates a pod with multiple containers for task steps
-func (kc *KubeConnection) RunTaskStepsOnNode(node *corev1.Node, namespacedName types.NamespacedName, stepConfigs []StepContainerConfig, defaultImage string, mounts []opsopt.MountConfig) (pod *corev1.Pod, err error) {
-	return RunTaskStepsOnNode(kc.Client, node, namespacedName, stepConfigs, defaultImage, mounts)
+func (kc *KubeConnection) RunTaskStepsOnNode(node *corev1.Node, namespacedName types.NamespacedName, stepConfigs []StepContainerConfig, defaultImage string, mounts []opsopt.MountConfig, secretEnv map[string]string) (pod *corev1.Pod, err error) {
+	return RunTaskStepsOnNode(kc.Client, node, namespacedName, stepConfigs, defaultImage, mounts, secretEnv)
 }
 
 // WaitForTaskStepsPod waits for the pod to complete and collects logs from each container
-func (kc *KubeConnection) WaitForTaskStepsPod(logger *opslog.Logger, pod *corev1.Pod, stepConfigs []StepContainerConfig, tr *opsv1.TaskRun, nodeName string, allVars map[string]string, stepOutputs map[string]string) error {
-	ctx := context.TODO()
+// The allowfailure of a failed step is evaluated with resolve, eg: ${output} of the step
+// The pod is deleted once ctx is cancelled
+func (kc *KubeConnection) WaitForTaskStepsPod(ctx context.Context, logger *opslog.Logger, pod *corev1.Pod, stepConfigs []StepContainerConfig, tr *opsv1.TaskRun, nodeName string, allVars map[string]string, stepOutputs map[string]string, resolve opsexpr.Resolver) error {
 	var err error
 
 	// Wait for pod to be ready
 	for range time.Tick(time.Second * 2) {
+		if ctx.Err() != nil {
+			return kc.deleteTaskStepsPod(logger, pod, ctx.Err())
+		}
 		updatedPod, err := kc.Client.CoreV1().Pods(pod.Namespace).Get(ctx, pod.Name, metav1.GetOptions{})
 		if err != nil {
 			logger.Error.Println(err)
@@ -383,10 +388,10 @@ func (kc *KubeConnection) WaitForTaskStepsPod(logger *opslog.Logger, pod *corev1
 			containerName = fmt.Sprintf("step-%d", i)
 		}
 
-		logs, err := GetContainerLog(ctx, kc.Client, pod.Namespace, pod.Name, containerName)
-		if err != nil {
-			logger.Error.Printf("Failed to get logs for container %s: %v", containerName, err)
-			logs = err.Error()
+		logs, logErr := GetContainerLog(ctx, kc.Client, pod.Namespace, pod.Name, containerName)
+		if logErr != nil {
+			logger.Error.Printf("Failed to get logs for container %s: %v", containerName, logErr)
+			logs = logErr.Error()
 		}
 
 		// Determine status based on container state
@@ -394,7 +399,7 @@ func (kc *KubeConnection) WaitForTaskStepsPod(logger *opslog.Logger, pod *corev1
 		containerStatus := getContainerStatus(pod, containerName, true)
 		if containerStatus != nil && containerStatus.State.Terminated != nil {
 			if containerStatus.State.Terminated.ExitCode != 0 {
-				status = opsconstants.StatusFailed
+				status = getFailedStepStatus(pod, containerStatus, stepConfig)
 			}
 		} else if containerStatus != nil && containerStatus.State.Waiting != nil {
 			status = opsconstants.StatusRunning
@@ -405,7 +410,9 @@ func (kc *KubeConnection) WaitForTaskStepsPod(logger *opslog.Logger, pod *corev1
 			stepContent = fmt.Sprintf("file: %s -> %s", stepConfig.LocalFile, stepConfig.RemoteFile)
 		}
 
-		tr.Status.AddOutputStep(nodeName, stepConfig.StepName, stepContent, logs, status)
+		step := newTaskRunStep(stepConfig, stepContent, logs, status, containerStatus)
+		logs = step.StepOutput
+		tr.Status.AddStep(nodeName, step)
 
 		// Store step output for path references
 		stepOutputs[stepConfig.StepName] = strings.ReplaceAll(logs, "\"", "")
@@ -414,22 +421,33 @@ func (kc *KubeConnection) WaitForTaskStepsPod(logger *opslog.Logger, pod *corev1
 		allVars["status"] = status
 
 		// Check if step failed and should stop
-		if status == opsconstants.StatusFailed {
+		if status == opsconstants.StatusFailed || status == opsconstants.StatusTimeout {
 			// Check AllowFailure
-			allowFailure, err := opsutils.LogicExpression(stepConfig.AllowFailure, false)
-			if err != nil {
-				logger.Error.Printf("Failed to evaluate AllowFailure for step %s: %v", stepConfig.StepName, err)
+			allowFailure, logicErr := opsexpr.EvalBool(stepConfig.AllowFailure, false, resolve)
+			if logicErr != nil {
+				logger.Error.Printf("Failed to evaluate AllowFailure for step %s: %v", stepConfig.StepName, logicErr)
 			}
 			if !allowFailure {
 				// Step failed and failure is not allowed, stop execution
 				logger.Error.Printf("Step %s failed and AllowFailure is false, stopping execution", stepConfig.StepName)
+				err = fmt.Errorf("step %s failed", stepConfig.StepName)
 				break
 			}
 		}
 	}
+	if err != nil {
+		// the main container does not run after an init container failed
+		if !opsconstants.GetEnvDebug() {
+			kc.Client.CoreV1().Pods(pod.Namespace).Delete(ctx, pod.Name, metav1.DeleteOptions{})
+		}
+		return err
+	}
 
 	// Wait for main container (last step) to complete
 	for range time.Tick(time.Second * 2) {
+		if ctx.Err() != nil {
+			return kc.deleteTaskStepsPod(logger, pod, ctx.Err())
+		}
 		updatedPod, err := kc.Client.CoreV1().Pods(pod.Namespace).Get(ctx, pod.Name, metav1.GetOptions{})
 		if err != nil {
 			logger.Error.Println(err)
@@ -450,10 +468,10 @@ func (kc *KubeConnection) WaitForTaskStepsPod(logger *opslog.Logger, pod *corev1
 			containerName = fmt.Sprintf("step-%d", len(stepConfigs)-1)
 		}
 
-		logs, err := GetContainerLog(ctx, kc.Client, pod.Namespace, pod.Name, containerName)
-		if err != nil {
-			logger.Error.Printf("Failed to get logs for container %s: %v", containerName, err)
-			logs = err.Error()
+		logs, logErr := GetContainerLog(ctx, kc.Client, pod.Namespace, pod.Name, containerName)
+		if logErr != nil {
+			logger.Error.Printf("Failed to get logs for container %s: %v", containerName, logErr)
+			logs = logErr.Error()
 		}
 
 		// Determine status based on pod state
@@ -465,7 +483,7 @@ func (kc *KubeConnection) WaitForTaskStepsPod(logger *opslog.Logger, pod *corev1
 		containerStatus := getContainerStatus(pod, containerName, false)
 		if containerStatus != nil && containerStatus.State.Terminated != nil {
 			if containerStatus.State.Terminated.ExitCode != 0 {
-				status = opsconstants.StatusFailed
+				status = getFailedStepStatus(pod, containerStatus, lastStep)
 			}
 		}
 
@@ -474,7 +492,9 @@ func (kc *KubeConnection) WaitForTaskStepsPod(logger *opslog.Logger, pod *corev1
 			stepContent = fmt.Sprintf("file: %s -> %s", lastStep.LocalFile, lastStep.RemoteFile)
 		}
 
-		tr.Status.AddOutputStep(nodeName, lastStep.StepName, stepContent, logs, status)
+		step := newTaskRunStep(lastStep, stepContent, logs, status, containerStatus)
+		logs = step.StepOutput
+		tr.Status.AddStep(nodeName, step)
 
 		// Store step output for path references
 		stepOutputs[lastStep.StepName] = strings.ReplaceAll(logs, "\"", "")
@@ -483,11 +503,11 @@ func (kc *KubeConnection) WaitForTaskStepsPod(logger *opslog.Logger, pod *corev1
 		allVars["status"] = status
 
 		// Check if last step failed and should return error
-		if status == opsconstants.StatusFailed {
+		if status == opsconstants.StatusFailed || status == opsconstants.StatusTimeout {
 			// Check AllowFailure
-			allowFailure, err := opsutils.LogicExpression(lastStep.AllowFailure, false)
-			if err != nil {
-				logger.Error.Printf("Failed to evaluate AllowFailure for step %s: %v", lastStep.StepName, err)
+			allowFailure, logicErr := opsexpr.EvalBool(lastStep.AllowFailure, false, resolve)
+			if logicErr != nil {
+				logger.Error.Printf("Failed to evaluate AllowFailure for step %s: %v", lastStep.StepName, logicErr)
 			}
 			if !allowFailure {
 				// Step failed and failure is not allowed, return error
@@ -505,6 +525,16 @@ func (kc *KubeConnection) WaitForTaskStepsPod(logger *opslog.Logger, pod *corev1
 	return err
 }
 
+// deleteTaskStepsPod deletes the pod of task steps that is stopped before it completes
+func (kc *KubeConnection) deleteTaskStepsPod(logger *opslog.Logger, pod *corev1.Pod, reason error) error {
+	logger.Info.Printf("delete pod %s/%s, %v", pod.Namespace, pod.Name, reason)
+	err := kc.Client.CoreV1().Pods(pod.Namespace).Delete(context.TODO(), pod.Name, metav1.DeleteOptions{})
+	if err != nil && !k8serrors.IsNotFound(err) {
+		logger.Error.Println(err)
+	}
+	return reason
+}
+
 // GetContainerLog gets logs from a specific container in a pod
 func GetContainerLog(ctx context.Context, client *kubernetes.Clientset, namespace, podName, containerName string) (logs string, err error) {
 	req := client.CoreV1().Pods(namespace).GetLogs(podName, &corev1.PodLogOptions{
@@ -525,6 +555,39 @@ func GetContainerLog(ctx context.Context, client *kubernetes.Clientset, namespac
 	return
 }
 
+// newTaskRunStep records a step with the exit code, stderr and times from the terminated state of its container
+// The logs have both the stdout and the stderr in the order they are written, the termination message keeps the tail of the stderr
+func newTaskRunStep(stepConfig StepContainerConfig, stepContent, logs, status string, containerStatus *corev1.ContainerStatus) *opsv1.TaskRunStep {
+	step := &opsv1.TaskRunStep{
+		StepName:   stepConfig.StepName,
+		StepOutput: logs,
+		StepStatus: status,
+		Attempt:    stepConfig.Attempt,
+		Command:    stepContent,
+	}
+	if containerStatus == nil || containerStatus.State.Terminated == nil {
+		return step
+	}
+	terminated := containerStatus.State.Terminated
+	// the tail may start in the middle of a character
+	step.Stderr = strings.TrimRight(strings.ToValidUTF8(terminated.Message, ""), "\n")
+	step.SetExitCode(int(terminated.ExitCode))
+	step.SetTimes(terminated.StartedAt.Time, terminated.FinishedAt.Time)
+	return step
+}
+
+// getFailedStepStatus returns Timeout if a failed step container was killed after its timeout or by the deadline of the pod, else Failed
+func getFailedStepStatus(pod *corev1.Pod, containerStatus *corev1.ContainerStatus, stepConfig StepContainerConfig) string {
+	if pod.Status.Reason == "DeadlineExceeded" {
+		return opsconstants.StatusTimeout
+	}
+	terminated := containerStatus.State.Terminated
+	if stepConfig.TimeoutSeconds > 0 && terminated.FinishedAt.Sub(terminated.StartedAt.Time) >= time.Duration(stepConfig.TimeoutSeconds)*time.Second {
+		return opsconstants.StatusTimeout
+	}
+	return opsconstants.StatusFailed
+}
+
 // getContainerStatus gets the status of a container (init or regular)
 func getContainerStatus(pod *corev1.Pod, containerName string, isInit bool) *corev1.ContainerStatus {
 	if isInit {
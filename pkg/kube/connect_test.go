@@ -0,0 +1,84 @@
+package kube
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestNewTaskRunStepKeepsStderrTail(t *testing.T) {
+	tests := []struct {
+		name       string
+		logs       string
+		message    string
+		wantStderr string
+	}{
+		{"no stderr", "out\n", "", ""},
+		{"stderr", "err\nout\n", "err\n", "err"},
+		{"tail cut in a character", "out\n", "\xb8\x96界\n", "界"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			containerStatus := &corev1.ContainerStatus{State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 1, Message: tt.message}}}
+			step := newTaskRunStep(StepContainerConfig{StepName: "step"}, "cmd", tt.logs, "Failed", containerStatus)
+			if step.StepOutput != tt.logs || step.Stderr != tt.wantStderr {
+				t.Errorf("newTaskRunStep() output = %q, stderr = %q, want %q, %q", step.StepOutput, step.Stderr, tt.logs, tt.wantStderr)
+			}
+			if step.ExitCode == nil || *step.ExitCode != 1 {
+				t.Errorf("newTaskRunStep() exit code = %v, want 1", step.ExitCode)
+			}
+		})
+	}
+}
+
+func TestWithStderrTail(t *testing.T) {
+	var largeStderr strings.Builder
+	for i := 0; largeStderr.Len() < 5000; i++ {
+		fmt.Fprintf(&largeStderr, "error %d\n", i)
+	}
+	large := largeStderr.String()
+	tests := []struct {
+		name        string
+		stderr      string
+		wantMessage string
+	}{
+		{"no stderr", "", ""},
+		{"small stderr", "err1\nerr2\n", "err1\nerr2\n"},
+		{"large stderr", large, fmt.Sprintf("... %d bytes of stderr truncated, the full stderr is in the output ...\n", len(large)-stderrTailBytes) + large[len(large)-stderrTailBytes:]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			stderrFile := filepath.Join(dir, "stderr")
+			if err := os.WriteFile(stderrFile, []byte(tt.stderr), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			path := filepath.Join(dir, "termination-log")
+			runner := exec.Command("bash", "-c", withStderrTail(fmt.Sprintf("echo out; cat %s >&2; exit 3", stderrFile), path))
+			var stdout, stderr strings.Builder
+			runner.Stdout, runner.Stderr = &stdout, &stderr
+			err := runner.Run()
+			var exitErr *exec.ExitError
+			if !errors.As(err, &exitErr) || exitErr.ExitCode() != 3 {
+				t.Fatalf("exit code = %v, want 3", err)
+			}
+			// the stderr is streamed as it is, only the termination message is cut
+			if stdout.String() != "out\n" || stderr.String() != tt.stderr {
+				t.Errorf("stdout = %q, stderr = %q, want out and the full stderr", stdout.String(), stderr.String())
+			}
+			message, err := os.ReadFile(path)
+			if err != nil || string(message) != tt.wantMessage {
+				t.Errorf("termination message = %q, %v, want %q", message, err, tt.wantMessage)
+			}
+			if len(message) > 4096 {
+				t.Errorf("termination message is %d bytes, more than the 4096 bytes kept by the kubelet", len(message))
+			}
+		})
+	}
+}
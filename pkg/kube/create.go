@@ -54,3 +54,43 @@ func CreateTask(ctx context.Context, logger *opslog.Logger, restConfig *rest.Con
 	}
 	return
 }
+
+// RerunPipelineRun creates a PipelineRun that runs the pipeline of a finished PipelineRun again from a task
+func RerunPipelineRun(ctx context.Context, client runtimeClient.Client, namespace, name, rerunFrom string) (pr *opsv1.PipelineRun, err error) {
+	origin := &opsv1.PipelineRun{}
+	err = client.Get(ctx, runtimeClient.ObjectKey{Namespace: namespace, Name: name}, origin)
+	if err != nil {
+		return
+	}
+	p := &opsv1.Pipeline{}
+	err = client.Get(ctx, runtimeClient.ObjectKey{Namespace: namespace, Name: origin.Spec.PipelineRef}, p)
+	if err != nil {
+		return
+	}
+	pr, err = opsv1.NewRerunPipelineRun(origin, p, rerunFrom)
+	if err != nil {
+		return
+	}
+	err = client.Create(ctx, pr)
+	return
+}
+
+// DecidePipelineRunApproval approves or rejects an approval task of a PipelineRun waiting for it
+func DecidePipelineRunApproval(ctx context.Context, client runtimeClient.Client, namespace, name, task, decision, approver, comment string) (pr *opsv1.PipelineRun, err error) {
+	pr = &opsv1.PipelineRun{}
+	err = client.Get(ctx, runtimeClient.ObjectKey{Namespace: namespace, Name: name}, pr)
+	if err != nil {
+		return
+	}
+	p := &opsv1.Pipeline{}
+	err = client.Get(ctx, runtimeClient.ObjectKey{Namespace: namespace, Name: pr.Spec.PipelineRef}, p)
+	if err != nil {
+		return
+	}
+	err = pr.AddApproval(p, task, decision, approver, comment)
+	if err != nil {
+		return
+	}
+	err = client.Update(ctx, pr)
+	return
+}
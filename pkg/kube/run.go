@@ -4,7 +4,10 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sort"
+	"strconv"
 	"strings"
+	"unicode"
 
 	"github.com/shaowenchen/ops/pkg/constants"
 	"github.com/shaowenchen/ops/pkg/option"
@@ -282,21 +285,27 @@ func DownloadS3FileOnNode(client *kubernetes.Clientset, node *v1.Node, namespace
 
 // StepContainerConfig represents configuration for a step container
 type StepContainerConfig struct {
-	StepName     string
-	Content      string
-	LocalFile    string
-	RemoteFile   string
-	Direction    string
-	RuntimeImage string
-	Mode         string
-	IsFileStep   bool
-	FileOpt      *option.FileOption
-	AllowFailure string
+	StepName       string
+	Content        string
+	LocalFile      string
+	RemoteFile     string
+	Direction      string
+	RuntimeImage   string
+	Mode           string
+	IsFileStep     bool
+	FileOpt        *option.FileOption
+	AllowFailure   string
+	TimeoutSeconds int // the step container is killed after it, no timeout if 0
+	Attempt        int // the attempt of a step with retries or until, 0 for other steps
 }
 
+// stepPodDeadlineDelaySeconds is added to the timeouts of steps as the deadline of the pod, for pulling images and starting containers
+const stepPodDeadlineDelaySeconds = 300
+
 // RunTaskStepsOnNode creates a pod with multiple containers, one for each step
 // Uses init containers for all steps except the last one, which runs as the main container
-func RunTaskStepsOnNode(client *kubernetes.Clientset, node *v1.Node, namespacedName types.NamespacedName, stepConfigs []StepContainerConfig, defaultImage string, mounts []option.MountConfig) (pod *corev1.Pod, err error) {
+// The secretEnv are passed to the containers by a Secret of the pod, so their values are never in the pod spec
+func RunTaskStepsOnNode(client *kubernetes.Clientset, node *v1.Node, namespacedName types.NamespacedName, stepConfigs []StepContainerConfig, defaultImage string, mounts []option.MountConfig, secretEnv map[string]string) (pod *corev1.Pod, err error) {
 	if len(stepConfigs) == 0 {
 		err = errors.New("no step configurations provided")
 		return
@@ -319,12 +328,51 @@ func RunTaskStepsOnNode(client *kubernetes.Clientset, node *v1.Node, namespacedN
 	initContainers := []corev1.Container{}
 	for i := 0; i < len(stepConfigs)-1; i++ {
 		stepConfig := stepConfigs[i]
-		container := buildStepContainer(stepConfig, defaultImage, volumeMounts, priviBool)
+		container := buildStepContainer(stepConfig, defaultImage, volumeMounts, priviBool, namespacedName.Name, secretEnv)
 		initContainers = append(initContainers, container)
 	}
 
 	// Last step runs as main container
-	mainContainer := buildStepContainer(stepConfigs[len(stepConfigs)-1], defaultImage, volumeMounts, priviBool)
+	mainContainer := buildStepContainer(stepConfigs[len(stepConfigs)-1], defaultImage, volumeMounts, priviBool, namespacedName.Name, secretEnv)
+
+	// the pod is stopped once every step could have timed out
+	var activeDeadlineSeconds *int64
+	deadlineSeconds := int64(stepPodDeadlineDelaySeconds)
+	for _, stepConfig := range stepConfigs {
+		if stepConfig.TimeoutSeconds <= 0 {
+			deadlineSeconds = 0
+			break
+		}
+		deadlineSeconds += int64(stepConfig.TimeoutSeconds)
+	}
+	if deadlineSeconds > 0 {
+		activeDeadlineSeconds = &deadlineSeconds
+	}
+
+	// the Secret is named as the pod, it is created first so that the containers can start at once
+	if len(secretEnv) > 0 {
+		_, err = client.CoreV1().Secrets(namespacedName.Namespace).Create(context.TODO(), &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      namespacedName.Name,
+				Namespace: namespacedName.Namespace,
+				Labels: map[string]string{
+					constants.LabelOpsTaskKey: constants.LabelOpsTaskValue,
+				},
+			},
+			StringData: secretEnv,
+		}, metav1.CreateOptions{})
+		if err != nil {
+			return
+		}
+		defer func() {
+			if err == nil {
+				err = ownSecretByPod(client, pod)
+			}
+			if err != nil {
+				client.CoreV1().Secrets(namespacedName.Namespace).Delete(context.TODO(), namespacedName.Name, metav1.DeleteOptions{})
+			}
+		}()
+	}
 
 	hostFlag := true
 	pod, err = client.CoreV1().Pods(namespacedName.Namespace).Create(
@@ -339,6 +387,7 @@ func RunTaskStepsOnNode(client *kubernetes.Clientset, node *v1.Node, namespacedN
 			},
 			Spec: corev1.PodSpec{
 				AutomountServiceAccountToken: &automountSA,
+				ActiveDeadlineSeconds:        activeDeadlineSeconds,
 				NodeName:                     node.Name,
 				InitContainers:               initContainers,
 				Containers: []corev1.Container{
@@ -357,8 +406,77 @@ func RunTaskStepsOnNode(client *kubernetes.Clientset, node *v1.Node, namespacedN
 	return
 }
 
+// ownSecretByPod makes the pod own the Secret named as it, so the Secret is deleted with the pod
+func ownSecretByPod(client *kubernetes.Clientset, pod *corev1.Pod) error {
+	secret, err := client.CoreV1().Secrets(pod.Namespace).Get(context.TODO(), pod.Name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	secret.OwnerReferences = []metav1.OwnerReference{{
+		APIVersion: "v1",
+		Kind:       "Pod",
+		Name:       pod.Name,
+		UID:        pod.UID,
+	}}
+	_, err = client.CoreV1().Secrets(pod.Namespace).Update(context.TODO(), secret, metav1.UpdateOptions{})
+	return err
+}
+
+// SecretEnvName returns the env of the step containers holding the value of a variable from a Secret
+func SecretEnvName(variable string) string {
+	return "OPS_SECRET_" + strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return unicode.ToUpper(r)
+		}
+		return '_'
+	}, variable)
+}
+
+// NewSecretEnv returns the variables with the ones from Secrets replaced by references to their env, and the env by name
+// The content of a step rendered with them has no value of Secrets, the references are replaced when the step runs
+func NewSecretEnv(vars map[string]string, secretVariables []string) (podVars map[string]string, secretEnv map[string]string) {
+	podVars = make(map[string]string, len(vars))
+	for k, v := range vars {
+		podVars[k] = v
+	}
+	for _, k := range secretVariables {
+		value, ok := vars[k]
+		if !ok {
+			continue
+		}
+		if secretEnv == nil {
+			secretEnv = make(map[string]string)
+		}
+		name := SecretEnvName(k)
+		podVars[k] = "${" + name + "}"
+		secretEnv[name] = value
+	}
+	return
+}
+
+// withSecretEnv decodes the script and replaces the references to the secretEnv with the values of the env in the container
+// The values are put in the script as rendering does, so a reference in quotes or in a python script works as well
+func withSecretEnv(shellBase64 string, secretEnv map[string]string) string {
+	// the dot keeps the line breaks at the end of the script
+	cmd := "ops_script=$(echo " + shellBase64 + " | base64 -d; echo .); ops_script=${ops_script%.}; "
+	for _, name := range sortedKeys(secretEnv) {
+		cmd += fmt.Sprintf(`ops_script=${ops_script//'${%s}'/"$%s"}; `, name, name)
+	}
+	return cmd + `printf '%s' "$ops_script"`
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 // buildStepContainer builds a container configuration for a step
-func buildStepContainer(stepConfig StepContainerConfig, defaultImage string, volumeMounts []v1.VolumeMount, priviBool bool) corev1.Container {
+// The secretEnv of a shell step are read from the Secret named secretName
+func buildStepContainer(stepConfig StepContainerConfig, defaultImage string, volumeMounts []v1.VolumeMount, priviBool bool, secretName string, secretEnv map[string]string) corev1.Container {
 	image := stepConfig.RuntimeImage
 	if image == "" {
 		image = defaultImage
@@ -427,11 +545,26 @@ func buildStepContainer(stepConfig StepContainerConfig, defaultImage string, vol
 			usePython = true
 		}
 		shellBase64 := utils.EncodingStringToBase64(stepConfig.Content)
+		script := "echo " + shellBase64 + " | base64 -d"
+		if len(secretEnv) > 0 {
+			script = withSecretEnv(shellBase64, secretEnv)
+			for _, name := range sortedKeys(secretEnv) {
+				container.Env = append(container.Env, corev1.EnvVar{
+					Name: name,
+					ValueFrom: &corev1.EnvVarSource{
+						SecretKeyRef: &corev1.SecretKeySelector{
+							LocalObjectReference: corev1.LocalObjectReference{Name: secretName},
+							Key:                  name,
+						},
+					},
+				})
+			}
+		}
 		cmdArg := []string{}
 		if stepConfig.Mode == constants.ModeContainer {
-			cmdArg = []string{"-c", "echo " + shellBase64 + " | base64 -d | bash"}
+			cmdArg = []string{"-c", script + " | bash"}
 		} else {
-			cmdArg = []string{"-c", "echo " + shellBase64 + " | base64 -d | nsenter -t 1 -m -u -i -n"}
+			cmdArg = []string{"-c", script + " | nsenter -t 1 -m -u -i -n"}
 		}
 		if usePython {
 			cmdArg[1] = cmdArg[1] + " -- python3 /dev/stdin"
@@ -442,6 +575,30 @@ func buildStepContainer(stepConfig StepContainerConfig, defaultImage string, vol
 			Privileged: &priviBool,
 		}
 	}
+	// the tail of the stderr of the step is kept as the termination message of the container
+	if len(container.Args) == 2 {
+		container.Args[1] = withStderrCapture(container.Args[1])
+	}
+	// kill the step after its timeout, the process group on the host with it
+	if stepConfig.TimeoutSeconds > 0 {
+		container.Command = append([]string{"timeout", "-k", "5", strconv.Itoa(stepConfig.TimeoutSeconds)}, container.Command...)
+	}
 
 	return container
 }
+
+// stderrTailBytes is the size of the tail of stderr kept as the termination message, the kubelet keeps at most 4096 bytes of it
+const stderrTailBytes = 4000
+
+// withStderrCapture streams the stderr of cmd to the logs of the container as it is written,
+// and keeps its tail as the termination message once cmd exits
+func withStderrCapture(cmd string) string {
+	return withStderrTail(cmd, corev1.TerminationMessagePathDefault)
+}
+
+// withStderrTail writes the last stderrTailBytes of the stderr of cmd to path, following a line of the bytes truncated before it
+func withStderrTail(cmd, path string) string {
+	return fmt.Sprintf(`ops_stderr=$(mktemp); { { %s; } 2>&1 1>&3 3>&- | tee "$ops_stderr" >&2; } 3>&1; ops_rc=${PIPESTATUS[0]}; `+
+		`ops_size=$(wc -c < "$ops_stderr"); { if [ "$ops_size" -gt %d ]; then echo "... $((ops_size - %d)) bytes of stderr truncated, the full stderr is in the output ..."; fi; tail -c %d "$ops_stderr"; } > %s; `+
+		`rm -f "$ops_stderr"; exit $ops_rc`, cmd, stderrTailBytes, stderrTailBytes, stderrTailBytes, path)
+}
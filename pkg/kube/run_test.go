@@ -0,0 +1,62 @@
+package kube
+
+import (
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/shaowenchen/ops/pkg/constants"
+	"github.com/shaowenchen/ops/pkg/utils"
+)
+
+func TestNewSecretEnv(t *testing.T) {
+	vars := map[string]string{"token": "s3cret", "db-password": "p", "name": "ops"}
+	podVars, secretEnv := NewSecretEnv(vars, []string{"token", "db-password", "missing"})
+	wantPodVars := map[string]string{"token": "${OPS_SECRET_TOKEN}", "db-password": "${OPS_SECRET_DB_PASSWORD}", "name": "ops"}
+	wantEnv := map[string]string{"OPS_SECRET_TOKEN": "s3cret", "OPS_SECRET_DB_PASSWORD": "p"}
+	if !reflect.DeepEqual(podVars, wantPodVars) || !reflect.DeepEqual(secretEnv, wantEnv) {
+		t.Errorf("NewSecretEnv() = %v, %v, want %v, %v", podVars, secretEnv, wantPodVars, wantEnv)
+	}
+	if vars["token"] != "s3cret" {
+		t.Errorf("NewSecretEnv() changes the variables: %v", vars)
+	}
+	if podVars, secretEnv := NewSecretEnv(vars, nil); secretEnv != nil || !reflect.DeepEqual(podVars, vars) {
+		t.Errorf("NewSecretEnv() without secrets = %v, %v", podVars, secretEnv)
+	}
+}
+
+func TestWithSecretEnv(t *testing.T) {
+	secret := `p&a/s\s'w"o$rd`
+	content := "echo \"${OPS_SECRET_TOKEN}\"\necho '${OPS_SECRET_TOKEN}'\necho ${OPS_SECRET_OTHER}\n\n"
+	secretEnv := map[string]string{"OPS_SECRET_TOKEN": "unused", "OPS_SECRET_OTHER": "other"}
+	script := withSecretEnv(utils.EncodingStringToBase64(content), secretEnv)
+	if strings.Contains(script, "unused") || strings.Contains(script, "other") {
+		t.Fatalf("withSecretEnv() has the values: %s", script)
+	}
+	runner := exec.Command("bash", "-c", script)
+	runner.Env = append(os.Environ(), "OPS_SECRET_TOKEN="+secret, "OPS_SECRET_OTHER=other")
+	output, err := runner.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "echo \"" + secret + "\"\necho '" + secret + "'\necho other\n\n"
+	if string(output) != want {
+		t.Errorf("the script is %q, want %q", output, want)
+	}
+}
+
+func TestBuildStepContainerWithSecretEnv(t *testing.T) {
+	stepConfig := StepContainerConfig{StepName: "login", Content: "login ${OPS_SECRET_TOKEN}", Mode: constants.ModeContainer}
+	container := buildStepContainer(stepConfig, "", nil, true, "ops-task-1", map[string]string{"OPS_SECRET_TOKEN": "s3cret"})
+	spec := strings.Join(append(container.Command, container.Args...), " ")
+	if strings.Contains(spec, "s3cret") || strings.Contains(spec, utils.EncodingStringToBase64("s3cret")) {
+		t.Errorf("the value of the secret is in the container: %s", spec)
+	}
+	if len(container.Env) != 1 || container.Env[0].Name != "OPS_SECRET_TOKEN" || container.Env[0].Value != "" ||
+		container.Env[0].ValueFrom == nil || container.Env[0].ValueFrom.SecretKeyRef == nil ||
+		container.Env[0].ValueFrom.SecretKeyRef.Name != "ops-task-1" || container.Env[0].ValueFrom.SecretKeyRef.Key != "OPS_SECRET_TOKEN" {
+		t.Errorf("env = %+v, want OPS_SECRET_TOKEN from the secret ops-task-1", container.Env)
+	}
+}
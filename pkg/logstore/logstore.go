@@ -0,0 +1,206 @@
+// Package logstore keeps the full outputs of steps, the status of a TaskRun only keeps the head and tail of a large output kept in the store
+//
+// The store is configured by env of the controller and server, LOG_STORE is local or s3, a local store writes
+// to LOG_STORE_DIR, which is shared by the controller and server, eg: a PVC of ReadWriteMany.
+package logstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	opsv1 "github.com/shaowenchen/ops/api/v1"
+	opsconstants "github.com/shaowenchen/ops/pkg/constants"
+	"github.com/shaowenchen/ops/pkg/storage"
+)
+
+// Store writes and reads the logs by key, eg: taskruns/{namespace}/{taskrun}/{node}/{index}-{step}.log
+type Store interface {
+	Put(ctx context.Context, key string, data []byte) error
+	Get(ctx context.Context, key string) ([]byte, error)
+}
+
+var ErrNotConfigured = errors.New("log store is not configured, set " + opsconstants.EnvLogStoreKey)
+
+var (
+	defaultStore Store
+	defaultErr   error
+	defaultOnce  sync.Once
+)
+
+// Default returns the store configured by env, it is nil if LOG_STORE is empty
+func Default() (Store, error) {
+	defaultOnce.Do(func() {
+		defaultStore, defaultErr = NewStoreFromEnv()
+	})
+	return defaultStore, defaultErr
+}
+
+// NewStoreFromEnv returns the store configured by env, it is nil if LOG_STORE is empty
+func NewStoreFromEnv() (Store, error) {
+	switch opsconstants.GetEnvLogStore() {
+	case "":
+		return nil, nil
+	case opsconstants.LogStoreLocal:
+		return &LocalStore{Dir: opsconstants.GetEnvLogStoreDir()}, nil
+	case opsconstants.LogStoreS3:
+		store := &S3Store{
+			Endpoint: os.Getenv(opsconstants.EnvLogStoreS3EndpointKey),
+			Region:   os.Getenv(opsconstants.EnvLogStoreS3RegionKey),
+			Bucket:   os.Getenv(opsconstants.EnvLogStoreS3BucketKey),
+			AK:       os.Getenv(opsconstants.EnvLogStoreS3AKKey),
+			SK:       os.Getenv(opsconstants.EnvLogStoreS3SKKey),
+		}
+		if store.Bucket == "" || store.AK == "" || store.SK == "" {
+			return nil, fmt.Errorf("log store s3 needs %s, %s and %s", opsconstants.EnvLogStoreS3BucketKey, opsconstants.EnvLogStoreS3AKKey, opsconstants.EnvLogStoreS3SKKey)
+		}
+		return store, nil
+	}
+	return nil, fmt.Errorf("unknown log store %s", opsconstants.GetEnvLogStore())
+}
+
+// LocalStore keeps the logs as files in Dir
+type LocalStore struct {
+	Dir string
+}
+
+func (s *LocalStore) Put(ctx context.Context, key string, data []byte) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+func (s *LocalStore) Get(ctx context.Context, key string) ([]byte, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(path)
+}
+
+func (s *LocalStore) path(key string) (string, error) {
+	if !IsValidKey(key) {
+		return "", fmt.Errorf("invalid log key %s", key)
+	}
+	return filepath.Join(s.Dir, filepath.FromSlash(key)), nil
+}
+
+// S3Store keeps the logs as objects in Bucket
+type S3Store struct {
+	Endpoint string
+	Region   string
+	Bucket   string
+	AK       string
+	SK       string
+}
+
+func (s *S3Store) Put(ctx context.Context, key string, data []byte) error {
+	if !IsValidKey(key) {
+		return fmt.Errorf("invalid log key %s", key)
+	}
+	return storage.S3PutObject(s.AK, s.SK, s.Region, s.Endpoint, s.Bucket, key, data)
+}
+
+func (s *S3Store) Get(ctx context.Context, key string) ([]byte, error) {
+	if !IsValidKey(key) {
+		return nil, fmt.Errorf("invalid log key %s", key)
+	}
+	return storage.S3GetObject(s.AK, s.SK, s.Region, s.Endpoint, s.Bucket, key)
+}
+
+// IsValidKey returns false if the key is absolute or goes out of the store, eg: ../../etc/passwd
+func IsValidKey(key string) bool {
+	if key == "" || strings.HasPrefix(key, "/") {
+		return false
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// StepOutputKey returns the key of the output of the index-th step recorded on a node of a TaskRun
+func StepOutputKey(namespace, taskRun, node string, index int, stepName string) string {
+	return strings.Join([]string{"taskruns", keyPart(namespace), keyPart(taskRun), keyPart(node), fmt.Sprintf("%d-%s.log", index, keyPart(stepName))}, "/")
+}
+
+// keyPart replaces the characters that can not be a part of a key
+func keyPart(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == ' ' || r < 0x20 {
+			return '_'
+		}
+		return r
+	}, s)
+	if s == "" || s == "." || s == ".." {
+		return "_" + s
+	}
+	return s
+}
+
+// Truncate keeps the head and tail of output in maxBytes, it returns output as it is if it is not larger than maxBytes
+func Truncate(output string, maxBytes int) (string, bool) {
+	if maxBytes <= 0 || len(output) <= maxBytes {
+		return output, false
+	}
+	half := maxBytes / 2
+	// a character cut at the boundary is dropped
+	head := strings.ToValidUTF8(output[:half], "")
+	tail := strings.ToValidUTF8(output[len(output)-half:], "")
+	return fmt.Sprintf("%s\n... %d bytes truncated ...\n%s", head, len(output)-len(head)-len(tail), tail), true
+}
+
+// OffloadStepOutputs writes the outputs of steps larger than maxBytes to store, then truncates them in the status of a TaskRun,
+// an output is not truncated if store is nil or it fails to be written, the steps truncated before are skipped
+func OffloadStepOutputs(ctx context.Context, store Store, tr *opsv1.TaskRun, maxBytes int) (err error) {
+	if store == nil {
+		return nil
+	}
+	for nodeName, nodeStatus := range tr.Status.TaskRunNodeStatus {
+		if nodeStatus == nil {
+			continue
+		}
+		for i, step := range nodeStatus.TaskRunStep {
+			if step.Truncated {
+				continue
+			}
+			output, truncated := Truncate(step.StepOutput, maxBytes)
+			if !truncated {
+				continue
+			}
+			key := StepOutputKey(tr.Namespace, tr.Name, nodeName, i, step.StepName)
+			if putErr := store.Put(ctx, key, []byte(step.StepOutput)); putErr != nil {
+				err = fmt.Errorf("failed to write output of step %s on %s: %v", step.StepName, nodeName, putErr)
+				continue
+			}
+			step.StepOutput, step.Truncated, step.OutputRef = output, true, key
+		}
+	}
+	return
+}
+
+// GetStepOutput returns the full output of a step, it is read from store if the step is truncated
+func GetStepOutput(ctx context.Context, store Store, step *opsv1.TaskRunStep) (string, error) {
+	if !step.Truncated || step.OutputRef == "" {
+		return step.StepOutput, nil
+	}
+	if store == nil {
+		return step.StepOutput, ErrNotConfigured
+	}
+	data, err := store.Get(ctx, step.OutputRef)
+	if err != nil {
+		return step.StepOutput, err
+	}
+	return string(data), nil
+}
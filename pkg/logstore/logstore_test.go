@@ -0,0 +1,145 @@
+package logstore
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	opsv1 "github.com/shaowenchen/ops/api/v1"
+)
+
+type failedStore struct{}
+
+func (s *failedStore) Put(ctx context.Context, key string, data []byte) error {
+	return errors.New("store is down")
+}
+
+func (s *failedStore) Get(ctx context.Context, key string) ([]byte, error) {
+	return nil, errors.New("store is down")
+}
+
+func newTaskRun(outputs ...string) *opsv1.TaskRun {
+	tr := &opsv1.TaskRun{}
+	tr.Namespace, tr.Name = "ops-system", "upgrade"
+	nodeStatus := &opsv1.TaskRunNodeStatus{}
+	for i, output := range outputs {
+		nodeStatus.TaskRunStep = append(nodeStatus.TaskRunStep, &opsv1.TaskRunStep{StepName: "step " + string(rune('a'+i)), StepOutput: output})
+	}
+	tr.Status.SetNodeStatus("node1", nodeStatus)
+	return tr
+}
+
+func TestLocalStore(t *testing.T) {
+	ctx := context.TODO()
+	store := &LocalStore{Dir: t.TempDir()}
+	key := StepOutputKey("ops-system", "upgrade", "node1", 0, "step a")
+	if err := store.Put(ctx, key, []byte("output")); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	data, err := store.Get(ctx, key)
+	if err != nil || string(data) != "output" {
+		t.Errorf("Get() = %q, %v, want output", data, err)
+	}
+	if _, err := store.Get(ctx, "taskruns/missing.log"); err == nil {
+		t.Errorf("Get() of a missing key has no error")
+	}
+	for _, key := range []string{"", "/etc/passwd", "../passwd", "taskruns/../../passwd", "taskruns//a"} {
+		if err := store.Put(ctx, key, []byte("x")); err == nil {
+			t.Errorf("Put(%q) has no error", key)
+		}
+	}
+}
+
+func TestStepOutputKey(t *testing.T) {
+	got := StepOutputKey("ops-system", "upgrade", "../node/1", 2, "")
+	want := "taskruns/ops-system/upgrade/.._node_1/2-_.log"
+	if got != want || !IsValidKey(got) {
+		t.Errorf("StepOutputKey() = %q, want %q", got, want)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		output    string
+		maxBytes  int
+		want      string
+		truncated bool
+	}{
+		{"0123456789", 0, "0123456789", false},
+		{"0123456789", 10, "0123456789", false},
+		{"0123456789", 4, "01\n... 6 bytes truncated ...\n89", true},
+		// a character cut at the boundary is dropped
+		{"你好ab世界", 8, "你\n... 8 bytes truncated ...\n界", true},
+	}
+	for _, tt := range tests {
+		got, truncated := Truncate(tt.output, tt.maxBytes)
+		if got != tt.want || truncated != tt.truncated {
+			t.Errorf("Truncate(%q, %d) = %q, %v, want %q, %v", tt.output, tt.maxBytes, got, truncated, tt.want, tt.truncated)
+		}
+	}
+}
+
+func TestOffloadStepOutputs(t *testing.T) {
+	ctx := context.TODO()
+	large := strings.Repeat("x", 100)
+	store := &LocalStore{Dir: t.TempDir()}
+	tr := newTaskRun("small", large)
+	if err := OffloadStepOutputs(ctx, store, tr, 10); err != nil {
+		t.Fatalf("OffloadStepOutputs() error = %v", err)
+	}
+	steps := tr.Status.TaskRunNodeStatus["node1"].TaskRunStep
+	if steps[0].Truncated || steps[0].StepOutput != "small" || steps[0].OutputRef != "" {
+		t.Errorf("small output is offloaded: %+v", steps[0])
+	}
+	if !steps[1].Truncated || steps[1].OutputRef == "" || len(steps[1].StepOutput) >= len(large) {
+		t.Fatalf("large output is not offloaded: %+v", steps[1])
+	}
+	output, err := GetStepOutput(ctx, store, steps[1])
+	if err != nil || output != large {
+		t.Errorf("GetStepOutput() = %q, %v, want the full output", output, err)
+	}
+	// the steps truncated before are kept
+	truncatedOutput := steps[1].StepOutput
+	if err := OffloadStepOutputs(ctx, store, tr, 10); err != nil || steps[1].StepOutput != truncatedOutput {
+		t.Errorf("truncated output is offloaded again: %q, %v", steps[1].StepOutput, err)
+	}
+}
+
+func TestOffloadStepOutputsWithoutStore(t *testing.T) {
+	ctx := context.TODO()
+	large := strings.Repeat("x", 100)
+	tests := []struct {
+		name    string
+		store   Store
+		wantErr bool
+	}{
+		{"no store", nil, false},
+		{"failed store", &failedStore{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := newTaskRun(large)
+			err := OffloadStepOutputs(ctx, tt.store, tr, 10)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("OffloadStepOutputs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			step := tr.Status.TaskRunNodeStatus["node1"].TaskRunStep[0]
+			if step.Truncated || step.StepOutput != large || step.OutputRef != "" {
+				t.Errorf("output is truncated: %+v", step)
+			}
+			output, err := GetStepOutput(ctx, tt.store, step)
+			if err != nil || output != large {
+				t.Errorf("GetStepOutput() = %q, %v, want the full output", output, err)
+			}
+		})
+	}
+}
+
+func TestGetStepOutputNotConfigured(t *testing.T) {
+	step := &opsv1.TaskRunStep{StepOutput: "head", Truncated: true, OutputRef: "taskruns/a.log"}
+	output, err := GetStepOutput(context.TODO(), nil, step)
+	if !errors.Is(err, ErrNotConfigured) || output != "head" {
+		t.Errorf("GetStepOutput() = %q, %v, want head, %v", output, err, ErrNotConfigured)
+	}
+}
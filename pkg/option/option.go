@@ -74,6 +74,12 @@ type TaskOption struct {
 	Proxy     string
 	Variables map[string]string
 	Clear     bool
+	// Secrets are the values from Secrets, they are masked in the outputs and logs of steps
+	Secrets []string
+	// SecretVariables are the names of the variables from Secrets, they are passed to step pods by a Secret
+	SecretVariables []string
+	// DryRun renders the steps on every host without running them
+	DryRun bool
 }
 
 type ShellOption struct {
@@ -0,0 +1,195 @@
+package render
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/client-go/util/jsonpath"
+)
+
+// now is replaced to render with a fixed time
+var now = time.Now
+
+type function struct {
+	args int
+	// optional is true if the value can be not set, eg: the name of the reference is not found
+	optional bool
+	fn       func(value interface{}, args []string) (interface{}, error)
+}
+
+var functions = map[string]function{
+	// default returns the argument if the value is not set or empty
+	"default": {args: 1, optional: true, fn: func(value interface{}, args []string) (interface{}, error) {
+		if value == nil || toString(value) == "" {
+			return args[0], nil
+		}
+		return value, nil
+	}},
+	"upper": {fn: func(value interface{}, args []string) (interface{}, error) {
+		return strings.ToUpper(toString(value)), nil
+	}},
+	"lower": {fn: func(value interface{}, args []string) (interface{}, error) {
+		return strings.ToLower(toString(value)), nil
+	}},
+	"trim": {fn: func(value interface{}, args []string) (interface{}, error) {
+		return strings.TrimSpace(toString(value)), nil
+	}},
+	// split returns the list of the value separated by the argument
+	"split": {args: 1, fn: func(value interface{}, args []string) (interface{}, error) {
+		list := []interface{}{}
+		for _, item := range strings.Split(toString(value), args[0]) {
+			list = append(list, item)
+		}
+		return list, nil
+	}},
+	// join returns the items of a list joined by the argument
+	"join": {args: 1, fn: func(value interface{}, args []string) (interface{}, error) {
+		list, ok := value.([]interface{})
+		if !ok {
+			return toString(value), nil
+		}
+		items := make([]string, 0, len(list))
+		for _, item := range list {
+			items = append(items, toString(item))
+		}
+		return strings.Join(items, args[0]), nil
+	}},
+	"b64enc": {fn: func(value interface{}, args []string) (interface{}, error) {
+		return base64.StdEncoding.EncodeToString([]byte(toString(value))), nil
+	}},
+	"b64dec": {fn: func(value interface{}, args []string) (interface{}, error) {
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(toString(value)))
+		if err != nil {
+			return nil, err
+		}
+		return string(decoded), nil
+	}},
+	"toJson": {fn: func(value interface{}, args []string) (interface{}, error) {
+		data, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		return string(data), nil
+	}},
+	"fromJson": {fn: func(value interface{}, args []string) (interface{}, error) {
+		return fromJSON(value)
+	}},
+	// jsonpath returns the values at the path of a json, eg: ${steps.get.output | jsonpath ".items[*].metadata.name"}
+	"jsonpath": {args: 1, fn: func(value interface{}, args []string) (interface{}, error) {
+		data, err := fromJSON(value)
+		if err != nil {
+			return nil, err
+		}
+		path := args[0]
+		if !strings.Contains(path, "{") {
+			path = "{" + path + "}"
+		}
+		jp := jsonpath.New("render")
+		if err = jp.Parse(path); err != nil {
+			return nil, err
+		}
+		results, err := jp.FindResults(data)
+		if err != nil {
+			return nil, err
+		}
+		list := []interface{}{}
+		for _, result := range results {
+			for _, r := range result {
+				list = append(list, r.Interface())
+			}
+		}
+		if len(list) == 1 {
+			return list[0], nil
+		}
+		return list, nil
+	}},
+	// regexFind returns the first match of the regular expression, or the first group of it if the expression has groups
+	"regexFind": {args: 1, fn: func(value interface{}, args []string) (interface{}, error) {
+		re, err := regexp.Compile(args[0])
+		if err != nil {
+			return nil, err
+		}
+		match := re.FindStringSubmatch(toString(value))
+		if len(match) == 0 {
+			return "", nil
+		}
+		if len(match) > 1 {
+			return match[1], nil
+		}
+		return match[0], nil
+	}},
+	// date formats a time with the go layout, the value is now, a time in RFC3339 or unix seconds
+	"date": {args: 1, fn: func(value interface{}, args []string) (interface{}, error) {
+		t, err := toTime(value)
+		if err != nil {
+			return nil, err
+		}
+		return t.Format(args[0]), nil
+	}},
+}
+
+func call(name string, value interface{}, args []string) (interface{}, error) {
+	f, ok := functions[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %s", name)
+	}
+	if len(args) != f.args {
+		return nil, fmt.Errorf("function %s wants %d arguments, got %d", name, f.args, len(args))
+	}
+	if value == nil && !f.optional {
+		return nil, fmt.Errorf("value of function %s is not set", name)
+	}
+	return f.fn(value, args)
+}
+
+func fromJSON(value interface{}) (interface{}, error) {
+	s, ok := value.(string)
+	if !ok {
+		return value, nil
+	}
+	var data interface{}
+	if err := json.Unmarshal([]byte(s), &data); err != nil {
+		return nil, fmt.Errorf("invalid json: %v", err)
+	}
+	return data, nil
+}
+
+func toTime(value interface{}) (time.Time, error) {
+	switch v := value.(type) {
+	case time.Time:
+		return v, nil
+	case float64:
+		return time.Unix(int64(v), 0), nil
+	}
+	s := strings.TrimSpace(toString(value))
+	if seconds, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// toString returns a string as it is, and other values in json, eg: the list returned by split
+func toString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case time.Time:
+		return v.Format(time.RFC3339)
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
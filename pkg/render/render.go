@@ -0,0 +1,223 @@
+// Package render renders the references like ${name} in strings
+//
+// A reference can pipe its value to functions, eg: ${name | default "ops" | upper}.
+// The head of a reference is a name, a string in quotes or now, the arguments of functions
+// are strings in quotes or words. Strings are rendered from left to right in one pass, the values
+// are not rendered again, and $${ is rendered as ${. A reference that can not be resolved,
+// or whose functions fail, is kept as it is.
+package render
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Resolver returns the value of a name referenced by ${name}
+type Resolver func(name string) (string, bool)
+
+// MapResolver resolves names from vars
+func MapResolver(vars map[string]string) Resolver {
+	return func(name string) (string, bool) {
+		value, ok := vars[name]
+		return value, ok
+	}
+}
+
+// Render renders the references in target, the error of the first reference that failed is returned
+func Render(target string, resolve Resolver) (string, error) {
+	if !strings.Contains(target, "${") {
+		return target, nil
+	}
+	var sb strings.Builder
+	var firstErr error
+	for i := 0; i < len(target); {
+		if strings.HasPrefix(target[i:], "$${") {
+			sb.WriteString("${")
+			i += 3
+			continue
+		}
+		if !strings.HasPrefix(target[i:], "${") {
+			sb.WriteByte(target[i])
+			i++
+			continue
+		}
+		end := findReferenceEnd(target, i+2)
+		if end < 0 {
+			sb.WriteString(target[i:])
+			break
+		}
+		value, ok, err := evalReference(target[i+2:end], resolve)
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("failed to render %s: %v", target[i:end+1], err)
+		}
+		if ok && err == nil {
+			sb.WriteString(value)
+		} else {
+			sb.WriteString(target[i : end+1])
+		}
+		i = end + 1
+	}
+	return sb.String(), firstErr
+}
+
+// References returns the names referenced in target in order, eg: name in ${name | upper}
+func References(target string) (names []string) {
+	for i := 0; i < len(target); {
+		if strings.HasPrefix(target[i:], "$${") {
+			i += 3
+			continue
+		}
+		if !strings.HasPrefix(target[i:], "${") {
+			i++
+			continue
+		}
+		end := findReferenceEnd(target, i+2)
+		if end < 0 {
+			break
+		}
+		segments := splitOutsideQuotes(target[i+2:end], '|')
+		head := strings.TrimSpace(segments[0])
+		if head != "" && !isQuoted(head) {
+			names = append(names, head)
+		}
+		i = end + 1
+	}
+	return
+}
+
+// RenderVars renders the variables that reference other variables, a variable is rendered before the ones referencing it
+// The references in a cycle are kept as they are
+func RenderVars(vars map[string]string) map[string]string {
+	rendered := make(map[string]string, len(vars))
+	rendering := make(map[string]bool)
+	var resolve Resolver
+	resolve = func(name string) (string, bool) {
+		if value, ok := rendered[name]; ok {
+			return value, true
+		}
+		raw, ok := vars[name]
+		if !ok || rendering[name] {
+			return "", false
+		}
+		rendering[name] = true
+		value, _ := Render(raw, resolve)
+		rendering[name] = false
+		rendered[name] = value
+		return value, true
+	}
+	for name := range vars {
+		resolve(name)
+	}
+	for name, value := range rendered {
+		vars[name] = value
+	}
+	return vars
+}
+
+// findReferenceEnd returns the index of the } closing a reference starting at start, a } in quotes does not close it
+func findReferenceEnd(target string, start int) int {
+	var quote byte
+	for i := start; i < len(target); i++ {
+		c := target[i]
+		switch {
+		case quote != 0 && c == '\\':
+			i++
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote == 0 && c == '}':
+			return i
+		}
+	}
+	return -1
+}
+
+// splitOutsideQuotes splits s by sep that is not in quotes
+func splitOutsideQuotes(s string, sep byte) (parts []string) {
+	var quote byte
+	last := 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0 && c == '\\':
+			i++
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote == 0 && c == sep:
+			parts = append(parts, s[last:i])
+			last = i + 1
+		}
+	}
+	return append(parts, s[last:])
+}
+
+func isQuoted(s string) bool {
+	return len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0]
+}
+
+// unquote removes the quotes of s and the backslashes escaping the quote or a backslash in it,
+// other backslashes are kept, eg: "v(\d+)" is v(\d+)
+func unquote(s string) string {
+	if !isQuoted(s) {
+		return s
+	}
+	quote := s[0]
+	s = s[1 : len(s)-1]
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && (s[i+1] == quote || s[i+1] == '\\') {
+			i++
+		}
+		sb.WriteByte(s[i])
+	}
+	return sb.String()
+}
+
+// splitArgs splits a function call by spaces that are not in quotes, the quotes of arguments are removed
+func splitArgs(call string) (args []string) {
+	for _, field := range splitOutsideQuotes(strings.TrimSpace(call), ' ') {
+		if field = strings.TrimSpace(field); field != "" {
+			args = append(args, unquote(field))
+		}
+	}
+	return
+}
+
+// evalReference evaluates the reference in ${}, ok is false if the name of it can not be resolved
+func evalReference(ref string, resolve Resolver) (result string, ok bool, err error) {
+	segments := splitOutsideQuotes(ref, '|')
+	head := strings.TrimSpace(segments[0])
+	var value interface{}
+	switch {
+	case isQuoted(head):
+		value = unquote(head)
+	case head == "":
+		return "", false, nil
+	default:
+		if resolve != nil {
+			if v, found := resolve(head); found {
+				value = v
+			}
+		}
+		if value == nil && head == "now" {
+			value = now()
+		}
+	}
+	for _, segment := range segments[1:] {
+		args := splitArgs(segment)
+		if len(args) == 0 {
+			return "", false, fmt.Errorf("empty function")
+		}
+		value, err = call(args[0], value, args[1:])
+		if err != nil {
+			return "", false, err
+		}
+	}
+	if value == nil {
+		return "", false, nil
+	}
+	return toString(value), true, nil
+}
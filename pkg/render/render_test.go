@@ -0,0 +1,142 @@
+package render
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	vars := map[string]string{
+		"name":    "ops",
+		"env":     "",
+		"ref":     "${name}",
+		"json":    `{"items":[{"metadata":{"name":"a"}},{"metadata":{"name":"b"}}],"count":2}`,
+		"version": "release-v12.3",
+		"list":    "a,b,c",
+		"time":    "1700000000",
+	}
+	tests := []struct {
+		target string
+		want   string
+	}{
+		{"no references", "no references"},
+		{"hello ${name}", "hello ops"},
+		{"${ name }-${name}", "ops-ops"},
+		// single pass, the values are not rendered again
+		{"${ref}", "${name}"},
+		// $${ is kept as ${
+		{"$${name} is ${name}", "${name} is ops"},
+		{"echo $${HOME}", "echo ${HOME}"},
+		// unresolved references are kept as they are
+		{"${missing}", "${missing}"},
+		{"${HOME:-/root}", "${HOME:-/root}"},
+		{"${}", "${}"},
+		{"${name", "${name"},
+		// functions
+		{"${name | upper}", "OPS"},
+		{"${env | default \"dev\" | upper}", "DEV"},
+		{"${missing | default 'x y'}", "x y"},
+		{"${\" a \" | trim}", "a"},
+		{"${list | split \",\" | join \"-\"}", "a-b-c"},
+		{"${name | b64enc}", "b3Bz"},
+		{"${name | b64enc | b64dec}", "ops"},
+		{"${version | regexFind \"v(\\d+)\"}", "12"},
+		{"${version | regexFind \"v\\d+\"}", "v12"},
+		{"${time | date \"2006-01-02\"}", "2023-11-14"},
+		{"${list | split \",\" | toJson}", `["a","b","c"]`},
+		// a } in quotes does not close the reference
+		{"${missing | default \"}\"}", "}"},
+		// jsonpath
+		{"${json | jsonpath \".count\"}", "2"},
+		{"${json | jsonpath \".items[0].metadata.name\"}", "a"},
+		{"${json | jsonpath \".items[*].metadata.name\" | join \",\"}", "a,b"},
+		{"${json | jsonpath \"{.items[1].metadata.name}\"}", "b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			got, err := Render(tt.target, MapResolver(vars))
+			if err != nil {
+				t.Fatalf("Render(%q) error = %v", tt.target, err)
+			}
+			if got != tt.want {
+				t.Errorf("Render(%q) = %q, want %q", tt.target, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderErrors(t *testing.T) {
+	vars := map[string]string{"name": "ops", "json": `{"a":1}`}
+	tests := []struct {
+		target  string
+		want    string
+		wantErr string
+	}{
+		{"${name | unknown}", "${name | unknown}", "unknown function"},
+		{"${name | upper x}", "${name | upper x}", "wants 0 arguments"},
+		{"${missing | upper}", "${missing | upper}", "is not set"},
+		{"${name | b64dec}", "${name | b64dec}", "illegal base64"},
+		{"${name | fromJson}", "${name | fromJson}", "invalid json"},
+		{"${json | jsonpath \".b\"}", "${json | jsonpath \".b\"}", "not found"},
+		{"${name | regexFind \"(\"}", "${name | regexFind \"(\"}", "missing closing"},
+		{"${name |}", "${name |}", "empty function"},
+		// the other references are still rendered
+		{"${name} ${name | unknown} ${name}", "ops ${name | unknown} ops", "unknown function"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			got, err := Render(tt.target, MapResolver(vars))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Render(%q) error = %v, want %q", tt.target, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Render(%q) = %q, want %q", tt.target, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReferences(t *testing.T) {
+	got := References(`${a} $${b} ${c | default "d"} ${"e"} ${ f }`)
+	want := []string{"a", "c", "f"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("References() = %v, want %v", got, want)
+	}
+}
+
+func TestRenderVars(t *testing.T) {
+	tests := []struct {
+		name string
+		vars map[string]string
+		want map[string]string
+	}{
+		{
+			name: "references are rendered before the variables referencing them",
+			vars: map[string]string{"url": "http://${host}:${port}/", "host": "${name}.svc", "name": "ops", "port": "80"},
+			want: map[string]string{"url": "http://ops.svc:80/", "host": "ops.svc", "name": "ops", "port": "80"},
+		},
+		{
+			name: "functions",
+			vars: map[string]string{"name": "ops", "upper": "${name | upper}", "message": "${upper} is up"},
+			want: map[string]string{"name": "ops", "upper": "OPS", "message": "OPS is up"},
+		},
+		{
+			name: "values are not rendered again",
+			vars: map[string]string{"a": "$${b}", "b": "x", "c": "${a}"},
+			want: map[string]string{"a": "${b}", "b": "x", "c": "${b}"},
+		},
+		{
+			name: "references in a cycle are kept",
+			vars: map[string]string{"a": "${b}", "b": "${a}", "c": "${missing}"},
+			want: map[string]string{"a": "${a}", "b": "${a}", "c": "${missing}"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RenderVars(tt.vars); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("RenderVars() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
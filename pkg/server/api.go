@@ -15,6 +15,7 @@ import (
 	opsconstants "github.com/shaowenchen/ops/pkg/constants"
 	opsevent "github.com/shaowenchen/ops/pkg/event"
 	opskube "github.com/shaowenchen/ops/pkg/kube"
+	opslogstore "github.com/shaowenchen/ops/pkg/logstore"
 	opsutils "github.com/shaowenchen/ops/pkg/utils"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -714,6 +715,11 @@ func CreateTask(c *gin.Context) {
 	if task.Namespace == "" {
 		task.Namespace = req.Namespace
 	}
+	err = task.Validate()
+	if err != nil {
+		showError(c, err.Error())
+		return
+	}
 	client, err := opskube.GetRuntimeClient("")
 	if err != nil {
 		showError(c, err.Error())
@@ -760,11 +766,24 @@ func CreatePipeline(c *gin.Context) {
 	if pipeline.Namespace == "" {
 		pipeline.Namespace = req.Namespace
 	}
+	err = pipeline.ValidateTaskGraph()
+	if err != nil {
+		showError(c, err.Error())
+		return
+	}
 	client, err := opskube.GetRuntimeClient("")
 	if err != nil {
 		showError(c, err.Error())
 		return
 	}
+	err = pipeline.ValidatePipelineRefs(func(name string) (*opsv1.Pipeline, error) {
+		p := &opsv1.Pipeline{}
+		return p, client.Get(context.TODO(), runtimeClient.ObjectKey{Namespace: pipeline.Namespace, Name: name}, p)
+	})
+	if err != nil {
+		showError(c, err.Error())
+		return
+	}
 	err = client.Create(context.TODO(), pipeline)
 	if err != nil {
 		showError(c, err.Error())
@@ -792,6 +811,11 @@ func PutTask(c *gin.Context) {
 		showError(c, err.Error())
 		return
 	}
+	err = task.Validate()
+	if err != nil {
+		showError(c, err.Error())
+		return
+	}
 	client, err := opskube.GetRuntimeClient("")
 	if err != nil {
 		showError(c, err.Error())
@@ -824,11 +848,24 @@ func PutPipeline(c *gin.Context) {
 		showError(c, err.Error())
 		return
 	}
+	err = pipeline.ValidateTaskGraph()
+	if err != nil {
+		showError(c, err.Error())
+		return
+	}
 	client, err := opskube.GetRuntimeClient("")
 	if err != nil {
 		showError(c, err.Error())
 		return
 	}
+	err = pipeline.ValidatePipelineRefs(func(name string) (*opsv1.Pipeline, error) {
+		p := &opsv1.Pipeline{}
+		return p, client.Get(context.TODO(), runtimeClient.ObjectKey{Namespace: pipeline.Namespace, Name: name}, p)
+	})
+	if err != nil {
+		showError(c, err.Error())
+		return
+	}
 	err = client.Update(context.TODO(), pipeline)
 	if err != nil {
 		showError(c, err.Error())
@@ -1083,6 +1120,89 @@ func GetTaskRun(c *gin.Context) {
 	showData(c, taskRun)
 }
 
+// @Summary Get TaskRun Logs
+// @Tags TaskRuns
+// @Accept json
+// @Produce json
+// @Param namespace path string true "namespace"
+// @Param taskrun path string true "taskrun"
+// @Param node query string false "node"
+// @Param step query string false "step"
+// @Success 200
+// @Router /api/v1/namespaces/{namespace}/taskruns/{taskrun}/logs [get]
+func GetTaskRunLogs(c *gin.Context) {
+	type Params struct {
+		Namespace string `uri:"namespace"`
+		Taskrun   string `uri:"taskrun"`
+		Node      string `form:"node"`
+		Step      string `form:"step"`
+	}
+	type StepLog struct {
+		Node      string `json:"node"`
+		StepName  string `json:"stepName"`
+		Attempt   int    `json:"attempt,omitempty"`
+		Output    string `json:"output"`
+		Truncated bool   `json:"truncated,omitempty"`
+	}
+	var req = Params{}
+	err := c.ShouldBindUri(&req)
+	if err != nil {
+		showError(c, err.Error())
+		return
+	}
+	err = c.ShouldBindQuery(&req)
+	if err != nil {
+		showError(c, err.Error())
+		return
+	}
+	client, err := opskube.GetRuntimeClient("")
+	if err != nil {
+		showError(c, err.Error())
+		return
+	}
+	taskRun := &opsv1.TaskRun{}
+	err = client.Get(context.TODO(), runtimeClient.ObjectKey{
+		Namespace: req.Namespace,
+		Name:      req.Taskrun,
+	}, taskRun)
+	if err != nil {
+		showError(c, err.Error())
+		return
+	}
+	store, err := opslogstore.Default()
+	if err != nil {
+		showError(c, err.Error())
+		return
+	}
+	nodes := make([]string, 0, len(taskRun.Status.TaskRunNodeStatus))
+	for node := range taskRun.Status.TaskRunNodeStatus {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	logs := make([]StepLog, 0)
+	for _, node := range nodes {
+		nodeStatus := taskRun.Status.TaskRunNodeStatus[node]
+		if (req.Node != "" && req.Node != node) || nodeStatus == nil {
+			continue
+		}
+		for _, step := range nodeStatus.TaskRunStep {
+			if req.Step != "" && req.Step != step.StepName {
+				continue
+			}
+			// the truncated output is returned if the full one can not be read
+			output, err := opslogstore.GetStepOutput(c.Request.Context(), store, step)
+			logs = append(logs, StepLog{
+				Node:      node,
+				StepName:  step.StepName,
+				Attempt:   step.Attempt,
+				Output:    output,
+				Truncated: step.Truncated && (err != nil || step.OutputRef == ""),
+			})
+		}
+	}
+	showData(c, logs)
+}
+
 // @Summary Get PipelineRun
 // @Tags PipelineRuns
 // @Accept json
@@ -1251,6 +1371,7 @@ func ListPipelineRuns(c *gin.Context) {
 // @Param namespace path string true "namespace"
 // @Param taskRef body string true "taskRef"
 // @Param variables body map[string]string true "variables"
+// @Param dryRun query bool false "render the steps on every node without running them, it returns once planned"
 // @Success 200
 // @Router /api/v1/namespaces/{namespace}/taskruns [post]
 func CreateTaskRun(c *gin.Context) {
@@ -1269,6 +1390,7 @@ func CreateTaskRun(c *gin.Context) {
 // @Param namespace path string true "namespace"
 // @Param taskRef body string true "taskRef"
 // @Param variables body map[string]string true "variables"
+// @Param dryRun query bool false "render the steps on every node without running them, it returns once planned"
 // @Success 200
 // @Router /api/v1/namespaces/{namespace}/taskruns/sync [post]
 func CreateTaskRunSync(c *gin.Context) {
@@ -1285,12 +1407,17 @@ func createTaskRun(c *gin.Context, sync bool) (latest opsv1.TaskRun, err error)
 		Namespace string            `uri:"namespace"`
 		TaskRef   string            `json:"taskRef"`
 		Variables map[string]string `json:"variables"`
+		DryRun    bool              `form:"dryRun"`
 	}
 	var req = Params{}
 	err = c.ShouldBindUri(&req)
 	if err != nil {
 		return
 	}
+	err = c.ShouldBindQuery(&req)
+	if err != nil {
+		return
+	}
 	err = c.ShouldBindJSON(&req)
 	if err != nil {
 		return
@@ -1322,11 +1449,13 @@ func createTaskRun(c *gin.Context, sync bool) (latest opsv1.TaskRun, err error)
 		}
 	}
 	taskRun.Namespace = req.Namespace
+	taskRun.Spec.DryRun = req.DryRun
 	err = client.Create(context.TODO(), &taskRun)
 	if err != nil {
 		return
 	}
-	if !sync {
+	// a dry run is waited for, so that the planned steps are returned
+	if !sync && !req.DryRun {
 		latest = taskRun
 		return
 	}
@@ -1351,7 +1480,7 @@ func createTaskRun(c *gin.Context, sync bool) (latest opsv1.TaskRun, err error)
 			if err != nil {
 				return
 			}
-			if latest.Status.RunStatus == opsconstants.StatusSuccessed || latest.Status.RunStatus == opsconstants.StatusFailed || latest.Status.RunStatus == opsconstants.StatusAborted || latest.Status.RunStatus == opsconstants.StatusDataInValid {
+			if opsconstants.IsFinishedStatus(latest.Status.RunStatus) {
 				return
 			}
 
@@ -1373,6 +1502,7 @@ func createTaskRun(c *gin.Context, sync bool) (latest opsv1.TaskRun, err error)
 // @Param namespace path string true "namespace"
 // @Param pipelineRef body string true "pipelineRef"
 // @Param variables body map[string]string true "variables"
+// @Param dryRun query bool false "render the steps on every node without running them, it returns once planned"
 // @Success 200
 // @Router /api/v1/namespaces/{namespace}/pipelineruns [post]
 func CreatePipelineRun(c *gin.Context) {
@@ -1391,6 +1521,7 @@ func CreatePipelineRun(c *gin.Context) {
 // @Param namespace path string true "namespace"
 // @Param pipelineRef body string true "pipelineRef"
 // @Param variables body map[string]string true "variables"
+// @Param dryRun query bool false "render the steps on every node without running them, it returns once planned"
 // @Success 200
 // @Router /api/v1/namespaces/{namespace}/pipelineruns/sync [post]
 func CreatePipelineRunSync(c *gin.Context) {
@@ -1407,12 +1538,17 @@ func createPipelineRun(c *gin.Context, sync bool) (latest opsv1.PipelineRun, err
 		Namespace   string            `uri:"namespace"`
 		PipelineRef string            `json:"pipelineRef"`
 		Variables   map[string]string `json:"variables"`
+		DryRun      bool              `form:"dryRun"`
 	}
 	var req = Params{}
 	err = c.ShouldBindUri(&req)
 	if err != nil {
 		return
 	}
+	err = c.ShouldBindQuery(&req)
+	if err != nil {
+		return
+	}
 	err = c.ShouldBindJSON(&req)
 	if err != nil {
 		return
@@ -1435,13 +1571,15 @@ func createPipelineRun(c *gin.Context, sync bool) (latest opsv1.PipelineRun, err
 	if req.Variables != nil {
 		pipelinerun.Spec.Variables = req.Variables
 	}
+	pipelinerun.Spec.DryRun = req.DryRun
 
 	err = client.Create(context.TODO(), pipelinerun)
 	if err != nil {
 		return
 	}
 
-	if !sync {
+	// a dry run is waited for, so that the planned TaskRuns are returned
+	if !sync && !req.DryRun {
 		latest = *pipelinerun
 		return
 	}
@@ -1464,7 +1602,7 @@ func createPipelineRun(c *gin.Context, sync bool) (latest opsv1.PipelineRun, err
 			if err != nil {
 				return
 			}
-			if latest.Status.RunStatus == opsconstants.StatusSuccessed || latest.Status.RunStatus == opsconstants.StatusFailed || latest.Status.RunStatus == opsconstants.StatusAborted {
+			if opsconstants.IsFinishedStatus(latest.Status.RunStatus) {
 				return
 			}
 
@@ -1479,6 +1617,216 @@ func createPipelineRun(c *gin.Context, sync bool) (latest opsv1.PipelineRun, err
 	}
 }
 
+// @Summary Cancel TaskRun
+// @Tags TaskRuns
+// @Accept json
+// @Produce json
+// @Param namespace path string true "namespace"
+// @Param taskrun path string true "taskrun"
+// @Success 200
+// @Router /api/v1/namespaces/{namespace}/taskruns/{taskrun}/cancel [post]
+func CancelTaskRun(c *gin.Context) {
+	type Params struct {
+		Namespace string `uri:"namespace"`
+		Taskrun   string `uri:"taskrun"`
+	}
+	var req = Params{}
+	err := c.ShouldBindUri(&req)
+	if err != nil {
+		showError(c, err.Error())
+		return
+	}
+	client, err := opskube.GetRuntimeClient("")
+	if err != nil {
+		showError(c, err.Error())
+		return
+	}
+	taskRun := &opsv1.TaskRun{}
+	err = client.Get(context.TODO(), runtimeClient.ObjectKey{
+		Namespace: req.Namespace,
+		Name:      req.Taskrun,
+	}, taskRun)
+	if err != nil {
+		showError(c, err.Error())
+		return
+	}
+	if opsconstants.IsFinishedStatus(taskRun.Status.RunStatus) {
+		showError(c, "taskrun is finished with "+taskRun.Status.RunStatus)
+		return
+	}
+	taskRun.Spec.Cancelled = true
+	taskRun.Spec.CancelledBy = GetUser(c)
+	err = client.Update(context.TODO(), taskRun)
+	if err != nil {
+		showError(c, err.Error())
+		return
+	}
+	showData(c, taskRun.CopyWithOutVersion())
+}
+
+// @Summary Cancel PipelineRun
+// @Tags PipelineRuns
+// @Accept json
+// @Produce json
+// @Param namespace path string true "namespace"
+// @Param pipelinerun path string true "pipelinerun"
+// @Success 200
+// @Router /api/v1/namespaces/{namespace}/pipelineruns/{pipelinerun}/cancel [post]
+func CancelPipelineRun(c *gin.Context) {
+	type Params struct {
+		Namespace   string `uri:"namespace"`
+		Pipelinerun string `uri:"pipelinerun"`
+	}
+	var req = Params{}
+	err := c.ShouldBindUri(&req)
+	if err != nil {
+		showError(c, err.Error())
+		return
+	}
+	client, err := opskube.GetRuntimeClient("")
+	if err != nil {
+		showError(c, err.Error())
+		return
+	}
+	pipelineRun := &opsv1.PipelineRun{}
+	err = client.Get(context.TODO(), runtimeClient.ObjectKey{
+		Namespace: req.Namespace,
+		Name:      req.Pipelinerun,
+	}, pipelineRun)
+	if err != nil {
+		showError(c, err.Error())
+		return
+	}
+	if opsconstants.IsFinishedStatus(pipelineRun.Status.RunStatus) {
+		showError(c, "pipelinerun is finished with "+pipelineRun.Status.RunStatus)
+		return
+	}
+	pipelineRun.Spec.Cancelled = true
+	pipelineRun.Spec.CancelledBy = GetUser(c)
+	err = client.Update(context.TODO(), pipelineRun)
+	if err != nil {
+		showError(c, err.Error())
+		return
+	}
+	showData(c, pipelineRun.CopyWithOutVersion())
+}
+
+// @Summary Rerun PipelineRun
+// @Tags PipelineRuns
+// @Accept json
+// @Produce json
+// @Param namespace path string true "namespace"
+// @Param pipelinerun path string true "pipelinerun"
+// @Param rerunFrom body string true "rerunFrom"
+// @Success 200
+// @Router /api/v1/namespaces/{namespace}/pipelineruns/{pipelinerun}/rerun [post]
+func RerunPipelineRun(c *gin.Context) {
+	type Params struct {
+		Namespace   string `uri:"namespace"`
+		Pipelinerun string `uri:"pipelinerun"`
+		RerunFrom   string `json:"rerunFrom"`
+	}
+	var req = Params{}
+	err := c.ShouldBindUri(&req)
+	if err != nil {
+		showError(c, err.Error())
+		return
+	}
+	err = c.ShouldBindJSON(&req)
+	if err != nil {
+		showError(c, err.Error())
+		return
+	}
+	if req.RerunFrom == "" {
+		showError(c, "rerunFrom is required")
+		return
+	}
+	client, err := opskube.GetRuntimeClient("")
+	if err != nil {
+		showError(c, err.Error())
+		return
+	}
+	pipelineRun, err := opskube.RerunPipelineRun(context.TODO(), client, req.Namespace, req.Pipelinerun, req.RerunFrom)
+	if err != nil {
+		showError(c, err.Error())
+		return
+	}
+	showData(c, pipelineRun.CopyWithOutVersion())
+}
+
+// @Summary Approve PipelineRun
+// @Tags PipelineRuns
+// @Accept json
+// @Produce json
+// @Param namespace path string true "namespace"
+// @Param pipelinerun path string true "pipelinerun"
+// @Param task body string true "task"
+// @Param comment body string false "comment"
+// @Success 200
+// @Router /api/v1/namespaces/{namespace}/pipelineruns/{pipelinerun}/approve [post]
+func ApprovePipelineRun(c *gin.Context) {
+	decidePipelineRunApproval(c, opsconstants.ApprovalApproved)
+}
+
+// @Summary Reject PipelineRun
+// @Tags PipelineRuns
+// @Accept json
+// @Produce json
+// @Param namespace path string true "namespace"
+// @Param pipelinerun path string true "pipelinerun"
+// @Param task body string true "task"
+// @Param comment body string false "comment"
+// @Success 200
+// @Router /api/v1/namespaces/{namespace}/pipelineruns/{pipelinerun}/reject [post]
+func RejectPipelineRun(c *gin.Context) {
+	decidePipelineRunApproval(c, opsconstants.ApprovalRejected)
+}
+
+func decidePipelineRunApproval(c *gin.Context, decision string) {
+	type Params struct {
+		Namespace   string `uri:"namespace"`
+		Pipelinerun string `uri:"pipelinerun"`
+		Task        string `json:"task"`
+		Comment     string `json:"comment"`
+	}
+	var req = Params{}
+	err := c.ShouldBindUri(&req)
+	if err != nil {
+		showError(c, err.Error())
+		return
+	}
+	err = c.ShouldBindJSON(&req)
+	if err != nil {
+		showError(c, err.Error())
+		return
+	}
+	if req.Task == "" {
+		showError(c, "task is required")
+		return
+	}
+	// the approver is the user authenticated by the token, never the one claimed by the request
+	user := GetUser(c)
+	if user == "" {
+		showForbidden(c, "the token of a user in server users is required to approve or reject")
+		return
+	}
+	client, err := opskube.GetRuntimeClient("")
+	if err != nil {
+		showError(c, err.Error())
+		return
+	}
+	pipelineRun, err := opskube.DecidePipelineRunApproval(context.TODO(), client, req.Namespace, req.Pipelinerun, req.Task, decision, user, req.Comment)
+	if errors.Is(err, opsv1.ErrNotApprover) {
+		showForbidden(c, err.Error())
+		return
+	}
+	if err != nil {
+		showError(c, err.Error())
+		return
+	}
+	showData(c, pipelineRun.CopyWithOutVersion())
+}
+
 // @Summary Create Event
 // @Tags Events
 // @Accept json
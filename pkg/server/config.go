@@ -19,6 +19,22 @@ type ConfigOptions struct {
 type ServerOptions struct {
 	RunMode string `mapstructure:"runmode"`
 	Token   string `mapstructure:"token"`
+	// Users are the tokens of users as user:token,user:token, eg: SERVER_USERS=alice:token1,bob:token2
+	// the user of a request is the one whose token is presented, the shared Token has no user
+	Users string `mapstructure:"users"`
+}
+
+// GetUserTokens returns the tokens of Users by user name, the entries without a user or token are ignored
+func (o *ServerOptions) GetUserTokens() map[string]string {
+	tokens := make(map[string]string)
+	for _, item := range strings.Split(o.Users, ",") {
+		user, token, _ := strings.Cut(strings.TrimSpace(item), ":")
+		user, token = strings.TrimSpace(user), strings.TrimSpace(token)
+		if user != "" && token != "" {
+			tokens[user] = token
+		}
+	}
+	return tokens
 }
 
 type EventOption struct {
@@ -42,6 +42,8 @@ func SetupRouter(r *gin.Engine) {
 		v1Taskruns.POST("", CreateTaskRun)
 		v1Taskruns.POST("/sync", CreateTaskRunSync)
 		v1Taskruns.GET("/:taskrun", GetTaskRun)
+		v1Taskruns.GET("/:taskrun/logs", GetTaskRunLogs)
+		v1Taskruns.POST("/:taskrun/cancel", CancelTaskRun)
 	}
 	v1Pipelines := r.Group("/api/v1/namespaces/:namespace/pipelines").Use(AuthMiddleware())
 	{
@@ -57,6 +59,10 @@ func SetupRouter(r *gin.Engine) {
 		v1Pipelineruns.POST("", CreatePipelineRun)
 		v1Pipelineruns.POST("/sync", CreatePipelineRunSync)
 		v1Pipelineruns.GET("/:pipelinerun", GetPipelineRun)
+		v1Pipelineruns.POST("/:pipelinerun/cancel", CancelPipelineRun)
+		v1Pipelineruns.POST("/:pipelinerun/rerun", RerunPipelineRun)
+		v1Pipelineruns.POST("/:pipelinerun/approve", ApprovePipelineRun)
+		v1Pipelineruns.POST("/:pipelinerun/reject", RejectPipelineRun)
 	}
 	v1Login := r.Group("/api/v1/login").Use(AuthMiddleware())
 	{
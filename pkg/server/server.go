@@ -1,12 +1,16 @@
 package server
 
 import (
+	"crypto/subtle"
 	"encoding/base64"
 	"github.com/gin-gonic/gin"
 	"net/http"
 	"strings"
 )
 
+// contextUserKey keeps the user authenticated by the token of a request
+const contextUserKey = "user"
+
 type Pagination[T any] struct {
 	PageSize uint `form:"page_size" json:"page_size"`
 	Page     uint `form:"page" json:"page"`
@@ -44,6 +48,14 @@ func showNotAuthorized(c *gin.Context, message string) {
 		"message": "not authorized, " + message,
 	})
 }
+
+func showForbidden(c *gin.Context, message string) {
+	c.JSON(http.StatusForbidden, gin.H{
+		"code":    -1,
+		"message": "forbidden, " + message,
+	})
+}
+
 func showError(c *gin.Context, message string) {
 	c.JSON(http.StatusOK, gin.H{
 		"code":    -1,
@@ -79,20 +91,46 @@ func showSuccess(c *gin.Context) {
 
 func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if GlobalConfig.Server.Token == "" {
-			c.Next()
-			return
-		}
-		if GetToken(c) != GlobalConfig.Server.Token {
+		user, ok := Authenticate(c)
+		if !ok {
 			showNotAuthorized(c, "invalid token")
 			c.Abort()
 			return
 		}
-
+		if user != "" {
+			c.Set(contextUserKey, user)
+		}
 		c.Next()
 	}
 }
 
+// Authenticate returns the user whose token is presented by the request, the shared token has no user
+// ok is false if the token is neither the shared token nor the token of a user, every request is allowed without both
+func Authenticate(c *gin.Context) (user string, ok bool) {
+	token := GetToken(c)
+	userTokens := GlobalConfig.Server.GetUserTokens()
+	if token != "" {
+		// the user of basic auth is checked first, so users with the same token are told apart
+		if name, _, hasBasicAuth := c.Request.BasicAuth(); hasBasicAuth && isSameToken(userTokens[name], token) {
+			return name, true
+		}
+		for name, userToken := range userTokens {
+			if isSameToken(userToken, token) {
+				return name, true
+			}
+		}
+	}
+	if GlobalConfig.Server.Token == "" && len(userTokens) == 0 {
+		return "", true
+	}
+	return "", isSameToken(GlobalConfig.Server.Token, token)
+}
+
+// isSameToken compares a token in constant time, an empty expected token matches nothing
+func isSameToken(expected, token string) bool {
+	return expected != "" && subtle.ConstantTimeCompare([]byte(expected), []byte(token)) == 1
+}
+
 func GetToken(c *gin.Context) string {
 	// try get from header
 	authHeader := c.GetHeader("Authorization")
@@ -124,3 +162,8 @@ func GetToken(c *gin.Context) string {
 	}
 	return ""
 }
+
+// GetUser returns the user authenticated by the token of the request, it is empty for the shared token
+func GetUser(c *gin.Context) string {
+	return c.GetString(contextUserKey)
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestGetUserTokens(t *testing.T) {
+	o := &ServerOptions{Users: " alice:token1, bob : token2 ,carol:,:token3,,dave"}
+	want := map[string]string{"alice": "token1", "bob": "token2"}
+	if got := o.GetUserTokens(); !reflect.DeepEqual(got, want) {
+		t.Errorf("GetUserTokens() = %v, want %v", got, want)
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	origin := GlobalConfig.Server
+	defer func() { GlobalConfig.Server = origin }()
+	tests := []struct {
+		name     string
+		token    string
+		users    string
+		auth     func(r *http.Request)
+		wantUser string
+		wantOk   bool
+	}{
+		{"no token configured", "", "", func(r *http.Request) {}, "", true},
+		{"shared token", "ops", "alice:token1", func(r *http.Request) { r.Header.Set("Authorization", "Bearer ops") }, "", true},
+		{"bearer token of a user", "ops", "alice:token1", func(r *http.Request) { r.Header.Set("Authorization", "Bearer token1") }, "alice", true},
+		{"basic auth of a user", "ops", "alice:token1", func(r *http.Request) { r.SetBasicAuth("alice", "token1") }, "alice", true},
+		{"user is taken from the token", "ops", "alice:token1,bob:token2", func(r *http.Request) { r.SetBasicAuth("bob", "token1") }, "alice", true},
+		{"shared token with a claimed user", "ops", "alice:token1", func(r *http.Request) { r.SetBasicAuth("alice", "ops") }, "", true},
+		{"same token of users", "", "alice:token1,bob:token1", func(r *http.Request) { r.SetBasicAuth("bob", "token1") }, "bob", true},
+		{"invalid token", "ops", "alice:token1", func(r *http.Request) { r.SetBasicAuth("alice", "token2") }, "", false},
+		{"no token", "ops", "", func(r *http.Request) {}, "", false},
+		{"only users", "", "alice:token1", func(r *http.Request) {}, "", false},
+	}
+	gin.SetMode(gin.TestMode)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			GlobalConfig.Server = ServerOptions{Token: tt.token, Users: tt.users}
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodPost, "/api/v1/namespaces/ops-system/pipelineruns/run/approve", nil)
+			tt.auth(c.Request)
+			user, ok := Authenticate(c)
+			if user != tt.wantUser || ok != tt.wantOk {
+				t.Errorf("Authenticate() = %q, %v, want %q, %v", user, ok, tt.wantUser, tt.wantOk)
+			}
+		})
+	}
+}
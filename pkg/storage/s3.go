@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"bytes"
 	"errors"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
@@ -9,6 +10,7 @@ import (
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/shaowenchen/ops/pkg/option"
 	"github.com/shaowenchen/ops/pkg/utils"
+	"io"
 	"os"
 )
 
@@ -90,3 +92,37 @@ func S3Download(ak, sk, region, endpoint, bucket, localFilePath, remoteFile stri
 	output = "success download s3 " + remoteFile + " to " + localFilePath
 	return
 }
+
+// S3PutObject writes data to the key of bucket
+func S3PutObject(ak, sk, region, endpoint, bucket, key string, data []byte) (err error) {
+	_, err = s3.New(newS3Session(ak, sk, region, endpoint)).PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(data),
+	})
+	return
+}
+
+// S3GetObject reads the data of the key of bucket
+func S3GetObject(ak, sk, region, endpoint, bucket, key string) (data []byte, err error) {
+	result, err := s3.New(newS3Session(ak, sk, region, endpoint)).GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return
+	}
+	defer result.Body.Close()
+	return io.ReadAll(result.Body)
+}
+
+func newS3Session(ak, sk, region, endpoint string) *session.Session {
+	sess, _ := session.NewSession(&aws.Config{
+		Credentials:      credentials.NewStaticCredentials(ak, sk, ""),
+		Endpoint:         aws.String(endpoint),
+		Region:           aws.String(region),
+		DisableSSL:       aws.Bool(true),
+		S3ForcePathStyle: aws.Bool(false),
+	})
+	return sess
+}
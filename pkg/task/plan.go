@@ -0,0 +1,73 @@
+package task
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	opsv1 "github.com/shaowenchen/ops/api/v1"
+	opsconstants "github.com/shaowenchen/ops/pkg/constants"
+	opsexpr "github.com/shaowenchen/ops/pkg/expr"
+	opslog "github.com/shaowenchen/ops/pkg/log"
+	"github.com/shaowenchen/ops/pkg/option"
+	opsrender "github.com/shaowenchen/ops/pkg/render"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// PlanTask records the rendered steps of t on a node of tr without running them, the status of the node is Planned
+// A step is Skipped if its when is false, a when referencing the output or status of steps is only known
+// after running, so the step is Planned. The status of the node is Failed if the task can not be rendered
+func PlanTask(logger *opslog.Logger, t *opsv1.Task, tr *opsv1.TaskRun, nodeName string, taskOpt option.TaskOption) (err error) {
+	nodeStatus := &opsv1.TaskRunNodeStatus{
+		NodeName:  nodeName,
+		RunStatus: opsconstants.StatusPlanned,
+		StartTime: &metav1.Time{Time: time.Now()},
+	}
+	tr.Status.SetNodeStatus(nodeName, nodeStatus)
+	defer func() {
+		if err != nil {
+			nodeStatus.RunStatus = opsconstants.StatusFailed
+		}
+	}()
+	allVars, err := GetRealVariables(t, taskOpt)
+	if err != nil {
+		return err
+	}
+	logger.Debug.Println("> Plan Task", t.GetUniqueKey(), "on", nodeName)
+	for si, s := range t.Spec.Steps {
+		var sp = &s
+		sp = RenderStepVariablesWithStepRefs(sp, allVars, nil)
+		logger.Debug.Println(fmt.Sprintf("(%d/%d) %s", si+1, len(t.Spec.Steps), s.Name))
+		stepStatus := opsconstants.StatusPlanned
+		if IsStaticExpression(s.When) {
+			result, err := opsexpr.EvalBool(s.When, true, NewStepResolver(allVars, nil))
+			if err != nil {
+				logger.Error.Println(err)
+				return err
+			}
+			if !result {
+				logger.Debug.Println("Skip!")
+				stepStatus = opsconstants.StatusSkipped
+			}
+		}
+		step := &opsv1.TaskRunStep{
+			StepName:   s.Name,
+			Command:    stepCommand(s),
+			StepStatus: stepStatus,
+		}
+		maskStep(step, taskOpt.Secrets)
+		nodeStatus.TaskRunStep = append(nodeStatus.TaskRunStep, step)
+	}
+	return nil
+}
+
+// IsStaticExpression returns true if exp does not reference the output or status of steps, so it can be evaluated before running
+func IsStaticExpression(exp string) bool {
+	for _, name := range opsrender.References(exp) {
+		switch {
+		case name == "output", name == "result", name == "status", strings.HasPrefix(name, "steps."):
+			return false
+		}
+	}
+	return true
+}
@@ -1,16 +1,24 @@
 package task
 
 import (
-	"fmt"
-
+	"context"
 	"errors"
+	"fmt"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
 
 	opsv1 "github.com/shaowenchen/ops/api/v1"
+	opsexpr "github.com/shaowenchen/ops/pkg/expr"
 	"github.com/shaowenchen/ops/pkg/option"
+	opsrender "github.com/shaowenchen/ops/pkg/render"
 	"github.com/shaowenchen/ops/pkg/utils"
 	"gopkg.in/yaml.v3"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	runtimeClient "sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 func GetRealVariables(t *opsv1.Task, taskOpt option.TaskOption) (map[string]string, error) {
@@ -30,6 +38,86 @@ func GetRealVariables(t *opsv1.Task, taskOpt option.TaskOption) (map[string]stri
 	return globalVariables, nil
 }
 
+// GetSecretVariables returns the names of the variables of a task resolved from Secrets
+func GetSecretVariables(t *opsv1.Task, values map[string]string) (names []string) {
+	for k, v := range t.Spec.Variables {
+		if _, ok := values[k]; ok && v.ValueFrom != nil && v.ValueFrom.SecretKeyRef != nil {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+	return
+}
+
+// ResolveValueFrom reads the variables with valueFrom of a task from Secrets and ConfigMaps in namespace
+// A variable already set in vars is kept, the values from Secrets are returned as secrets to be masked
+func ResolveValueFrom(ctx context.Context, client runtimeClient.Client, namespace string, t *opsv1.Task, vars map[string]string) (values map[string]string, secrets []string, err error) {
+	values = make(map[string]string)
+	for k, v := range t.Spec.Variables {
+		if v.ValueFrom == nil || strings.TrimSpace(vars[k]) != "" {
+			continue
+		}
+		var value string
+		var found, isSecret bool
+		switch {
+		case v.ValueFrom.SecretKeyRef != nil:
+			ref := v.ValueFrom.SecretKeyRef
+			value, found, err = getSecretKey(ctx, client, namespace, ref.Name, ref.Key)
+			isSecret = true
+			if err == nil && !found && (ref.Optional == nil || !*ref.Optional) {
+				err = fmt.Errorf("key %s of secret %s/%s not found", ref.Key, namespace, ref.Name)
+			}
+		case v.ValueFrom.ConfigMapKeyRef != nil:
+			ref := v.ValueFrom.ConfigMapKeyRef
+			value, found, err = getConfigMapKey(ctx, client, namespace, ref.Name, ref.Key)
+			if err == nil && !found && (ref.Optional == nil || !*ref.Optional) {
+				err = fmt.Errorf("key %s of configmap %s/%s not found", ref.Key, namespace, ref.Name)
+			}
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get variable %s: %v", k, err)
+		}
+		if !found {
+			continue
+		}
+		values[k] = value
+		if isSecret {
+			// the value is often written with a newline at the end
+			secrets = append(secrets, value)
+			if trimmed := strings.TrimSpace(value); trimmed != value {
+				secrets = append(secrets, trimmed)
+			}
+		}
+	}
+	return
+}
+
+func getSecretKey(ctx context.Context, client runtimeClient.Client, namespace, name, key string) (string, bool, error) {
+	secret := &corev1.Secret{}
+	err := client.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, secret)
+	if apierrors.IsNotFound(err) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	value, ok := secret.Data[key]
+	return string(value), ok, nil
+}
+
+func getConfigMapKey(ctx context.Context, client runtimeClient.Client, namespace, name, key string) (string, bool, error) {
+	cm := &corev1.ConfigMap{}
+	err := client.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, cm)
+	if apierrors.IsNotFound(err) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	value, ok := cm.Data[key]
+	return value, ok, nil
+}
+
 func RenderTask(t *opsv1.Task, allVars map[string]string) (*opsv1.Task, error) {
 	for i, s := range t.Spec.Steps {
 		sp := RenderStepVariables(&s, allVars)
@@ -65,28 +153,17 @@ func RenderStepVariables(step *opsv1.Step, vars map[string]string) *opsv1.Step {
 // RenderStepVariablesWithPathRefs renders step variables with support for path references
 // taskResults: map[taskName]map[resultKey]value
 func RenderStepVariablesWithPathRefs(step *opsv1.Step, vars map[string]string, taskResults map[string]map[string]string) *opsv1.Step {
-	// replace all
-	// but in the case, result=${message} will cause error
-	// so we need to replace twice
-	// this implementation is not good
-	f := func() {
-		// First resolve path references, then regular variables
-		step.Name = RenderStringWithPathRefs(step.Name, vars, taskResults)
-		step.Content = RenderStringWithPathRefs(step.Content, vars, taskResults)
-		step.LocalFile = RenderStringWithPathRefs(step.LocalFile, vars, taskResults)
-		step.RemoteFile = RenderStringWithPathRefs(step.RemoteFile, vars, taskResults)
-	}
-	f()
-	f()
-
+	// path references and regular variables are rendered in one pass, so escaped $${} is kept as ${}
+	step.Name = RenderStringWithPathRefs(step.Name, vars, taskResults)
+	step.Content = RenderStringWithPathRefs(step.Content, vars, taskResults)
+	step.LocalFile = RenderStringWithPathRefs(step.LocalFile, vars, taskResults)
+	step.RemoteFile = RenderStringWithPathRefs(step.RemoteFile, vars, taskResults)
 	return step
 }
 
+// RenderVarsVariables renders the variables referencing other variables, see pkg/render
 func RenderVarsVariables(vars map[string]string) map[string]string {
-	for key := range vars {
-		vars[key] = RenderString(vars[key], vars)
-	}
-	return vars
+	return opsrender.RenderVars(vars)
 }
 
 // RenderTaskMount renders variables in TaskMount fields
@@ -115,50 +192,23 @@ func RenderTaskMount(mount *opsv1.TaskMount, vars map[string]string, taskResults
 	return mount
 }
 
+// RenderString renders the variables and functions in target, see pkg/render
 func RenderString(target string, vars map[string]string) string {
-	for key, value := range vars {
-		if strings.Contains(target, fmt.Sprintf(`${%s}`, key)) {
-			target = strings.ReplaceAll(target, fmt.Sprintf(`${%s}`, key), value)
-		}
-	}
-	return target
+	rendered, _ := opsrender.Render(target, opsrender.MapResolver(vars))
+	return rendered
 }
 
 // ExtractVariableReferences extracts variable references from a string
-// Returns a set of variable names referenced in the format ${varName}
+// Returns a set of variable names referenced in the format ${varName}, eg: varName in ${varName | default "x"}
+// Path references like ${tasks.xxx.results.yyy} and ${steps.xxx.output} are excluded
 func ExtractVariableReferences(target string) map[string]bool {
 	variables := make(map[string]bool)
-	if target == "" {
-		return variables
-	}
-
-	// Pattern to match ${varName}
-	// This regex matches ${...} but excludes path references like ${tasks.xxx.results.yyy} and ${steps.xxx.output}
-	start := 0
-	for {
-		idx := strings.Index(target[start:], "${")
-		if idx == -1 {
-			break
-		}
-		idx += start
-		// Find the closing }
-		endIdx := strings.Index(target[idx:], "}")
-		if endIdx == -1 {
-			break
-		}
-		endIdx += idx
-
-		// Extract the variable reference
-		varRef := target[idx+2 : endIdx] // +2 to skip "${"
-
+	for _, varRef := range opsrender.References(target) {
 		// Skip path references (tasks.xxx.results.yyy, steps.xxx.output)
 		if !strings.Contains(varRef, ".") {
 			variables[varRef] = true
 		}
-
-		start = endIdx + 1
 	}
-
 	return variables
 }
 
@@ -210,6 +260,7 @@ func GetTaskRequiredVariables(t *opsv1.Task) map[string]bool {
 }
 
 // ResolvePathReference resolves path references like tasks.{taskName}.results.{resultKey}
+// resultKey can be a node-keyed result {resultKey}.{node}, or reduce the values of all nodes with {resultKey}[reducer]
 // Returns the resolved value and true if the reference was found, empty string and false otherwise
 func ResolvePathReference(pathRef string, taskResults map[string]map[string]string) (string, bool) {
 	// Format: tasks.{taskName}.results.{resultKey}
@@ -217,7 +268,8 @@ func ResolvePathReference(pathRef string, taskResults map[string]map[string]stri
 		return "", false
 	}
 
-	parts := strings.Split(pathRef, ".")
+	// node names may contain dots, eg: 1.1.1.1, so the rest is the result key
+	parts := strings.SplitN(pathRef, ".", 4)
 	if len(parts) != 4 || parts[0] != "tasks" || parts[2] != "results" {
 		return "", false
 	}
@@ -233,51 +285,93 @@ func ResolvePathReference(pathRef string, taskResults map[string]map[string]stri
 		if value, ok := results[resultKey]; ok {
 			return value, true
 		}
+		return reduceResult(results, resultKey)
 	}
 
 	return "", false
 }
 
-// RenderStringWithPathRefs renders string with both regular variables and path references
-// taskResults: map[taskName]map[resultKey]value
-func RenderStringWithPathRefs(target string, vars map[string]string, taskResults map[string]map[string]string) string {
-	// First, resolve path references (tasks.{taskName}.results.{resultKey})
-	// Use a loop to handle nested references
-	maxIterations := 10
-	for i := 0; i < maxIterations; i++ {
-		replaced := false
-		// Find all path references in the format ${tasks.xxx.results.yyy}
-		start := 0
-		for {
-			idx := strings.Index(target[start:], "${tasks.")
-			if idx == -1 {
-				break
-			}
-			idx += start
-			// Find the closing }
-			endIdx := strings.Index(target[idx:], "}")
-			if endIdx == -1 {
-				break
+// reducers of a result over all nodes, eg: ${tasks.check.results.healthy[all]}
+const (
+	resultReducerList  = "*"     // values joined by comma in order of node name
+	resultReducerFirst = "first" // value of the first node
+	resultReducerCount = "count" // count of nodes with a true value
+	resultReducerAny   = "any"   // true if any value is true
+	resultReducerAll   = "all"   // true if all values are true
+)
+
+// reduceResult resolves {resultKey}[reducer] with the values of a result on all nodes
+func reduceResult(results map[string]string, resultKey string) (string, bool) {
+	open := strings.LastIndex(resultKey, "[")
+	if open <= 0 || !strings.HasSuffix(resultKey, "]") {
+		return "", false
+	}
+	key, reducer := resultKey[:open], resultKey[open+1:len(resultKey)-1]
+	values, ok := getResultValues(results, key)
+	if !ok {
+		return "", false
+	}
+	switch reducer {
+	case resultReducerList:
+		return strings.Join(values, ","), true
+	case resultReducerFirst:
+		return values[0], true
+	case resultReducerCount:
+		count := 0
+		for _, v := range values {
+			if isTrueResult(v) {
+				count++
 			}
-			endIdx += idx
-
-			// Extract the path reference
-			pathRef := target[idx+2 : endIdx] // +2 to skip "${"
-			if value, found := ResolvePathReference(pathRef, taskResults); found {
-				target = target[:idx] + value + target[endIdx+1:]
-				replaced = true
-				start = idx + len(value)
-			} else {
-				start = endIdx + 1
+		}
+		return strconv.Itoa(count), true
+	case resultReducerAny, resultReducerAll:
+		for _, v := range values {
+			if isTrueResult(v) == (reducer == resultReducerAny) {
+				return strconv.FormatBool(reducer == resultReducerAny), true
 			}
 		}
-		if !replaced {
-			break
+		return strconv.FormatBool(reducer == resultReducerAll), true
+	}
+	return "", false
+}
+
+// getResultValues returns the node-keyed values of a result in order of node name
+// Falls back to the values of a matrix task, or the single value of the result
+func getResultValues(results map[string]string, key string) ([]string, bool) {
+	nodes := []string{}
+	for k := range results {
+		if strings.HasPrefix(k, key+".") {
+			nodes = append(nodes, k)
 		}
 	}
+	if len(nodes) > 0 {
+		sort.Strings(nodes)
+		values := make([]string, 0, len(nodes))
+		for _, k := range nodes {
+			values = append(values, results[k])
+		}
+		return values, true
+	}
+	if value, ok := results[key+"["+resultReducerList+"]"]; ok {
+		return strings.Split(value, ","), true
+	}
+	if value, ok := results[key]; ok {
+		return []string{value}, true
+	}
+	return nil, false
+}
+
+// isTrueResult returns true if a result value means true
+func isTrueResult(value string) bool {
+	b, err := strconv.ParseBool(strings.TrimSpace(value))
+	return err == nil && b
+}
 
-	// Then, resolve regular variables
-	return RenderString(target, vars)
+// RenderStringWithPathRefs renders string with both regular variables and path references
+// taskResults: map[taskName]map[resultKey]value
+func RenderStringWithPathRefs(target string, vars map[string]string, taskResults map[string]map[string]string) string {
+	rendered, _ := opsrender.Render(target, opsrender.Resolver(NewPathResolver(vars, taskResults)))
+	return rendered
 }
 
 // ResolveStepReference resolves step references like steps.{stepName}.output
@@ -309,60 +403,39 @@ func ResolveStepReference(stepRef string, stepOutputs map[string]string) (string
 // RenderStringWithStepRefs renders string with both regular variables and step references
 // stepOutputs: map[stepName]output
 func RenderStringWithStepRefs(target string, vars map[string]string, stepOutputs map[string]string) string {
-	// First, resolve step references (steps.{stepName}.output)
-	maxIterations := 10
-	for i := 0; i < maxIterations; i++ {
-		replaced := false
-		// Find all step references in the format ${steps.xxx.output}
-		start := 0
-		for {
-			idx := strings.Index(target[start:], "${steps.")
-			if idx == -1 {
-				break
-			}
-			idx += start
-			// Find the closing }
-			endIdx := strings.Index(target[idx:], "}")
-			if endIdx == -1 {
-				break
-			}
-			endIdx += idx
-
-			// Extract the step reference
-			stepRef := target[idx+2 : endIdx] // +2 to skip "${"
-			if value, found := ResolveStepReference(stepRef, stepOutputs); found {
-				target = target[:idx] + value + target[endIdx+1:]
-				replaced = true
-				start = idx + len(value)
-			} else {
-				start = endIdx + 1
-			}
-		}
-		if !replaced {
-			break
-		}
-	}
-
-	// Then, resolve regular variables
-	return RenderString(target, vars)
+	rendered, _ := opsrender.Render(target, opsrender.Resolver(NewStepResolver(vars, stepOutputs)))
+	return rendered
 }
 
 // RenderStepVariablesWithStepRefs renders step variables with support for step references
 // stepOutputs: map[stepName]output
 func RenderStepVariablesWithStepRefs(step *opsv1.Step, vars map[string]string, stepOutputs map[string]string) *opsv1.Step {
-	// replace all
-	// but in the case, result=${message} will cause error
-	// so we need to replace twice
-	// this implementation is not good
-	f := func() {
-		// First resolve step references, then regular variables
-		step.Name = RenderStringWithStepRefs(step.Name, vars, stepOutputs)
-		step.Content = RenderStringWithStepRefs(step.Content, vars, stepOutputs)
-		step.LocalFile = RenderStringWithStepRefs(step.LocalFile, vars, stepOutputs)
-		step.RemoteFile = RenderStringWithStepRefs(step.RemoteFile, vars, stepOutputs)
-	}
-	f()
-	f()
-
+	// step references and regular variables are rendered in one pass, so escaped $${} is kept as ${}
+	step.Name = RenderStringWithStepRefs(step.Name, vars, stepOutputs)
+	step.Content = RenderStringWithStepRefs(step.Content, vars, stepOutputs)
+	step.LocalFile = RenderStringWithStepRefs(step.LocalFile, vars, stepOutputs)
+	step.RemoteFile = RenderStringWithStepRefs(step.RemoteFile, vars, stepOutputs)
 	return step
 }
+
+// NewStepResolver resolves the variables of an expression in a step, eg: ${output} and ${steps.{stepName}.output}
+func NewStepResolver(vars map[string]string, stepOutputs map[string]string) opsexpr.Resolver {
+	return func(name string) (string, bool) {
+		if value, ok := ResolveStepReference(name, stepOutputs); ok {
+			return value, true
+		}
+		value, ok := vars[name]
+		return value, ok
+	}
+}
+
+// NewPathResolver resolves the variables of an expression in a pipeline, eg: ${tasks.{taskName}.results.{resultKey}}
+func NewPathResolver(vars map[string]string, taskResults map[string]map[string]string) opsexpr.Resolver {
+	return func(name string) (string, bool) {
+		if value, ok := ResolvePathReference(name, taskResults); ok {
+			return value, true
+		}
+		value, ok := vars[name]
+		return value, ok
+	}
+}
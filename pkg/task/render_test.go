@@ -0,0 +1,101 @@
+package task
+
+import (
+	"reflect"
+	"testing"
+
+	opsv1 "github.com/shaowenchen/ops/api/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestResolvePathReferenceReducers(t *testing.T) {
+	taskResults := map[string]map[string]string{
+		"nodes": {
+			"healthy":            "true",
+			"healthy.node2":      "false",
+			"healthy.node1":      "true",
+			"healthy.10.0.0.3":   "TRUE",
+			"disk":               "",
+			"disk.node1":         "",
+			"disk.node2":         "80",
+			"version":            "v1",
+			"version.node1":      "v1",
+			"unhealthy.node1":    "false",
+			"unhealthy.node2":    "no",
+			"allhealthy.node1":   "true",
+			"allhealthy.node2":   " true ",
+			"allhealthy.node3":   "1",
+			"allhealthy.1.1.1.1": "t",
+		},
+		"matrix": {
+			"healthy[*]": "true,false,true",
+		},
+		"single": {
+			"healthy": "true",
+		},
+	}
+	tests := []struct {
+		ref    string
+		want   string
+		wantOk bool
+	}{
+		// the values of nodes are in order of node name
+		{"tasks.nodes.results.healthy", "true", true},
+		{"tasks.nodes.results.healthy.node2", "false", true},
+		{"tasks.nodes.results.healthy.10.0.0.3", "TRUE", true},
+		{"tasks.nodes.results.healthy[*]", "TRUE,true,false", true},
+		{"tasks.nodes.results.disk[*]", ",80", true},
+		{"tasks.nodes.results.version[*]", "v1", true},
+		// first
+		{"tasks.nodes.results.healthy[first]", "TRUE", true},
+		{"tasks.nodes.results.disk[first]", "", true},
+		{"tasks.single.results.healthy[first]", "true", true},
+		// count of true values
+		{"tasks.nodes.results.healthy[count]", "2", true},
+		{"tasks.nodes.results.disk[count]", "0", true},
+		{"tasks.nodes.results.unhealthy[count]", "0", true},
+		{"tasks.nodes.results.allhealthy[count]", "4", true},
+		{"tasks.matrix.results.healthy[count]", "2", true},
+		{"tasks.single.results.healthy[count]", "1", true},
+		// any
+		{"tasks.nodes.results.healthy[any]", "true", true},
+		{"tasks.nodes.results.unhealthy[any]", "false", true},
+		{"tasks.nodes.results.disk[any]", "false", true},
+		{"tasks.matrix.results.healthy[any]", "true", true},
+		// all
+		{"tasks.nodes.results.healthy[all]", "false", true},
+		{"tasks.nodes.results.allhealthy[all]", "true", true},
+		{"tasks.nodes.results.unhealthy[all]", "false", true},
+		{"tasks.matrix.results.healthy[all]", "false", true},
+		{"tasks.single.results.healthy[all]", "true", true},
+		// unknown reducers, results and tasks
+		{"tasks.nodes.results.healthy[max]", "", false},
+		{"tasks.nodes.results.missing[count]", "", false},
+		{"tasks.nodes.results.[count]", "", false},
+		{"tasks.missing.results.healthy[all]", "", false},
+		{"tasks.nodes.healthy", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			got, ok := ResolvePathReference(tt.ref, taskResults)
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("ResolvePathReference(%q) = %q, %v, want %q, %v", tt.ref, got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestGetSecretVariables(t *testing.T) {
+	task := &opsv1.Task{Spec: opsv1.TaskSpec{Variables: opsv1.Variables{
+		"token":    {ValueFrom: &opsv1.VariableSource{SecretKeyRef: &corev1.SecretKeySelector{Key: "token"}}},
+		"password": {ValueFrom: &opsv1.VariableSource{SecretKeyRef: &corev1.SecretKeySelector{Key: "password"}}},
+		"optional": {ValueFrom: &opsv1.VariableSource{SecretKeyRef: &corev1.SecretKeySelector{Key: "optional"}}},
+		"region":   {ValueFrom: &opsv1.VariableSource{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{Key: "region"}}},
+		"name":     {Default: "ops"},
+	}}}
+	// optional is not found, so it is not resolved
+	values := map[string]string{"token": "s3cret", "password": "p", "region": "cn", "name": "ops"}
+	if got, want := GetSecretVariables(task, values), []string{"password", "token"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("GetSecretVariables() = %v, want %v", got, want)
+	}
+}
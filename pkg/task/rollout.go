@@ -0,0 +1,82 @@
+package task
+
+import (
+	"context"
+	"sync"
+
+	opsv1 "github.com/shaowenchen/ops/api/v1"
+)
+
+// Rollout controls how a task runs on many hosts
+type Rollout struct {
+	Parallelism       int
+	BatchSize         int
+	MaxFailures       *int32
+	MaxFailurePercent *int32
+}
+
+// NewRollout returns the rollout of a task, forks overrides the parallelism of the task if it is greater than 0
+func NewRollout(t *opsv1.Task, forks int) Rollout {
+	ro := Rollout{
+		Parallelism:       t.Spec.Parallelism,
+		BatchSize:         t.Spec.BatchSize,
+		MaxFailures:       t.Spec.MaxFailures,
+		MaxFailurePercent: t.Spec.MaxFailurePercent,
+	}
+	if forks > 0 {
+		ro.Parallelism = forks
+	}
+	return ro
+}
+
+func (ro Rollout) isTooManyFailed(failed, total int) bool {
+	if ro.MaxFailures != nil && failed > int(*ro.MaxFailures) {
+		return true
+	}
+	return ro.MaxFailurePercent != nil && failed*100 > int(*ro.MaxFailurePercent)*total
+}
+
+// Run calls run for the hosts 0 to total-1 batch by batch, at most Parallelism hosts at the same time
+// run returns false if the host failed. Once more hosts failed than allowed or ctx is done,
+// the hosts not started yet are skipped and returned
+func (ro Rollout) Run(ctx context.Context, total int, run func(i int) bool) (skipped []int) {
+	parallelism := max(ro.Parallelism, 1)
+	batchSize := ro.BatchSize
+	if batchSize <= 0 || batchSize > total {
+		batchSize = total
+	}
+	var mutex sync.Mutex
+	failed := 0
+	isStopped := func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return ctx.Err() != nil || ro.isTooManyFailed(failed, total)
+	}
+	for start := 0; start < total; start += batchSize {
+		end := min(start+batchSize, total)
+		slots := make(chan struct{}, parallelism)
+		var wg sync.WaitGroup
+		for i := start; i < end; i++ {
+			slots <- struct{}{}
+			if isStopped() {
+				<-slots
+				skipped = append(skipped, i)
+				continue
+			}
+			wg.Add(1)
+			go func(i int) {
+				defer func() {
+					<-slots
+					wg.Done()
+				}()
+				if !run(i) {
+					mutex.Lock()
+					failed++
+					mutex.Unlock()
+				}
+			}(i)
+		}
+		wg.Wait()
+	}
+	return
+}
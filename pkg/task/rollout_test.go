@@ -0,0 +1,176 @@
+package task
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+
+	opsv1 "github.com/shaowenchen/ops/api/v1"
+)
+
+func int32Ptr(i int32) *int32 {
+	return &i
+}
+
+func TestNewRollout(t *testing.T) {
+	task := &opsv1.Task{Spec: opsv1.TaskSpec{Parallelism: 2, BatchSize: 3, MaxFailures: int32Ptr(1)}}
+	if ro := NewRollout(task, 0); ro.Parallelism != 2 || ro.BatchSize != 3 || *ro.MaxFailures != 1 || ro.MaxFailurePercent != nil {
+		t.Errorf("NewRollout() = %+v", ro)
+	}
+	if ro := NewRollout(task, 5); ro.Parallelism != 5 {
+		t.Errorf("NewRollout() with forks = %+v, want parallelism 5", ro)
+	}
+}
+
+func TestRolloutRunsBatchesInOrder(t *testing.T) {
+	ro := Rollout{Parallelism: 2, BatchSize: 3}
+	var (
+		mutex            sync.Mutex
+		events           []int // i+1 when host i starts, -(i+1) when it finishes
+		running, maxRuns int
+	)
+	skipped := ro.Run(context.TODO(), 8, func(i int) bool {
+		mutex.Lock()
+		events = append(events, i+1)
+		running++
+		maxRuns = max(maxRuns, running)
+		mutex.Unlock()
+		mutex.Lock()
+		events = append(events, -(i + 1))
+		running--
+		mutex.Unlock()
+		return true
+	})
+	if len(skipped) != 0 {
+		t.Errorf("skipped = %v, want none", skipped)
+	}
+	if maxRuns > ro.Parallelism {
+		t.Errorf("%d hosts run at the same time, want at most %d", maxRuns, ro.Parallelism)
+	}
+	// every host of a batch finishes before the next batch starts
+	finished := 0
+	for _, e := range events {
+		if e > 0 && (e-1)/ro.BatchSize*ro.BatchSize > finished {
+			t.Fatalf("host %d starts before the hosts of the previous batch finish: %v", e-1, events)
+		}
+		if e < 0 {
+			finished++
+		}
+	}
+	if finished != 8 {
+		t.Errorf("%d hosts finished, want 8", finished)
+	}
+}
+
+func TestRolloutSkipsAfterFailures(t *testing.T) {
+	tests := []struct {
+		name        string
+		rollout     Rollout
+		total       int
+		failed      map[int]bool
+		wantRun     []int
+		wantSkipped []int
+	}{
+		{
+			name:        "no limit",
+			rollout:     Rollout{},
+			total:       3,
+			failed:      map[int]bool{0: true, 1: true, 2: true},
+			wantRun:     []int{0, 1, 2},
+			wantSkipped: nil,
+		},
+		{
+			name:        "max failures",
+			rollout:     Rollout{MaxFailures: int32Ptr(1)},
+			total:       5,
+			failed:      map[int]bool{1: true, 2: true},
+			wantRun:     []int{0, 1, 2},
+			wantSkipped: []int{3, 4},
+		},
+		{
+			name:        "no failure allowed",
+			rollout:     Rollout{MaxFailures: int32Ptr(0)},
+			total:       3,
+			failed:      map[int]bool{0: true},
+			wantRun:     []int{0},
+			wantSkipped: []int{1, 2},
+		},
+		{
+			name:        "batches after the failures are skipped",
+			rollout:     Rollout{Parallelism: 2, BatchSize: 2, MaxFailures: int32Ptr(0)},
+			total:       6,
+			failed:      map[int]bool{1: true},
+			wantRun:     []int{0, 1},
+			wantSkipped: []int{2, 3, 4, 5},
+		},
+		{
+			name:        "failures under the percent",
+			rollout:     Rollout{MaxFailurePercent: int32Ptr(50)},
+			total:       4,
+			failed:      map[int]bool{0: true, 3: true},
+			wantRun:     []int{0, 1, 2, 3},
+			wantSkipped: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				mutex sync.Mutex
+				run   []int
+			)
+			skipped := tt.rollout.Run(context.TODO(), tt.total, func(i int) bool {
+				mutex.Lock()
+				defer mutex.Unlock()
+				run = append(run, i)
+				return !tt.failed[i]
+			})
+			sort.Ints(run)
+			if !reflect.DeepEqual(run, tt.wantRun) || !reflect.DeepEqual(skipped, tt.wantSkipped) {
+				t.Errorf("Run() runs %v and skips %v, want %v and %v", run, skipped, tt.wantRun, tt.wantSkipped)
+			}
+		})
+	}
+}
+
+func TestRolloutMaxFailurePercent(t *testing.T) {
+	tests := []struct {
+		percent int32
+		total   int
+		wantRun int // hosts run before stopping if all of them fail
+	}{
+		{0, 4, 1},
+		{25, 10, 3}, // 2 of 10 is not more than 25%, 3 of 10 is
+		{33, 3, 1},  // 1 of 3 is more than 33%
+		{34, 3, 2},  // 1 of 3 is not more than 34%
+		{50, 4, 3},  // 2 of 4 is not more than 50%
+		{99, 100, 100},
+		{100, 3, 3},
+	}
+	for _, tt := range tests {
+		ro := Rollout{MaxFailurePercent: int32Ptr(tt.percent)}
+		run := 0
+		skipped := ro.Run(context.TODO(), tt.total, func(i int) bool {
+			run++
+			return false
+		})
+		if run != tt.wantRun || len(skipped) != tt.total-tt.wantRun {
+			t.Errorf("%d%% of %d hosts: run %d and skipped %d, want %d and %d", tt.percent, tt.total, run, len(skipped), tt.wantRun, tt.total-tt.wantRun)
+		}
+	}
+}
+
+func TestRolloutSkipsWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+	ro := Rollout{BatchSize: 2}
+	skipped := ro.Run(ctx, 5, func(i int) bool {
+		if i == 1 {
+			cancel()
+		}
+		return true
+	})
+	if want := []int{2, 3, 4}; !reflect.DeepEqual(skipped, want) {
+		t.Errorf("skipped = %v, want %v", skipped, want)
+	}
+}
@@ -2,6 +2,7 @@ package task
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math/rand"
 	"strings"
@@ -9,6 +10,7 @@ import (
 
 	opsv1 "github.com/shaowenchen/ops/api/v1"
 	opsconstants "github.com/shaowenchen/ops/pkg/constants"
+	opsexpr "github.com/shaowenchen/ops/pkg/expr"
 	"github.com/shaowenchen/ops/pkg/host"
 	"github.com/shaowenchen/ops/pkg/kube"
 	opslog "github.com/shaowenchen/ops/pkg/log"
@@ -18,6 +20,9 @@ import (
 )
 
 func GetValidStatusError(status string, err error) string {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return opsconstants.StatusTimeout
+	}
 	if err != nil {
 		return opsconstants.StatusFailed
 	}
@@ -36,13 +41,15 @@ func RunTaskOnHost(ctx context.Context, logger *opslog.Logger, t *opsv1.Task, tr
 	stepOutputs := make(map[string]string)
 	logger.Debug.Println("> Run Task", t.GetUniqueKey(), "on", hc.Host.Spec.Address)
 	for si, s := range t.Spec.Steps {
+		// stop before next step if cancelled, eg: taskrun aborted
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 		var sp = &s
-		sp = RenderStepVariablesWithPathRefs(sp, allVars, nil)
-		// Also support steps.{stepName}.output references
+		// support variables and steps.{stepName}.output references
 		sp = RenderStepVariablesWithStepRefs(sp, allVars, stepOutputs)
 		logger.Debug.Println(fmt.Sprintf("(%d/%d) %s", si+1, len(t.Spec.Steps), s.Name))
-		s.When = RenderStringWithStepRefs(s.When, allVars, stepOutputs)
-		result, err := utils.LogicExpression(s.When, true)
+		result, err := opsexpr.EvalBool(s.When, true, NewStepResolver(allVars, stepOutputs))
 		if err != nil {
 			logger.Error.Println(err)
 			return err
@@ -55,16 +62,63 @@ func RunTaskOnHost(ctx context.Context, logger *opslog.Logger, t *opsv1.Task, tr
 			logger.Error.Println(err)
 		}
 		stepFunc := GetHostStepFunc(s)
-		stepStatus, stepOutput, stepErr := stepFunc(t, hc, s, taskOpt)
-		stepStatus = GetValidStatusError(stepStatus, stepErr)
-		tr.Status.AddOutputStep(hc.Host.Name, s.Name, s.Content, stepOutput, stepStatus)
-		// Store step output for path references
-		stepOutputs[s.Name] = strings.ReplaceAll(stepOutput, "\"", "")
-		allVars["result"] = strings.ReplaceAll(stepOutput, "\"", "")
-		allVars["output"] = strings.ReplaceAll(stepOutput, "\"", "")
-		allVars["status"] = stepStatus
-		logger.Debug.Println(stepOutput)
-		result, err = utils.LogicExpression(s.AllowFailure, false)
+		var stepStatus, stepOutput string
+		var stepErr error
+		for attempt := 1; ; attempt++ {
+			stepCtx, stepCancel := withStepTimeout(ctx, t.GetStepTimeoutSeconds(s))
+			startTime := time.Now()
+			var execResult host.ExecResult
+			stepStatus, execResult, stepErr = stepFunc(stepCtx, t, hc, s, taskOpt)
+			stepCancel()
+			stepOutput = execResult.Stdout
+			step := &opsv1.TaskRunStep{
+				StepName: s.Name,
+				Command:  stepCommand(s),
+				Stderr:   execResult.Stderr,
+			}
+			step.SetExitCode(execResult.ExitCode)
+			step.SetTimes(startTime, time.Now())
+			if errors.Is(stepErr, context.DeadlineExceeded) {
+				logger.Error.Printf("step %s timed out after %ds", s.Name, t.GetStepTimeoutSeconds(s))
+			}
+			stepStatus = GetValidStatusError(stepStatus, stepErr)
+			// Store step output for path references
+			stepOutputs[s.Name] = strings.ReplaceAll(stepOutput, "\"", "")
+			allVars["result"] = strings.ReplaceAll(stepOutput, "\"", "")
+			allVars["output"] = strings.ReplaceAll(stepOutput, "\"", "")
+			allVars["status"] = stepStatus
+			logger.Debug.Println(utils.MaskSecrets(stepOutput, taskOpt.Secrets))
+			if !s.IsRetryable() {
+				step.StepOutput, step.StepStatus = stepOutput, stepStatus
+				maskStep(step, taskOpt.Secrets)
+				tr.Status.AddStep(hc.Host.Name, step)
+				break
+			}
+			done, err := IsStepDone(s, allVars, stepOutputs)
+			if err != nil {
+				logger.Error.Println(err)
+				return err
+			}
+			if !done && attempt > s.Retries {
+				logger.Error.Printf("step %s is not done after %d attempts", s.Name, attempt)
+				if stepErr == nil {
+					stepErr = fmt.Errorf("step %s is not done after %d attempts", s.Name, attempt)
+					stepStatus = opsconstants.StatusFailed
+					allVars["status"] = stepStatus
+				}
+			}
+			step.StepOutput, step.StepStatus, step.Attempt = stepOutput, stepStatus, attempt
+			maskStep(step, taskOpt.Secrets)
+			tr.Status.AddStep(hc.Host.Name, step)
+			if done || attempt > s.Retries {
+				break
+			}
+			logger.Info.Printf("step %s is not done, run it again after %ds, %d/%d", s.Name, s.DelaySeconds, attempt, s.Retries+1)
+			if err = sleepStepDelay(ctx, s.DelaySeconds); err != nil {
+				return err
+			}
+		}
+		result, err = IsFailureAllowed(s, allVars, stepOutputs)
 		if err != nil {
 			logger.Error.Println(err)
 			return err
@@ -76,7 +130,7 @@ func RunTaskOnHost(ctx context.Context, logger *opslog.Logger, t *opsv1.Task, tr
 	return err
 }
 
-func RunTaskOnKube(logger *opslog.Logger, t *opsv1.Task, tr *opsv1.TaskRun, kc *kube.KubeConnection, node *corev1.Node, taskOpt option.TaskOption, kubeOpt option.KubeOption) error {
+func RunTaskOnKube(ctx context.Context, logger *opslog.Logger, t *opsv1.Task, tr *opsv1.TaskRun, kc *kube.KubeConnection, node *corev1.Node, taskOpt option.TaskOption, kubeOpt option.KubeOption) error {
 	allVars, err := GetRealVariables(t, taskOpt)
 	if err != nil {
 		return err
@@ -87,14 +141,16 @@ func RunTaskOnKube(logger *opslog.Logger, t *opsv1.Task, tr *opsv1.TaskRun, kc *
 
 	// Collect all steps that need to be executed
 	stepsToExecute := []opsv1.Step{}
+	// the content of steps refers to the variables from Secrets by env, so their values are not in the pod spec
+	podVars, secretEnv := kube.NewSecretEnv(allVars, taskOpt.SecretVariables)
 	for si, s := range t.Spec.Steps {
 		var sp = &s
-		sp = RenderStepVariablesWithPathRefs(sp, allVars, nil)
-		// Also support steps.{stepName}.output references
+		content := s.Content
+		// support variables and steps.{stepName}.output references
 		sp = RenderStepVariablesWithStepRefs(sp, allVars, stepOutputs)
+		sp.Content = RenderStringWithStepRefs(content, podVars, stepOutputs)
 		logger.Debug.Println(fmt.Sprintf("(%d/%d) %s", si+1, len(t.Spec.Steps), s.Name))
-		s.When = RenderStringWithStepRefs(s.When, allVars, stepOutputs)
-		result, err := utils.LogicExpression(s.When, true)
+		result, err := opsexpr.EvalBool(s.When, true, NewStepResolver(allVars, stepOutputs))
 		if err != nil {
 			logger.Error.Println(err)
 			return err
@@ -128,13 +184,14 @@ func RunTaskOnKube(logger *opslog.Logger, t *opsv1.Task, tr *opsv1.TaskRun, kc *
 	stepConfigs := []kube.StepContainerConfig{}
 	for _, s := range stepsToExecute {
 		stepConfig := kube.StepContainerConfig{
-			StepName:     s.Name,
-			Content:      s.Content,
-			LocalFile:    s.LocalFile,
-			RemoteFile:   s.RemoteFile,
-			Direction:    s.Direction,
-			RuntimeImage: s.RuntimeImage,
-			AllowFailure: s.AllowFailure,
+			StepName:       s.Name,
+			Content:        s.Content,
+			LocalFile:      s.LocalFile,
+			RemoteFile:     s.RemoteFile,
+			Direction:      s.Direction,
+			RuntimeImage:   s.RuntimeImage,
+			AllowFailure:   s.AllowFailure,
+			TimeoutSeconds: t.GetStepTimeoutSeconds(s),
 		}
 
 		// Determine mode and if it's a file step
@@ -175,37 +232,156 @@ func RunTaskOnKube(logger *opslog.Logger, t *opsv1.Task, tr *opsv1.TaskRun, kc *
 		stepConfigs = append(stepConfigs, stepConfig)
 	}
 
-	// Create pod with multiple containers (one per step)
-	namespacedName, err := utils.GetOrCreateNamespacedName(kc.Client, kubeOpt.Namespace, fmt.Sprintf("ops-task-%s-%d", time.Now().Format("2006-01-02-15-04-05"), rand.Intn(10000)))
-	if err != nil {
-		logger.Error.Println(err)
-		return err
+	// A step with retries or until ends a pod, so that it can be run again in a new pod after it is checked
+	start := 0
+	for i, s := range stepsToExecute {
+		if !s.IsRetryable() && i < len(stepsToExecute)-1 {
+			continue
+		}
+		err = runTaskStepsPod(ctx, logger, tr, kc, execNode, node.Name, s, stepConfigs[start:i+1], kubeOpt, allVars, stepOutputs, secretEnv, taskOpt.Secrets)
+		if err != nil {
+			return err
+		}
+		start = i + 1
 	}
+	return nil
+}
 
-	pod, err := kc.RunTaskStepsOnNode(execNode, namespacedName, stepConfigs, kubeOpt.RuntimeImage, kubeOpt.Mounts)
-	if err != nil {
-		logger.Error.Println(err)
-		return err
+// runTaskStepsPod runs the steps in a pod with multiple containers (one per step)
+// If the last step has retries or until, it is run again in new pods until it is done
+// The secretEnv are passed to the pod by a Secret, the secrets are masked in the steps recorded
+func runTaskStepsPod(ctx context.Context, logger *opslog.Logger, tr *opsv1.TaskRun, kc *kube.KubeConnection, execNode *corev1.Node, nodeName string, lastStep opsv1.Step, stepConfigs []kube.StepContainerConfig, kubeOpt option.KubeOption, allVars map[string]string, stepOutputs map[string]string, secretEnv map[string]string, secrets []string) error {
+	for attempt := 1; ; attempt++ {
+		if lastStep.IsRetryable() {
+			stepConfigs[len(stepConfigs)-1].Attempt = attempt
+		}
+		namespacedName, err := utils.GetOrCreateNamespacedName(kc.Client, kubeOpt.Namespace, fmt.Sprintf("ops-task-%s-%d", time.Now().Format("2006-01-02-15-04-05"), rand.Intn(10000)))
+		if err != nil {
+			logger.Error.Println(err)
+			return err
+		}
+
+		pod, err := kc.RunTaskStepsOnNode(execNode, namespacedName, stepConfigs, kubeOpt.RuntimeImage, kubeOpt.Mounts, secretEnv)
+		if err != nil {
+			logger.Error.Println(err)
+			return err
+		}
+
+		// Wait for pod to complete and collect logs from each container
+		err = kc.WaitForTaskStepsPod(ctx, logger, pod, stepConfigs, tr, nodeName, allVars, stepOutputs, NewStepResolver(allVars, stepOutputs))
+		maskNodeSteps(tr, nodeName, secrets)
+		if !lastStep.IsRetryable() || !isStepAttemptRecorded(tr, nodeName, lastStep.Name, attempt) {
+			return err
+		}
+		done, logicErr := IsStepDone(lastStep, allVars, stepOutputs)
+		if logicErr != nil {
+			logger.Error.Println(logicErr)
+			return logicErr
+		}
+		if !done && attempt > lastStep.Retries && allVars["status"] == opsconstants.StatusSuccessed {
+			logger.Error.Printf("step %s is not done after %d attempts", lastStep.Name, attempt)
+			tr.Status.SetLastStepStatus(nodeName, opsconstants.StatusFailed)
+			allVars["status"] = opsconstants.StatusFailed
+			if allowFailure, _ := IsFailureAllowed(lastStep, allVars, stepOutputs); !allowFailure {
+				err = fmt.Errorf("step %s is not done after %d attempts", lastStep.Name, attempt)
+			}
+		}
+		if done || attempt > lastStep.Retries {
+			return err
+		}
+		logger.Info.Printf("step %s is not done, run it again after %ds, %d/%d", lastStep.Name, lastStep.DelaySeconds, attempt, lastStep.Retries+1)
+		if err = sleepStepDelay(ctx, lastStep.DelaySeconds); err != nil {
+			return err
+		}
+		// the steps before it are done, only the last step runs again
+		stepConfigs = stepConfigs[len(stepConfigs)-1:]
 	}
+}
 
-	// Wait for pod to complete and collect logs from each container
-	err = kc.WaitForTaskStepsPod(logger, pod, stepConfigs, tr, node.Name, allVars, stepOutputs)
-	return err
+// isStepAttemptRecorded returns true if the last recorded step of the node is the attempt of the step
+func isStepAttemptRecorded(tr *opsv1.TaskRun, nodeName, stepName string, attempt int) bool {
+	nodeStatus, ok := tr.Status.TaskRunNodeStatus[nodeName]
+	if !ok || len(nodeStatus.TaskRunStep) == 0 {
+		return false
+	}
+	last := nodeStatus.TaskRunStep[len(nodeStatus.TaskRunStep)-1]
+	return last.StepName == stepName && last.Attempt == attempt
+}
+
+// maskStep replaces the values from Secrets in the record of a step
+func maskStep(step *opsv1.TaskRunStep, secrets []string) {
+	step.Command = utils.MaskSecrets(step.Command, secrets)
+	step.StepOutput = utils.MaskSecrets(step.StepOutput, secrets)
+	step.Stderr = utils.MaskSecrets(step.Stderr, secrets)
+}
+
+// maskNodeSteps replaces the values from Secrets in the records of the steps of a node
+func maskNodeSteps(tr *opsv1.TaskRun, nodeName string, secrets []string) {
+	nodeStatus, ok := tr.Status.TaskRunNodeStatus[nodeName]
+	if !ok || len(secrets) == 0 {
+		return
+	}
+	for _, step := range nodeStatus.TaskRunStep {
+		maskStep(step, secrets)
+	}
+}
+
+// stepCommand returns the rendered command of a step to record
+func stepCommand(s opsv1.Step) string {
+	if len(s.Content) > 0 {
+		return s.Content
+	}
+	return fmt.Sprintf("file: %s -> %s", s.LocalFile, s.RemoteFile)
+}
+
+// IsStepDone evaluates the until of a step with the ${output} and ${status} of its last attempt
+// The step is done if it succeeded when until is empty
+func IsStepDone(s opsv1.Step, allVars map[string]string, stepOutputs map[string]string) (bool, error) {
+	if s.Until == "" {
+		return allVars["status"] == opsconstants.StatusSuccessed, nil
+	}
+	return opsexpr.EvalBool(s.Until, true, NewStepResolver(allVars, stepOutputs))
+}
+
+// IsFailureAllowed evaluates the allowfailure of a step with the ${output} and ${status} of its last attempt
+// The failure is not allowed if allowfailure is empty
+func IsFailureAllowed(s opsv1.Step, allVars map[string]string, stepOutputs map[string]string) (bool, error) {
+	return opsexpr.EvalBool(s.AllowFailure, false, NewStepResolver(allVars, stepOutputs))
+}
+
+// sleepStepDelay waits delaySeconds before the next attempt of a step, it returns the error of ctx if ctx is done
+func sleepStepDelay(ctx context.Context, delaySeconds int) error {
+	timer := time.NewTimer(time.Duration(delaySeconds) * time.Second)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// withStepTimeout returns the context of a step, it is cancelled after timeoutSeconds if it is greater than 0
+func withStepTimeout(ctx context.Context, timeoutSeconds int) (context.Context, context.CancelFunc) {
+	if timeoutSeconds <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, time.Duration(timeoutSeconds)*time.Second)
 }
 
-func GetHostStepFunc(step opsv1.Step) func(t *opsv1.Task, c *host.HostConnection, step opsv1.Step, to option.TaskOption) (status string, output string, err error) {
+func GetHostStepFunc(step opsv1.Step) func(ctx context.Context, t *opsv1.Task, c *host.HostConnection, step opsv1.Step, to option.TaskOption) (status string, result host.ExecResult, err error) {
 	if len(step.Content) > 0 {
 		return runStepShellOnHost
 	}
 	return runStepFileOnHost
 }
 
-func runStepShellOnHost(t *opsv1.Task, c *host.HostConnection, step opsv1.Step, option option.TaskOption) (status, stdout string, err error) {
-	stdout, err = c.Shell(context.TODO(), option.Sudo, step.Content)
+func runStepShellOnHost(ctx context.Context, t *opsv1.Task, c *host.HostConnection, step opsv1.Step, option option.TaskOption) (status string, result host.ExecResult, err error) {
+	result, err = c.ShellWithResult(ctx, option.Sudo, step.Content, option.Secrets)
 	return
 }
 
-func runStepFileOnHost(t *opsv1.Task, c *host.HostConnection, step opsv1.Step, taskOpt option.TaskOption) (status, output string, err error) {
+func runStepFileOnHost(ctx context.Context, t *opsv1.Task, c *host.HostConnection, step opsv1.Step, taskOpt option.TaskOption) (status string, result host.ExecResult, err error) {
 	fileOpt := option.FileOption{
 		Sudo:       taskOpt.Sudo,
 		Direction:  step.Direction,
@@ -219,7 +395,9 @@ func runStepFileOnHost(t *opsv1.Task, c *host.HostConnection, step opsv1.Step, t
 		AK:         taskOpt.Variables["ak"],
 		SK:         taskOpt.Variables["sk"],
 	}
-	output, err = c.File(context.Background(), fileOpt)
+	// a file step has no exit code
+	result.ExitCode = -1
+	result.Stdout, err = c.File(ctx, fileOpt)
 	return
 }
 
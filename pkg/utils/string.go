@@ -2,9 +2,11 @@ package utils
 
 import (
 	"encoding/base64"
-	"errors"
 	"fmt"
+	"sort"
 	"strings"
+
+	"github.com/shaowenchen/ops/pkg/constants"
 )
 
 func Contains(origin, target string) bool {
@@ -49,11 +51,9 @@ func BuildBase64CmdWithExecutor(sudo bool, rawCmd string, executor string) strin
 	return fmt.Sprintf("base64 -d <<< %s | %s %s", EncodingStringToBase64(rawCmd), GetSudoString(sudo), executor)
 }
 
-func RemoveStartEndMark(raw string) string {
-	for _, item := range []string{" ", "'", "\""} {
-		raw = strings.Trim(raw, item)
-	}
-	return raw
+// BuildBase64CmdWithTimeout is BuildBase64CmdWithExecutor killing the executor after timeoutSeconds
+func BuildBase64CmdWithTimeout(sudo bool, rawCmd string, executor string, timeoutSeconds int) string {
+	return fmt.Sprintf("base64 -d <<< %s | %s timeout -k 5 %d %s", EncodingStringToBase64(rawCmd), GetSudoString(sudo), timeoutSeconds, executor)
 }
 
 func MergeMap(target map[string]string, needMerge map[string]string) map[string]string {
@@ -65,6 +65,26 @@ func MergeMap(target map[string]string, needMerge map[string]string) map[string]
 	return target
 }
 
+// MaskSecrets replaces the secrets in str with ***, longer secrets are replaced first
+func MaskSecrets(str string, secrets []string) string {
+	if len(secrets) == 0 {
+		return str
+	}
+	sorted := make([]string, 0, len(secrets))
+	for _, secret := range secrets {
+		if secret != "" {
+			sorted = append(sorted, secret)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return len(sorted[i]) > len(sorted[j])
+	})
+	for _, secret := range sorted {
+		str = strings.ReplaceAll(str, secret, constants.MaskedValue)
+	}
+	return str
+}
+
 func GetSudoString(sudo bool) string {
 	if sudo {
 		return "sudo "
@@ -81,17 +101,6 @@ func SplitDirPath(filepath string) string {
 	return filepath
 }
 
-func Logic(input string) (result bool, err error) {
-	input = strings.TrimSpace(input)
-	if input == "0" || strings.ToLower(input) == "false" || strings.ToLower(input) == "!true" {
-		return false, nil
-	}
-	if input == "1" || strings.ToLower(input) == "true" || strings.ToLower(input) == "!false" {
-		return true, nil
-	}
-	return false, errors.New("can't logic " + input)
-}
-
 func CodeBlock(code string) string {
 	return fmt.Sprintf("%s\n%s\n%s\n", strings.Repeat("\u2193", 30), code, strings.Repeat("\u2191", 30))
 }
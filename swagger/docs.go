@@ -850,6 +850,12 @@ const docTemplate = `{
                                 "type": "string"
                             }
                         }
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "render the steps on every node without running them, it returns once planned",
+                        "name": "dryRun",
+                        "in": "query"
                     }
                 ],
                 "responses": {
@@ -899,6 +905,12 @@ const docTemplate = `{
                                 "type": "string"
                             }
                         }
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "render the steps on every node without running them, it returns once planned",
+                        "name": "dryRun",
+                        "in": "query"
                     }
                 ],
                 "responses": {
@@ -943,6 +955,189 @@ const docTemplate = `{
                 }
             }
         },
+        "/api/v1/namespaces/{namespace}/pipelineruns/{pipelinerun}/approve": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "PipelineRuns"
+                ],
+                "summary": "Approve PipelineRun",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "namespace",
+                        "name": "namespace",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "pipelinerun",
+                        "name": "pipelinerun",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "task",
+                        "name": "task",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    {
+                        "description": "comment",
+                        "name": "comment",
+                        "in": "body",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK"
+                    }
+                }
+            }
+        },
+        "/api/v1/namespaces/{namespace}/pipelineruns/{pipelinerun}/cancel": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "PipelineRuns"
+                ],
+                "summary": "Cancel PipelineRun",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "namespace",
+                        "name": "namespace",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "pipelinerun",
+                        "name": "pipelinerun",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK"
+                    }
+                }
+            }
+        },
+        "/api/v1/namespaces/{namespace}/pipelineruns/{pipelinerun}/reject": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "PipelineRuns"
+                ],
+                "summary": "Reject PipelineRun",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "namespace",
+                        "name": "namespace",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "pipelinerun",
+                        "name": "pipelinerun",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "task",
+                        "name": "task",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    {
+                        "description": "comment",
+                        "name": "comment",
+                        "in": "body",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK"
+                    }
+                }
+            }
+        },
+        "/api/v1/namespaces/{namespace}/pipelineruns/{pipelinerun}/rerun": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "PipelineRuns"
+                ],
+                "summary": "Rerun PipelineRun",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "namespace",
+                        "name": "namespace",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "pipelinerun",
+                        "name": "pipelinerun",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "rerunFrom",
+                        "name": "rerunFrom",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK"
+                    }
+                }
+            }
+        },
         "/api/v1/namespaces/{namespace}/pipelines": {
             "get": {
                 "consumes": [
@@ -1211,6 +1406,12 @@ const docTemplate = `{
                                 "type": "string"
                             }
                         }
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "render the steps on every node without running them, it returns once planned",
+                        "name": "dryRun",
+                        "in": "query"
                     }
                 ],
                 "responses": {
@@ -1260,6 +1461,12 @@ const docTemplate = `{
                                 "type": "string"
                             }
                         }
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "render the steps on every node without running them, it returns once planned",
+                        "name": "dryRun",
+                        "in": "query"
                     }
                 ],
                 "responses": {
@@ -1304,6 +1511,88 @@ const docTemplate = `{
                 }
             }
         },
+        "/api/v1/namespaces/{namespace}/taskruns/{taskrun}/cancel": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "TaskRuns"
+                ],
+                "summary": "Cancel TaskRun",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "namespace",
+                        "name": "namespace",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "taskrun",
+                        "name": "taskrun",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK"
+                    }
+                }
+            }
+        },
+        "/api/v1/namespaces/{namespace}/taskruns/{taskrun}/logs": {
+            "get": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "TaskRuns"
+                ],
+                "summary": "Get TaskRun Logs",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "namespace",
+                        "name": "namespace",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "taskrun",
+                        "name": "taskrun",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "node",
+                        "name": "node",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "step",
+                        "name": "step",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK"
+                    }
+                }
+            }
+        },
         "/api/v1/namespaces/{namespace}/tasks": {
             "get": {
                 "consumes": [
@@ -1597,6 +1886,23 @@ const docTemplate = `{
                 }
             }
         },
+        "v1.ConfigMapKeySelector": {
+            "type": "object",
+            "properties": {
+                "key": {
+                    "description": "The key to select.",
+                    "type": "string"
+                },
+                "name": {
+                    "description": "Name of the referent.\nMore info: https://kubernetes.io/docs/concepts/overview/working-with-objects/names/#names\nTODO: Add other useful fields. apiVersion, kind, uid?\n+optional",
+                    "type": "string"
+                },
+                "optional": {
+                    "description": "Specify whether the ConfigMap or its key must be defined\n+optional",
+                    "type": "boolean"
+                }
+            }
+        },
         "v1.ConfigMapMount": {
             "type": "object",
             "properties": {
@@ -1987,6 +2293,13 @@ const docTemplate = `{
                     "description": "INSERT ADDITIONAL SPEC FIELDS - desired state of cluster\nImportant: Run \"make\" to regenerate code after modifying this file",
                     "type": "string"
                 },
+                "finally": {
+                    "description": "run one by one after tasks, whatever the outcome",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/v1.TaskRef"
+                    }
+                },
                 "runtimeImage": {
                     "type": "string"
                 },
@@ -1996,6 +2309,10 @@ const docTemplate = `{
                         "$ref": "#/definitions/v1.TaskRef"
                     }
                 },
+                "timeout": {
+                    "description": "max duration of tasks, eg: 30m, finally tasks still run after it",
+                    "type": "string"
+                },
                 "ttlSecondsAfterFinished": {
                     "type": "integer"
                 },
@@ -2007,6 +2324,23 @@ const docTemplate = `{
         "v1.PipelineStatus": {
             "type": "object"
         },
+        "v1.SecretKeySelector": {
+            "type": "object",
+            "properties": {
+                "key": {
+                    "description": "The key of the secret to select from.  Must be a valid secret key.",
+                    "type": "string"
+                },
+                "name": {
+                    "description": "Name of the referent.\nMore info: https://kubernetes.io/docs/concepts/overview/working-with-objects/names/#names\nTODO: Add other useful fields. apiVersion, kind, uid?\n+optional",
+                    "type": "string"
+                },
+                "optional": {
+                    "description": "Specify whether the Secret or its key must be defined\n+optional",
+                    "type": "boolean"
+                }
+            }
+        },
         "v1.SecretMount": {
             "type": "object",
             "properties": {
@@ -2027,6 +2361,10 @@ const docTemplate = `{
                 "content": {
                     "type": "string"
                 },
+                "delaySeconds": {
+                    "description": "+kubebuilder:validation:Minimum=0",
+                    "type": "integer"
+                },
                 "direction": {
                     "type": "string"
                 },
@@ -2040,12 +2378,21 @@ const docTemplate = `{
                 "remotefile": {
                     "type": "string"
                 },
+                "retries": {
+                    "description": "+kubebuilder:validation:Minimum=0",
+                    "type": "integer"
+                },
                 "runtimeImage": {
                     "type": "string"
                 },
                 "timeoutSeconds": {
+                    "description": "+kubebuilder:validation:Minimum=0",
                     "type": "integer"
                 },
+                "until": {
+                    "description": "evaluated like when after every attempt, with ${output} and ${status} of the attempt",
+                    "type": "string"
+                },
                 "when": {
                     "type": "string"
                 }
@@ -2073,6 +2420,26 @@ const docTemplate = `{
                 }
             }
         },
+        "v1.TaskApproval": {
+            "type": "object",
+            "properties": {
+                "approvers": {
+                    "description": "users allowed to approve or reject, anyone if empty, advisory as the user is claimed by the caller",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "message": {
+                    "description": "what is approved, sent with the event",
+                    "type": "string"
+                },
+                "timeout": {
+                    "description": "the gate fails if not decided in time, eg: 2h",
+                    "type": "string"
+                }
+            }
+        },
         "v1.TaskMount": {
             "type": "object",
             "properties": {
@@ -2096,21 +2463,67 @@ const docTemplate = `{
                 "allowFailure": {
                     "type": "boolean"
                 },
+                "approval": {
+                    "description": "a gate waiting for a human to approve or reject, instead of a task",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/v1.TaskApproval"
+                        }
+                    ]
+                },
                 "desc": {
                     "type": "string"
                 },
+                "matrix": {
+                    "description": "map[variable]values, runs the task once per combination, a value can be a comma-separated list like ${hosts}",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        }
+                    }
+                },
+                "maxParallel": {
+                    "description": "+kubebuilder:validation:Minimum=0",
+                    "type": "integer"
+                },
                 "name": {
                     "description": "+kubebuilder:validation:Pattern=\"^[a-z](-?[a-z0-9])*$\"",
                     "type": "string"
                 },
+                "pipelineRef": {
+                    "description": "runs another pipeline as a child PipelineRun instead of a task",
+                    "type": "string"
+                },
                 "results": {
-                    "description": "map[resultKey]stepName, defines which step outputs to export as results",
+                    "description": "map[resultKey]stepName, defines which step outputs to export as results, map[resultKey]task.result for pipelineRef",
                     "type": "object",
                     "additionalProperties": {
                         "type": "string"
                     }
                 },
+                "retries": {
+                    "description": "+kubebuilder:validation:Minimum=0",
+                    "type": "integer"
+                },
+                "retryBackoffSeconds": {
+                    "description": "+kubebuilder:validation:Minimum=0",
+                    "type": "integer"
+                },
+                "retryOn": {
+                    "description": "regular expression over the output of the failed attempt, retry any failure if empty",
+                    "type": "string"
+                },
+                "runAfter": {
+                    "description": "names of tasks that must finish before this task starts",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
                 "runAlways": {
+                    "description": "Deprecated: use finally",
                     "type": "boolean"
                 },
                 "runtimeImage": {
@@ -2118,12 +2531,24 @@ const docTemplate = `{
                 },
                 "taskRef": {
                     "type": "string"
+                },
+                "timeout": {
+                    "description": "max duration of each attempt, eg: 10m",
+                    "type": "string"
+                },
+                "when": {
+                    "description": "condition over variables and ${tasks.X.results.Y}, the task is skipped if false",
+                    "type": "string"
                 }
             }
         },
         "v1.TaskSpec": {
             "type": "object",
             "properties": {
+                "batchSize": {
+                    "description": "+kubebuilder:validation:Minimum=0",
+                    "type": "integer"
+                },
                 "desc": {
                     "description": "INSERT ADDITIONAL SPEC FIELDS - desired state of cluster\nImportant: Run \"make\" to regenerate code after modifying this file",
                     "type": "string"
@@ -2131,12 +2556,24 @@ const docTemplate = `{
                 "host": {
                     "type": "string"
                 },
+                "maxFailurePercent": {
+                    "description": "+kubebuilder:validation:Minimum=0\n+kubebuilder:validation:Maximum=100",
+                    "type": "integer"
+                },
+                "maxFailures": {
+                    "description": "+kubebuilder:validation:Minimum=0",
+                    "type": "integer"
+                },
                 "mounts": {
                     "type": "array",
                     "items": {
                         "$ref": "#/definitions/v1.TaskMount"
                     }
                 },
+                "parallelism": {
+                    "description": "+kubebuilder:validation:Minimum=0",
+                    "type": "integer"
+                },
                 "runtimeImage": {
                     "type": "string"
                 },
@@ -2146,6 +2583,10 @@ const docTemplate = `{
                         "$ref": "#/definitions/v1.Step"
                     }
                 },
+                "timeoutSeconds": {
+                    "description": "+kubebuilder:validation:Minimum=0",
+                    "type": "integer"
+                },
                 "ttlSecondsAfterFinished": {
                     "type": "integer"
                 },
@@ -2189,6 +2630,25 @@ const docTemplate = `{
                 },
                 "value": {
                     "type": "string"
+                },
+                "valueFrom": {
+                    "description": "ValueFrom reads the value from a Secret or ConfigMap in the namespace of the TaskRun when it runs",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/v1.VariableSource"
+                        }
+                    ]
+                }
+            }
+        },
+        "v1.VariableSource": {
+            "type": "object",
+            "properties": {
+                "configMapKeyRef": {
+                    "$ref": "#/definitions/v1.ConfigMapKeySelector"
+                },
+                "secretKeyRef": {
+                    "$ref": "#/definitions/v1.SecretKeySelector"
                 }
             }
         },